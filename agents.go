@@ -0,0 +1,84 @@
+package claude
+
+import "encoding/json"
+
+// taskToolName is the tool Claude uses to launch a subagent
+const taskToolName = "Task"
+
+// AgentDefinition defines a custom subagent passed to the CLI with --agents
+type AgentDefinition struct {
+	// Description tells Claude when to delegate to this agent
+	Description string `json:"description"`
+	// Prompt is the agent's system prompt
+	Prompt string `json:"prompt"`
+	// Tools restricts the agent to the listed tools; empty inherits all tools
+	Tools []string `json:"tools,omitempty"`
+	// Model selects the agent's model, e.g. "sonnet", "opus", "haiku" or "inherit"
+	Model string `json:"model,omitempty"`
+}
+
+// subagentTracker correlates ParentToolUseID values with the Task tool calls
+// that launched them so messages can be attributed to a subagent
+type subagentTracker struct {
+	// agents maps a Task tool_use ID to the subagent type it launched
+	agents map[string]string
+}
+
+func newSubagentTracker() *subagentTracker {
+	return &subagentTracker{agents: make(map[string]string)}
+}
+
+// observe records Task tool calls in msg and sets the Subagent field on
+// messages produced inside a subagent
+func (t *subagentTracker) observe(msg Message) {
+	switch m := msg.(type) {
+	case *AssistantMessage:
+		m.Subagent = t.lookup(m.ParentToolUseID)
+		for id, agent := range taskToolUses(m.Message) {
+			t.agents[id] = agent
+		}
+	case *UserMessage:
+		m.Subagent = t.lookup(m.ParentToolUseID)
+	}
+}
+
+func (t *subagentTracker) lookup(parentToolUseID *string) string {
+	if parentToolUseID == nil {
+		return ""
+	}
+	return t.agents[*parentToolUseID]
+}
+
+// taskToolUses extracts Task tool_use blocks from an assistant message payload,
+// returning a map from tool_use ID to the requested subagent type
+func taskToolUses(raw json.RawMessage) map[string]string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var payload struct {
+		Content []struct {
+			Type  string `json:"type"`
+			ID    string `json:"id"`
+			Name  string `json:"name"`
+			Input struct {
+				SubagentType string `json:"subagent_type"`
+			} `json:"input"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil
+	}
+
+	var uses map[string]string
+	for _, block := range payload.Content {
+		if block.Type != "tool_use" || block.Name != taskToolName || block.ID == "" {
+			continue
+		}
+		if uses == nil {
+			uses = make(map[string]string)
+		}
+		uses[block.ID] = block.Input.SubagentType
+	}
+	return uses
+}
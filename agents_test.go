@@ -0,0 +1,74 @@
+package claude
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTaskToolUses(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want map[string]string
+	}{
+		{
+			name: "task tool call",
+			raw:  `{"content": [{"type": "text", "text": "Delegating"}, {"type": "tool_use", "id": "toolu_1", "name": "Task", "input": {"subagent_type": "reviewer", "prompt": "Review"}}]}`,
+			want: map[string]string{"toolu_1": "reviewer"},
+		},
+		{
+			name: "other tool calls ignored",
+			raw:  `{"content": [{"type": "tool_use", "id": "toolu_2", "name": "Bash", "input": {"command": "ls"}}]}`,
+			want: nil,
+		},
+		{
+			name: "plain text payload",
+			raw:  `{"text": "hello"}`,
+			want: nil,
+		},
+		{
+			name: "empty payload",
+			raw:  ``,
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := taskToolUses(json.RawMessage(tt.raw))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("taskToolUses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubagentTracker(t *testing.T) {
+	tracker := newSubagentTracker()
+
+	launch := &AssistantMessage{
+		Type:    "assistant",
+		Message: json.RawMessage(`{"content": [{"type": "tool_use", "id": "toolu_1", "name": "Task", "input": {"subagent_type": "reviewer"}}]}`),
+	}
+	inner := &AssistantMessage{Type: "assistant", ParentToolUseID: stringPtr("toolu_1")}
+	innerUser := &UserMessage{Type: "user", ParentToolUseID: stringPtr("toolu_1")}
+	other := &AssistantMessage{Type: "assistant", ParentToolUseID: stringPtr("toolu_unknown")}
+
+	for _, msg := range []Message{launch, inner, innerUser, other} {
+		tracker.observe(msg)
+	}
+
+	if launch.Subagent != "" {
+		t.Errorf("launching message Subagent = %q, want empty", launch.Subagent)
+	}
+	if inner.Subagent != "reviewer" {
+		t.Errorf("assistant Subagent = %q, want reviewer", inner.Subagent)
+	}
+	if innerUser.Subagent != "reviewer" {
+		t.Errorf("user Subagent = %q, want reviewer", innerUser.Subagent)
+	}
+	if other.Subagent != "" {
+		t.Errorf("unrelated Subagent = %q, want empty", other.Subagent)
+	}
+}
@@ -3,6 +3,9 @@ package claude
 import (
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -10,6 +13,39 @@ import (
 // ArgumentBuilder builds command line arguments for Claude CLI
 type ArgumentBuilder struct{}
 
+// managedFlags are CLI flags the SDK sets itself and that ExtraArgs may not override
+var managedFlags = map[string]bool{
+	"print":                  true,
+	"output-format":          true,
+	"input-format":           true,
+	"verbose":                true,
+	"model":                  true,
+	"fallback-model":         true,
+	"continue":               true,
+	"resume":                 true,
+	"fork-session":           true,
+	"system-prompt":          true,
+	"append-system-prompt":   true,
+	"add-dir":                true,
+	"allowed-tools":          true,
+	"disallowed-tools":       true,
+	"max-thinking-tokens":    true,
+	"max-turns":              true,
+	"permission-mode":        true,
+	"permission-prompt-tool": true,
+	"mcp-servers":            true,
+	"agents":                 true,
+	"settings":               true,
+	"setting-sources":        true,
+}
+
+// validSettingSources lists the accepted SettingSource values
+var validSettingSources = map[SettingSource]bool{
+	SettingSourceUser:    true,
+	SettingSourceProject: true,
+	SettingSourceLocal:   true,
+}
+
 // BuildArgs constructs command line arguments from options
 func (b *ArgumentBuilder) BuildArgs(opts *Options) []string {
 	args := []string{}
@@ -21,6 +57,8 @@ func (b *ArgumentBuilder) BuildArgs(opts *Options) []string {
 	// Model configuration
 	if opts.Model != "" {
 		args = append(args, "--model", opts.Model)
+	} else if len(opts.ModelChain) > 0 {
+		args = append(args, "--model", opts.ModelChain[0])
 	}
 
 	if opts.FallbackModel != "" {
@@ -36,6 +74,10 @@ func (b *ArgumentBuilder) BuildArgs(opts *Options) []string {
 		args = append(args, "--resume", opts.Resume)
 	}
 
+	if opts.ForkSession {
+		args = append(args, "--fork-session")
+	}
+
 	// System prompt configuration
 	if opts.CustomSystemPrompt != "" {
 		args = append(args, "--system-prompt", opts.CustomSystemPrompt)
@@ -45,6 +87,11 @@ func (b *ArgumentBuilder) BuildArgs(opts *Options) []string {
 		args = append(args, "--append-system-prompt", opts.AppendSystemPrompt)
 	}
 
+	// Additional directories
+	for _, dir := range resolveAdditionalDirectories(opts.WorkingDir, opts.AdditionalDirectories) {
+		args = append(args, "--add-dir", dir)
+	}
+
 	// Tool configuration
 	if len(opts.AllowedTools) > 0 {
 		args = append(args, "--allowed-tools", strings.Join(opts.AllowedTools, ","))
@@ -69,7 +116,7 @@ func (b *ArgumentBuilder) BuildArgs(opts *Options) []string {
 	}
 
 	if opts.PermissionPromptToolName != "" {
-		args = append(args, "--permission-prompt-tool-name", opts.PermissionPromptToolName)
+		args = append(args, "--permission-prompt-tool", opts.PermissionPromptToolName)
 	}
 
 	// MCP servers (JSON format)
@@ -78,6 +125,40 @@ func (b *ArgumentBuilder) BuildArgs(opts *Options) []string {
 		args = append(args, "--mcp-servers", string(mcpConfig))
 	}
 
+	// Subagents (JSON format)
+	if len(opts.Agents) > 0 {
+		agents, _ := json.Marshal(opts.Agents)
+		args = append(args, "--agents", string(agents))
+	}
+
+	// Settings configuration
+	if opts.Settings != "" {
+		args = append(args, "--settings", opts.Settings)
+	}
+
+	if opts.SettingSources != nil {
+		sources := make([]string, len(opts.SettingSources))
+		for i, source := range opts.SettingSources {
+			sources[i] = string(source)
+		}
+		args = append(args, "--setting-sources", strings.Join(sources, ","))
+	}
+
+	// Pass-through flags, sorted for deterministic output
+	if len(opts.ExtraArgs) > 0 {
+		flags := make([]string, 0, len(opts.ExtraArgs))
+		for flag := range opts.ExtraArgs {
+			flags = append(flags, flag)
+		}
+		sort.Strings(flags)
+		for _, flag := range flags {
+			args = append(args, "--"+normalizeFlagName(flag))
+			if value := opts.ExtraArgs[flag]; value != nil {
+				args = append(args, *value)
+			}
+		}
+	}
+
 	return args
 }
 
@@ -96,6 +177,38 @@ func (b *ArgumentBuilder) Validate(opts *Options) error {
 		return &ConfigError{Field: "MaxTurns", Value: strconv.Itoa(*opts.MaxTurns), Reason: "must be non-negative"}
 	}
 
+	// Validate the model chain
+	for i, model := range opts.ModelChain {
+		if model == "" {
+			return &ConfigError{Field: fmt.Sprintf("ModelChain[%d]", i), Value: model, Reason: "cannot be empty"}
+		}
+	}
+	if len(opts.ModelChain) > 0 && opts.Model != "" && opts.Model != opts.ModelChain[0] {
+		return &ConfigError{Field: "Model", Value: opts.Model, Reason: "must be empty or match ModelChain[0] when ModelChain is set"}
+	}
+
+	// Validate additional directories
+	for i, dir := range opts.AdditionalDirectories {
+		field := fmt.Sprintf("AdditionalDirectories[%d]", i)
+		if dir == "" {
+			return &ConfigError{Field: field, Value: dir, Reason: "cannot be empty"}
+		}
+		info, err := os.Stat(resolveDirectory(opts.WorkingDir, dir))
+		if err != nil {
+			return &ConfigError{Field: field, Value: dir, Reason: "directory does not exist"}
+		}
+		if !info.IsDir() {
+			return &ConfigError{Field: field, Value: dir, Reason: "is not a directory"}
+		}
+	}
+
+	// Validate environment variable names
+	for key := range opts.Env {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			return &ConfigError{Field: fmt.Sprintf("Env[%s]", key), Value: key, Reason: "must be a non-empty name without '=' or NUL"}
+		}
+	}
+
 	// Validate MCP server configs
 	for name, server := range opts.MCPServers {
 		if server == nil {
@@ -103,18 +216,128 @@ func (b *ArgumentBuilder) Validate(opts *Options) error {
 		}
 	}
 
+	// Validate subagent definitions
+	for name, agent := range opts.Agents {
+		field := fmt.Sprintf("Agents[%s]", name)
+		if name == "" {
+			return &ConfigError{Field: field, Value: name, Reason: "agent name cannot be empty"}
+		}
+		if agent.Description == "" {
+			return &ConfigError{Field: field, Value: name, Reason: "description is required"}
+		}
+		if agent.Prompt == "" {
+			return &ConfigError{Field: field, Value: name, Reason: "prompt is required"}
+		}
+	}
+
+	// Validate settings
+	if opts.Settings != "" {
+		if isInlineSettings(opts.Settings) {
+			if !json.Valid([]byte(opts.Settings)) {
+				return &ConfigError{Field: "Settings", Value: opts.Settings, Reason: "inline settings must be valid JSON"}
+			}
+		} else if _, err := os.Stat(resolveDirectory(opts.WorkingDir, opts.Settings)); err != nil {
+			return &ConfigError{Field: "Settings", Value: opts.Settings, Reason: "settings file does not exist"}
+		}
+	}
+
+	for i, source := range opts.SettingSources {
+		if !validSettingSources[source] {
+			return &ConfigError{Field: fmt.Sprintf("SettingSources[%d]", i), Value: string(source), Reason: "must be 'user', 'project', or 'local'"}
+		}
+	}
+
+	// Validate pass-through flags
+	for flag := range opts.ExtraArgs {
+		field := fmt.Sprintf("ExtraArgs[%s]", flag)
+		name := normalizeFlagName(flag)
+		if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, " =") {
+			return &ConfigError{Field: field, Value: flag, Reason: "must be a flag name such as 'debug' or '--debug'"}
+		}
+		if managedFlags[name] {
+			return &ConfigError{Field: field, Value: flag, Reason: "flag is managed by the SDK; use the corresponding Options field"}
+		}
+	}
+
+	if opts.PermissionTimeout < 0 {
+		return &ConfigError{Field: "PermissionTimeout", Value: opts.PermissionTimeout.String(), Reason: "must not be negative"}
+	}
+	if opts.IdleTimeout < 0 {
+		return &ConfigError{Field: "IdleTimeout", Value: opts.IdleTimeout.String(), Reason: "must not be negative"}
+	}
+	if opts.TurnTimeout < 0 {
+		return &ConfigError{Field: "TurnTimeout", Value: opts.TurnTimeout.String(), Reason: "must not be negative"}
+	}
+
 	// Validate permission mode
 	if opts.PermissionMode != "" {
-		validModes := map[PermissionMode]bool{
-			PermissionDefault:           true,
-			PermissionAcceptEdits:       true,
-			PermissionBypassPermissions: true,
-			PermissionPlan:              true,
-		}
-		if !validModes[opts.PermissionMode] {
-			return &ConfigError{Field: "PermissionMode", Value: string(opts.PermissionMode), Reason: "must be 'default', 'acceptEdits', 'bypassPermissions', or 'plan'"}
+		if err := validatePermissionMode(opts.PermissionMode); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+// validPermissionModes lists the modes accepted by --permission-mode
+var validPermissionModes = map[PermissionMode]bool{
+	PermissionDefault:           true,
+	PermissionAcceptEdits:       true,
+	PermissionBypassPermissions: true,
+	PermissionPlan:              true,
+}
+
+// validatePermissionMode checks mode against validPermissionModes
+func validatePermissionMode(mode PermissionMode) error {
+	if !validPermissionModes[mode] {
+		return &ConfigError{Field: "PermissionMode", Value: string(mode), Reason: "must be 'default', 'acceptEdits', 'bypassPermissions', or 'plan'"}
+	}
+	return nil
+}
+
+// normalizeFlagName strips the leading "--" from a flag name
+func normalizeFlagName(flag string) string {
+	return strings.TrimPrefix(flag, "--")
+}
+
+// isInlineSettings reports whether settings holds inline JSON rather than a file path
+func isInlineSettings(settings string) bool {
+	return strings.HasPrefix(strings.TrimSpace(settings), "{")
+}
+
+// resolveAdditionalDirectories normalises dirs to absolute, cleaned paths and
+// drops duplicates as well as entries that name the working directory itself
+func resolveAdditionalDirectories(workingDir string, dirs []string) []string {
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	seen := map[string]bool{resolveDirectory("", workingDir): true}
+	resolved := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		abs := resolveDirectory(workingDir, dir)
+		if seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		resolved = append(resolved, abs)
+	}
+	return resolved
+}
+
+// resolveDirectory returns dir as an absolute path, resolving relative paths against base
+func resolveDirectory(base, dir string) string {
+	if dir == "" {
+		dir = "."
+	}
+	if !filepath.IsAbs(dir) && base != "" {
+		dir = filepath.Join(base, dir)
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return filepath.Clean(dir)
+}
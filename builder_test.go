@@ -1,9 +1,13 @@
 package claude
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestArgumentBuilder_BuildArgs(t *testing.T) {
@@ -46,6 +50,17 @@ func TestArgumentBuilder_BuildArgs(t *testing.T) {
 				"--resume", "session-123",
 			},
 		},
+		{
+			name: "fork session",
+			opts: &Options{
+				Resume:      "session-123",
+				ForkSession: true,
+			},
+			want: []string{
+				"--resume", "session-123",
+				"--fork-session",
+			},
+		},
 		{
 			name: "system prompt configuration",
 			opts: &Options{
@@ -87,7 +102,7 @@ func TestArgumentBuilder_BuildArgs(t *testing.T) {
 			},
 			want: []string{
 				"--permission-mode", "default",
-				"--permission-prompt-tool-name", "dangerous-tool",
+				"--permission-prompt-tool", "dangerous-tool",
 			},
 		},
 		{
@@ -127,6 +142,59 @@ func TestArgumentBuilder_BuildArgs(t *testing.T) {
 				"--mcp-servers",
 			},
 		},
+		{
+			name: "subagents JSON",
+			opts: &Options{
+				Agents: map[string]AgentDefinition{
+					"reviewer": {
+						Description: "Reviews code",
+						Prompt:      "You review code",
+						Tools:       []string{"Read", "Grep"},
+						Model:       "sonnet",
+					},
+				},
+			},
+			want: []string{
+				"--agents", `{"reviewer":{"description":"Reviews code","prompt":"You review code","tools":["Read","Grep"],"model":"sonnet"}}`,
+			},
+		},
+		{
+			name: "settings configuration",
+			opts: &Options{
+				Settings:       `{"model":"opus"}`,
+				SettingSources: []SettingSource{SettingSourceUser, SettingSourceProject},
+			},
+			want: []string{
+				"--settings", `{"model":"opus"}`,
+				"--setting-sources", "user,project",
+			},
+		},
+		{
+			name: "empty setting sources loads none",
+			opts: &Options{
+				SettingSources: []SettingSource{},
+			},
+			want: []string{
+				"--setting-sources", "",
+			},
+		},
+		{
+			name: "extra args",
+			opts: &Options{
+				Model: "claude-3-opus",
+				ExtraArgs: map[string]*string{
+					"--debug":        nil,
+					"strict-mcp":     nil,
+					"--session-name": stringPtr("nightly"),
+				},
+			},
+			want: []string{
+				"--model", "claude-3-opus",
+				"--debug",
+				"--session-name", "nightly",
+				"--strict-mcp",
+			},
+		},
 		{
 			name: "all options combined",
 			opts: &Options{
@@ -145,7 +213,7 @@ func TestArgumentBuilder_BuildArgs(t *testing.T) {
 				"--allowed-tools", "read",
 				"--max-thinking-tokens", "500",
 				"--permission-mode", "plan",
-				"--permission-prompt-tool-name", "write",
+				"--permission-prompt-tool", "write",
 			},
 		},
 		{
@@ -240,6 +308,14 @@ func TestArgumentBuilder_Validate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "MaxTurns",
 		},
+		{
+			name: "negative PermissionTimeout",
+			opts: &Options{
+				PermissionTimeout: -1,
+			},
+			wantErr: true,
+			errMsg:  "PermissionTimeout",
+		},
 		{
 			name: "invalid PermissionMode",
 			opts: &Options{
@@ -293,6 +369,106 @@ func TestArgumentBuilder_Validate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "agent without prompt",
+			opts: &Options{
+				Agents: map[string]AgentDefinition{"reviewer": {Description: "Reviews code"}},
+			},
+			wantErr: true,
+			errMsg:  "Agents[reviewer]",
+		},
+		{
+			name: "agent without description",
+			opts: &Options{
+				Agents: map[string]AgentDefinition{"reviewer": {Prompt: "You review code"}},
+			},
+			wantErr: true,
+			errMsg:  "Agents[reviewer]",
+		},
+		{
+			name: "valid agent",
+			opts: &Options{
+				Agents: map[string]AgentDefinition{"reviewer": {Description: "Reviews code", Prompt: "You review code"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "extra arg collides with --print",
+			opts: &Options{
+				ExtraArgs: map[string]*string{"--print": nil},
+			},
+			wantErr: true,
+			errMsg:  "ExtraArgs[--print]",
+		},
+		{
+			name: "extra arg collides with output-format",
+			opts: &Options{
+				ExtraArgs: map[string]*string{"output-format": stringPtr("text")},
+			},
+			wantErr: true,
+			errMsg:  "ExtraArgs[output-format]",
+		},
+		{
+			name: "malformed extra arg",
+			opts: &Options{
+				ExtraArgs: map[string]*string{"---debug": nil},
+			},
+			wantErr: true,
+			errMsg:  "ExtraArgs[---debug]",
+		},
+		{
+			name: "valid extra args",
+			opts: &Options{
+				ExtraArgs: map[string]*string{"--debug": nil, "session-name": stringPtr("x")},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid inline settings",
+			opts: &Options{
+				Settings: `{"model": `,
+			},
+			wantErr: true,
+			errMsg:  "Settings",
+		},
+		{
+			name: "missing settings file",
+			opts: &Options{
+				Settings: "/nonexistent/settings.json",
+			},
+			wantErr: true,
+			errMsg:  "Settings",
+		},
+		{
+			name: "valid inline settings",
+			opts: &Options{
+				Settings: `{"model": "opus"}`,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid setting source",
+			opts: &Options{
+				SettingSources: []SettingSource{SettingSourceUser, "global"},
+			},
+			wantErr: true,
+			errMsg:  "SettingSources[1]",
+		},
+		{
+			name: "invalid Env key",
+			opts: &Options{
+				Env: map[string]string{"BAD=KEY": "value"},
+			},
+			wantErr: true,
+			errMsg:  "Env[BAD=KEY]",
+		},
+		{
+			name: "valid Env",
+			opts: &Options{
+				Env: map[string]string{"ANTHROPIC_BASE_URL": "https://proxy.example.com"},
+			},
+			wantErr: false,
+		},
 		{
 			name: "valid MCP servers",
 			opts: &Options{
@@ -404,3 +580,113 @@ func TestArgumentBuilder_Validate_ConfigError(t *testing.T) {
 		t.Errorf("ConfigError.Reason = %v, want 'must be non-negative'", configErr.Reason)
 	}
 }
+
+func TestArgumentBuilder_AdditionalDirectories(t *testing.T) {
+	builder := &ArgumentBuilder{}
+	root := t.TempDir()
+	shared := filepath.Join(root, "shared")
+	lib := filepath.Join(root, "lib")
+
+	t.Run("normalises and deduplicates", func(t *testing.T) {
+		opts := &Options{
+			WorkingDir: filepath.Join(root, "app"),
+			AdditionalDirectories: []string{
+				"../shared",
+				shared + "/",
+				lib,
+				filepath.Join(root, "app"),
+				".",
+			},
+		}
+		got := builder.BuildArgs(opts)
+		want := []string{"--add-dir", shared, "--add-dir", lib}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("BuildArgs() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		if err := os.MkdirAll(shared, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(root, "file.txt")
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name    string
+			dirs    []string
+			wantErr string
+		}{
+			{"existing directory", []string{shared}, ""},
+			{"relative to working dir", []string{"shared"}, ""},
+			{"missing directory", []string{shared, lib}, "AdditionalDirectories[1]"},
+			{"file instead of directory", []string{file}, "AdditionalDirectories[0]"},
+			{"empty entry", []string{""}, "AdditionalDirectories[0]"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := builder.Validate(&Options{WorkingDir: root, AdditionalDirectories: tt.dirs})
+				if tt.wantErr == "" {
+					if err != nil {
+						t.Errorf("Validate() error = %v", err)
+					}
+					return
+				}
+				configErr, ok := err.(*ConfigError)
+				if !ok {
+					t.Fatalf("Validate() error = %v, want *ConfigError", err)
+				}
+				if configErr.Field != tt.wantErr {
+					t.Errorf("ConfigError.Field = %v, want %v", configErr.Field, tt.wantErr)
+				}
+			})
+		}
+	})
+}
+
+func TestArgumentBuilder_ModelChain(t *testing.T) {
+	builder := &ArgumentBuilder{}
+
+	args := builder.BuildArgs(&Options{ModelChain: []string{"opus", "sonnet"}})
+	if got := argValue(args, "--model"); got != "opus" {
+		t.Errorf("--model = %q, want opus", got)
+	}
+
+	tests := []struct {
+		name      string
+		opts      *Options
+		wantField string
+	}{
+		{name: "matching model", opts: &Options{Model: "opus", ModelChain: []string{"opus", "sonnet"}}},
+		{name: "mismatched model", opts: &Options{Model: "haiku", ModelChain: []string{"opus"}}, wantField: "Model"},
+		{name: "empty entry", opts: &Options{ModelChain: []string{"opus", ""}}, wantField: "ModelChain[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := builder.Validate(tt.opts)
+			var configErr *ConfigError
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &configErr) || configErr.Field != tt.wantField {
+				t.Errorf("Validate() error = %v, want ConfigError for %s", err, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestArgumentBuilder_ValidateTimeouts(t *testing.T) {
+	builder := &ArgumentBuilder{}
+	for _, opts := range []*Options{{IdleTimeout: -time.Second}, {TurnTimeout: -time.Second}} {
+		var configErr *ConfigError
+		if err := builder.Validate(opts); !errors.As(err, &configErr) {
+			t.Errorf("Validate(%+v) error = %v, want ConfigError", opts, err)
+		}
+	}
+}
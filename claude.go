@@ -28,6 +28,21 @@ func QueryStream(ctx context.Context, prompt string, opts *Options) (*MessageStr
 	return defaultClient.QueryStream(ctx, prompt, opts)
 }
 
+// QueryPrompt executes a query with image or document attachments and returns the result
+func QueryPrompt(ctx context.Context, prompt *Prompt, opts *Options) (*ResultMessage, error) {
+	return defaultClient.QueryPrompt(ctx, prompt, opts)
+}
+
+// QueryStreamPrompt executes a query with image or document attachments and returns a channel of messages
+func QueryStreamPrompt(ctx context.Context, prompt *Prompt, opts *Options) (*MessageStream, error) {
+	return defaultClient.QueryStreamPrompt(ctx, prompt, opts)
+}
+
+// StartSession starts a long-lived Claude Code session that takes prompts one turn at a time
+func StartSession(ctx context.Context, opts *Options) (*Session, error) {
+	return defaultClient.StartSession(ctx, opts)
+}
+
 // Exec executes a raw claude command with custom arguments
 func Exec(ctx context.Context, args []string) (*bytes.Buffer, error) {
 	cmd := exec.CommandContext(ctx, "claude", args...)
@@ -280,6 +280,22 @@ func (m *mockClaudeClient) QueryStream(ctx context.Context, prompt string, opts
 	return nil, errors.New("not implemented")
 }
 
+func (m *mockClaudeClient) QueryFor(_ context.Context, _ string, _ string, _ *Options) (*ResultMessage, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockClaudeClient) QueryPrompt(_ context.Context, _ *Prompt, _ *Options) (*ResultMessage, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockClaudeClient) QueryStreamPrompt(_ context.Context, _ *Prompt, _ *Options) (*MessageStream, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockClaudeClient) StartSession(_ context.Context, _ *Options) (*Session, error) {
+	return nil, errors.New("not implemented")
+}
+
 func TestDefaultClientInitialization(t *testing.T) {
 	// Test that defaultClient is properly initialized
 	if defaultClient == nil {
@@ -299,7 +315,7 @@ func TestPackageFunctions_Integration(t *testing.T) {
 	// We'll use a custom executor to avoid actual CLI calls
 
 	mockExecutor := &MockCommandExecutor{
-		ExecuteFunc: func(_ context.Context, _ string, _ []string, _ string, _ string) ([]byte, error) {
+		ExecuteFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) ([]byte, error) {
 			// Return a valid result
 			return []byte(`{
 				"type": "result",
@@ -308,7 +324,7 @@ func TestPackageFunctions_Integration(t *testing.T) {
 				"usage": {"input_tokens": 5, "output_tokens": 10}
 			}`), nil
 		},
-		ExecuteStreamFunc: func(_ context.Context, _ string, _ []string, _ string, _ string) (io.ReadCloser, error) {
+		ExecuteStreamFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
 			// Return a stream with messages
 			data := strings.Join([]string{
 				`{"type": "user", "message": {}, "session_id": "test"}`,
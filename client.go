@@ -3,13 +3,29 @@ package claude
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
+// missingSessionMessage is printed by the CLI when --resume names an unknown session
+const missingSessionMessage = "No conversation found"
+
 // Client interface for Claude Code interaction
 type Client interface {
 	Query(ctx context.Context, prompt string, opts *Options) (*ResultMessage, error)
 	QueryStream(ctx context.Context, prompt string, opts *Options) (*MessageStream, error)
+	// QueryFor runs a query in the session mapped to key by the client's
+	// SessionStore, starting a new session when there is none
+	QueryFor(ctx context.Context, key string, prompt string, opts *Options) (*ResultMessage, error)
+	// QueryPrompt and QueryStreamPrompt are Query and QueryStream for
+	// prompts with image and document attachments
+	QueryPrompt(ctx context.Context, prompt *Prompt, opts *Options) (*ResultMessage, error)
+	QueryStreamPrompt(ctx context.Context, prompt *Prompt, opts *Options) (*MessageStream, error)
+	// StartSession starts a long-lived CLI process that takes prompts one
+	// turn at a time
+	StartSession(ctx context.Context, opts *Options) (*Session, error)
 }
 
 // Compile-time check that clientImpl implements Client interface
@@ -17,9 +33,97 @@ var _ Client = (*clientImpl)(nil)
 
 // clientImpl implements the Client interface
 type clientImpl struct {
-	executor CommandExecutor
-	builder  *ArgumentBuilder
-	parser   MessageParser
+	executor   CommandExecutor
+	builder    *ArgumentBuilder
+	parser     MessageParser
+	defaults   *Options
+	executable string
+	sessions   SessionStore
+	sessionTTL time.Duration
+	locks      *sessionLocker
+	pricing    *PricingRegistry
+	spend      *spendGuard
+	onFallback func(ModelFallback)
+}
+
+// ClientOption configures a Client created by NewClient
+type ClientOption func(*clientImpl)
+
+// WithDefaults sets Options applied to every call. Per-call Options are
+// merged over them field by field as described in MergeOptions.
+func WithDefaults(opts *Options) ClientOption {
+	return func(c *clientImpl) {
+		c.defaults = MergeOptions(nil, opts)
+	}
+}
+
+// WithExecutable sets the Claude CLI executable used when Options does not
+// set PathToClaudeCodeExecutable
+func WithExecutable(path string) ClientOption {
+	return func(c *clientImpl) {
+		c.executable = path
+	}
+}
+
+// WithExecutor sets the CommandExecutor used to run the CLI
+func WithExecutor(executor CommandExecutor) ClientOption {
+	return func(c *clientImpl) {
+		c.executor = executor
+	}
+}
+
+// WithSessionStore sets the SessionStore used by QueryFor. Records are
+// written with ttl; zero keeps them until deleted.
+func WithSessionStore(store SessionStore, ttl time.Duration) ClientOption {
+	return func(c *clientImpl) {
+		c.sessions = store
+		c.sessionTTL = ttl
+	}
+}
+
+// WithSessionLockPolicy sets how concurrent queries resuming the same session
+// are handled. The default, SessionLockWait, runs them one after another.
+func WithSessionLockPolicy(policy SessionLockPolicy) ClientOption {
+	return func(c *clientImpl) {
+		c.locks.policy = policy
+	}
+}
+
+// WithCrossProcessSessionLock additionally locks sessions with flock-based
+// lock files so that separate processes do not resume one session at once.
+// Lock files are created in dir, or under the Claude config dir if dir is empty.
+// On platforms without flock, sessions are only locked within the process,
+// as without this option.
+func WithCrossProcessSessionLock(dir string) ClientOption {
+	return func(c *clientImpl) {
+		c.locks.crossProcess = true
+		c.locks.lockDir = dir
+	}
+}
+
+// WithPricing sets the registry used to estimate MessageStream.Spend.
+// It defaults to DefaultPricing.
+func WithPricing(pricing *PricingRegistry) ClientOption {
+	return func(c *clientImpl) {
+		c.pricing = pricing
+	}
+}
+
+// WithLedger records the cost of every query in ledger, tagged with
+// Options.Labels. Queries are refused with a *QuotaExceededError when a
+// matching quota's spend for the current period, plus the estimated cost of
+// the prompt, would exceed its limit.
+func WithLedger(ledger Ledger, quotas ...Quota) ClientOption {
+	return func(c *clientImpl) {
+		c.spend = &spendGuard{ledger: ledger, quotas: quotas, now: time.Now}
+	}
+}
+
+// WithParser sets the MessageParser used for streamed messages
+func WithParser(parser MessageParser) ClientOption {
+	return func(c *clientImpl) {
+		c.parser = parser
+	}
 }
 
 // MessageParser interface for parsing messages
@@ -35,25 +139,78 @@ func (p *DefaultMessageParser) ParseMessage(line string) (Message, error) {
 	return ParseMessage(line)
 }
 
-// NewClient creates a new Client with default components
-func NewClient() Client {
-	return &clientImpl{
-		executor: &DefaultCommandExecutor{},
-		builder:  &ArgumentBuilder{},
-		parser:   &DefaultMessageParser{},
+// NewClient creates a new Client with default components, customised by opts
+func NewClient(opts ...ClientOption) Client {
+	c := &clientImpl{
+		executor:   &DefaultCommandExecutor{},
+		builder:    &ArgumentBuilder{},
+		parser:     &DefaultMessageParser{},
+		executable: "claude",
+		locks:      newSessionLocker(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.spend != nil {
+		c.spend.pricing = c.pricing
 	}
+	return c
 }
 
 // NewClientWithExecutor creates a new Client with a custom executor
 func NewClientWithExecutor(executor CommandExecutor) Client {
-	return &clientImpl{
-		executor: executor,
-		builder:  &ArgumentBuilder{},
-		parser:   &DefaultMessageParser{},
+	return NewClient(WithExecutor(executor))
+}
+
+// resolveOptions merges per-call options over the client defaults and validates the result
+func (c *clientImpl) resolveOptions(opts *Options) (*Options, error) {
+	if c.defaults != nil {
+		opts = MergeOptions(c.defaults, opts)
+	} else if opts == nil {
+		opts = &Options{}
+	}
+
+	// Validate options
+	if err := c.builder.Validate(opts); err != nil {
+		return nil, err
 	}
+	return opts, nil
 }
 
-// Query executes a Claude Code query and returns the result
+// executableFor returns the CLI executable to run for opts
+func (c *clientImpl) executableFor(opts *Options) string {
+	if opts.PathToClaudeCodeExecutable != "" {
+		return opts.PathToClaudeCodeExecutable
+	}
+	if c.executable != "" {
+		return c.executable
+	}
+	return "claude"
+}
+
+// QueryPrompt executes a query with a multi-part prompt and returns the result.
+// Prompts without attachments run exactly like Query.
+func (c *clientImpl) QueryPrompt(ctx context.Context, prompt *Prompt, opts *Options) (*ResultMessage, error) {
+	input, err := newPromptInput(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if input.blocks == nil {
+		return c.Query(ctx, input.text, opts)
+	}
+
+	// Attachments need stream-json input, so collect the streamed result
+	stream, err := c.queryStream(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return collectResult(stream)
+}
+
+// Query executes a Claude Code query and returns the result. If the run ends
+// in an error result, the ResultMessage is returned together with a
+// *MaxTurnsError, *ExecutionError or *BudgetError wrapping it.
 func (c *clientImpl) Query(ctx context.Context, prompt string, opts *Options) (*ResultMessage, error) {
 	if prompt == "" {
 		return nil, &ConfigError{
@@ -62,41 +219,126 @@ func (c *clientImpl) Query(ctx context.Context, prompt string, opts *Options) (*
 		}
 	}
 
-	if opts == nil {
-		opts = &Options{}
+	opts, err := c.resolveOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.ModelChain) > 0 {
+		return c.queryChain(ctx, prompt, opts)
 	}
+	return c.query(ctx, prompt, opts)
+}
 
-	// Validate options
-	if err := c.builder.Validate(opts); err != nil {
+// query runs a query with resolved options
+func (c *clientImpl) query(ctx context.Context, prompt string, opts *Options) (*ResultMessage, error) {
+	if opts.IdleTimeout > 0 || opts.TurnTimeout > 0 {
+		// Timeouts are enforced by watching the streamed messages
+		stream, err := c.streamResolved(ctx, promptInput{text: prompt}, opts, newSpendTracker(c.pricing))
+		if err != nil {
+			return nil, err
+		}
+		defer stream.Close()
+		return collectResult(stream)
+	}
+
+	reservation, err := c.spend.check(ctx, opts.Labels, opts.Model, prompt)
+	if err != nil {
 		return nil, err
 	}
+	defer reservation.release()
+	executable := c.executableFor(opts)
 
-	// Set defaults
-	executable := opts.PathToClaudeCodeExecutable
-	if executable == "" {
-		executable = "claude"
+	// Serialise use of the resumed session
+	release, err := c.locks.lockFor(ctx, opts)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
 	// Build arguments
 	args := append([]string{"--print", "--output-format", "json"}, c.builder.BuildArgs(opts)...)
 
 	// Execute command
-	output, err := c.executor.Execute(ctx, executable, args, prompt, opts.WorkingDir)
+	output, err := c.executor.Execute(ctx, executable, args, prompt, opts.WorkingDir, opts.Env)
 	if err != nil {
-		if processErr, ok := err.(*ProcessError); ok {
-			return nil, processErr
+		if ctx.Err() != nil {
+			return nil, &AbortError{Message: "query aborted", Err: ctx.Err()}
 		}
-		return nil, fmt.Errorf("failed to execute command: %w", err)
+		return nil, wrapExecError(err, opts.Env)
 	}
 
 	// Parse JSON response
 	var result ResultMessage
 	if err := json.Unmarshal(output, &result); err != nil {
 		return nil, &ParseError{
-			Line:    string(output),
+			Line:    maskEnvSecrets(string(output), opts.Env),
 			Message: fmt.Sprintf("failed to parse JSON response: %v", err),
 		}
 	}
 
-	return &result, nil
+	result.Model = opts.Model
+	if err := c.spend.record(ctx, opts.Labels, opts.Model, &result); err != nil {
+		return &result, errors.Join(resultError(&result), err)
+	}
+	return &result, resultError(&result)
+}
+
+// QueryFor executes a query in the session stored under key, resuming it when
+// a record exists and recording the resulting session ID afterwards. If the
+// stored session no longer exists in the CLI, the record is dropped and a new
+// session is started.
+func (c *clientImpl) QueryFor(ctx context.Context, key string, prompt string, opts *Options) (*ResultMessage, error) {
+	if c.sessions == nil {
+		return nil, &ConfigError{Field: "SessionStore", Message: "QueryFor requires a client created with WithSessionStore"}
+	}
+	if key == "" {
+		return nil, &ConfigError{Field: "key", Message: "key is required"}
+	}
+
+	record, err := c.sessions.Get(ctx, key)
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return nil, fmt.Errorf("failed to read session store: %w", err)
+	}
+
+	result, err := c.Query(ctx, prompt, resumeOptions(opts, record))
+	if err != nil && record != nil && isMissingSessionError(err) {
+		if err := c.sessions.Delete(ctx, key); err != nil {
+			return nil, fmt.Errorf("failed to update session store: %w", err)
+		}
+		record = nil
+		result, err = c.Query(ctx, prompt, opts)
+	}
+	if result == nil {
+		return nil, err
+	}
+
+	// Error results still belong to a resumable session
+	if result.SessionID != "" {
+		updated := &SessionRecord{Key: key, SessionID: result.SessionID}
+		if record != nil {
+			updated.Metadata = record.Metadata
+			updated.CreatedAt = record.CreatedAt
+		}
+		if putErr := c.sessions.Put(ctx, updated, c.sessionTTL); putErr != nil && err == nil {
+			err = fmt.Errorf("failed to update session store: %w", putErr)
+		}
+	}
+	return result, err
+}
+
+// resumeOptions returns opts set up to resume the session in record, if any
+func resumeOptions(opts *Options, record *SessionRecord) *Options {
+	if record == nil {
+		return opts
+	}
+	resumed := MergeOptions(nil, opts)
+	resumed.Continue = false
+	resumed.Resume = record.SessionID
+	return resumed
+}
+
+// isMissingSessionError reports whether err means the resumed session does not exist
+func isMissingSessionError(err error) bool {
+	var processErr *ProcessError
+	return errors.As(err, &processErr) && strings.Contains(processErr.Message, missingSessionMessage)
 }
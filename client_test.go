@@ -4,8 +4,12 @@ import (
 	"context"
 	"errors"
 	"io"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // MockMessageParser implements MessageParser for testing
@@ -199,7 +203,7 @@ func TestClient_Query(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockExecutor := &MockCommandExecutor{
-				ExecuteFunc: func(_ context.Context, name string, args []string, stdin string, workingDir string) ([]byte, error) {
+				ExecuteFunc: func(_ context.Context, name string, args []string, stdin string, workingDir string, _ map[string]string) ([]byte, error) {
 					// Verify executable name
 					expectedName := "claude"
 					if tt.opts != nil && tt.opts.PathToClaudeCodeExecutable != "" {
@@ -353,7 +357,7 @@ func TestClient_QueryStream(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockExecutor := &MockCommandExecutor{
-				ExecuteStreamFunc: func(_ context.Context, _ string, args []string, _ string, _ string) (io.ReadCloser, error) {
+				ExecuteStreamFunc: func(_ context.Context, _ string, args []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
 					if tt.streamErr != nil {
 						return nil, tt.streamErr
 					}
@@ -464,7 +468,7 @@ func TestClient_QueryStream_ReaderError(t *testing.T) {
 		`{"type": "assistant", "message": {"text": "response"}, "session_id": "test"}` + "\n"
 
 	mockExecutor := &MockCommandExecutor{
-		ExecuteStreamFunc: func(_ context.Context, _ string, _ []string, _ string, _ string) (io.ReadCloser, error) {
+		ExecuteStreamFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
 			return &errorReaderCloser{
 				errorAfterNReads: &errorAfterNReads{
 					data:       streamData,
@@ -574,7 +578,7 @@ func TestClient_BuildArgsIntegration(t *testing.T) {
 
 	calledWithArgs := false
 	mockExecutor := &MockCommandExecutor{
-		ExecuteFunc: func(_ context.Context, _ string, args []string, _ string, _ string) ([]byte, error) {
+		ExecuteFunc: func(_ context.Context, _ string, args []string, _ string, _ string, _ map[string]string) ([]byte, error) {
 			calledWithArgs = true
 
 			// Check that options were properly converted to args
@@ -607,3 +611,491 @@ func TestClient_BuildArgsIntegration(t *testing.T) {
 		t.Error("Execute() was not called")
 	}
 }
+
+func TestClient_Query_Env(t *testing.T) {
+	ctx := context.Background()
+	env := map[string]string{
+		"ANTHROPIC_BASE_URL": "https://proxy.example.com",
+		"ANTHROPIC_API_KEY":  "sk-ant-secret",
+	}
+
+	mockExecutor := &MockCommandExecutor{
+		ExecuteFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, gotEnv map[string]string) ([]byte, error) {
+			if !reflect.DeepEqual(gotEnv, env) {
+				t.Errorf("Execute() env = %v, want %v", gotEnv, env)
+			}
+			return nil, &ProcessError{ExitCode: 1, Message: "invalid api key sk-ant-secret"}
+		},
+	}
+
+	client := NewClientWithExecutor(mockExecutor)
+	_, err := client.Query(ctx, "test", &Options{Env: env})
+	if _, ok := err.(*ProcessError); !ok {
+		t.Fatalf("Query() error = %T, want *ProcessError", err)
+	}
+	if strings.Contains(err.Error(), "sk-ant-secret") {
+		t.Errorf("Query() error = %q, secret not masked", err.Error())
+	}
+}
+
+func TestClient_Query_EnvMaskedInParseError(t *testing.T) {
+	env := map[string]string{"ANTHROPIC_API_KEY": "sk-ant-secret"}
+	mockExecutor := &MockCommandExecutor{
+		ExecuteFunc: func(context.Context, string, []string, string, string, map[string]string) ([]byte, error) {
+			// Combined output, so stderr text ends up here
+			return []byte("warning: key sk-ant-secret rejected\n"), nil
+		},
+	}
+
+	client := NewClientWithExecutor(mockExecutor)
+	_, err := client.Query(context.Background(), "test", &Options{Env: env})
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Query() error = %v, want *ParseError", err)
+	}
+	if strings.Contains(parseErr.Line, "sk-ant-secret") {
+		t.Errorf("ParseError.Line = %q, secret not masked", parseErr.Line)
+	}
+}
+
+func TestClient_QueryStream_EnvMaskedInParseError(t *testing.T) {
+	env := map[string]string{"ANTHROPIC_API_KEY": "sk-ant-secret"}
+	mockExecutor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(context.Context, string, []string, string, string, map[string]string) (io.ReadCloser, error) {
+			return &mockReadCloser{Reader: strings.NewReader("warning: key sk-ant-secret rejected\n")}, nil
+		},
+	}
+
+	client := NewClientWithExecutor(mockExecutor)
+	stream, err := client.QueryStream(context.Background(), "test", &Options{Env: env})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	_, errs := drain(stream)
+	var parseErr *ParseError
+	if len(errs) != 1 || !errors.As(errs[0], &parseErr) {
+		t.Fatalf("stream errors = %v, want a *ParseError", errs)
+	}
+	if strings.Contains(parseErr.Error(), "sk-ant-secret") {
+		t.Errorf("ParseError = %q, secret not masked", parseErr.Error())
+	}
+}
+
+func TestClient_QueryStream_AdditionalDirectories(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	shared := filepath.Join(root, "shared")
+	if err := os.Mkdir(shared, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	mockExecutor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			data := `{"type": "system", "subtype": "init", "cwd": "` + root + `", "session_id": "test"}`
+			return &mockReadCloser{Reader: strings.NewReader(data)}, nil
+		},
+	}
+
+	client := NewClientWithExecutor(mockExecutor)
+	stream, err := client.QueryStream(ctx, "test", &Options{
+		WorkingDir:            root,
+		AdditionalDirectories: []string{"shared", shared},
+	})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+
+	var sys *InitMessage
+	for msgOrErr := range stream.Messages {
+		if msgOrErr.Err != nil {
+			t.Fatalf("unexpected stream error: %v", msgOrErr.Err)
+		}
+		sys, _ = msgOrErr.Message.(*InitMessage)
+	}
+	if sys == nil {
+		t.Fatal("QueryStream() did not yield an init message")
+	}
+
+	want := []string{root, shared}
+	if got := sys.Directories(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Directories() = %v, want %v", got, want)
+	}
+}
+
+func TestClient_QueryStream_Subagents(t *testing.T) {
+	ctx := context.Background()
+
+	streamData := strings.Join([]string{
+		`{"type": "assistant", "message": {"content": [{"type": "tool_use", "id": "toolu_1", "name": "Task", "input": {"subagent_type": "reviewer"}}]}, "session_id": "test"}`,
+		`{"type": "assistant", "message": {"content": [{"type": "text", "text": "Looks good"}]}, "parent_tool_use_id": "toolu_1", "session_id": "test"}`,
+		`{"type": "result", "subtype": "success", "session_id": "test", "usage": {"input_tokens": 1, "output_tokens": 1}}`,
+	}, "\n")
+
+	mockExecutor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(_ context.Context, _ string, args []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			hasAgents := false
+			for _, arg := range args {
+				if arg == "--agents" {
+					hasAgents = true
+				}
+			}
+			if !hasAgents {
+				t.Error("ExecuteStream() missing --agents argument")
+			}
+			return &mockReadCloser{Reader: strings.NewReader(streamData)}, nil
+		},
+	}
+
+	client := NewClientWithExecutor(mockExecutor)
+	stream, err := client.QueryStream(ctx, "review", &Options{
+		Agents: map[string]AgentDefinition{
+			"reviewer": {Description: "Reviews code", Prompt: "You review code"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+
+	var subagents []string
+	for msgOrErr := range stream.Messages {
+		if msgOrErr.Err != nil {
+			t.Fatalf("unexpected stream error: %v", msgOrErr.Err)
+		}
+		if m, ok := msgOrErr.Message.(*AssistantMessage); ok {
+			subagents = append(subagents, m.Subagent)
+		}
+	}
+
+	want := []string{"", "reviewer"}
+	if !reflect.DeepEqual(subagents, want) {
+		t.Errorf("Subagent values = %v, want %v", subagents, want)
+	}
+}
+
+func TestNewClient_Options(t *testing.T) {
+	ctx := context.Background()
+
+	var gotName string
+	var gotArgs []string
+	mockExecutor := &MockCommandExecutor{
+		ExecuteFunc: func(_ context.Context, name string, args []string, _ string, _ string, _ map[string]string) ([]byte, error) {
+			gotName = name
+			gotArgs = args
+			return []byte(`{"type": "result", "session_id": "test", "usage": {"input_tokens": 1, "output_tokens": 1}}`), nil
+		},
+	}
+
+	client := NewClient(
+		WithExecutor(mockExecutor),
+		WithExecutable("/opt/claude"),
+		WithDefaults(&Options{
+			Model:              "claude-3-sonnet",
+			AppendSystemPrompt: "Be concise",
+		}),
+	)
+
+	if _, err := client.Query(ctx, "test", &Options{Model: "claude-3-opus"}); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+
+	if gotName != "/opt/claude" {
+		t.Errorf("Execute() name = %v, want /opt/claude", gotName)
+	}
+	joined := strings.Join(gotArgs, " ")
+	if !strings.Contains(joined, "--model claude-3-opus") {
+		t.Errorf("Execute() args = %v, want per-call model", gotArgs)
+	}
+	if !strings.Contains(joined, "--append-system-prompt Be concise") {
+		t.Errorf("Execute() args = %v, want default system prompt", gotArgs)
+	}
+
+	// Per-call executable wins over the client default
+	if _, err := client.Query(ctx, "test", &Options{PathToClaudeCodeExecutable: "/usr/bin/claude"}); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if gotName != "/usr/bin/claude" {
+		t.Errorf("Execute() name = %v, want /usr/bin/claude", gotName)
+	}
+}
+
+func TestNewClient_WithParser(t *testing.T) {
+	ctx := context.Background()
+
+	parsed := 0
+	parser := &MockMessageParser{
+		ParseMessageFunc: func(line string) (Message, error) {
+			parsed++
+			return ParseMessage(line)
+		},
+	}
+	mockExecutor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			return &mockReadCloser{Reader: strings.NewReader(`{"type": "user", "session_id": "test"}`)}, nil
+		},
+	}
+
+	client := NewClient(WithExecutor(mockExecutor), WithParser(parser))
+	stream, err := client.QueryStream(ctx, "test", nil)
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	for range stream.Messages {
+	}
+
+	if parsed != 1 {
+		t.Errorf("custom parser called %d times, want 1", parsed)
+	}
+}
+
+func TestClient_QueryFor(t *testing.T) {
+	ctx := context.Background()
+	var calls [][]string
+	store := NewMemorySessionStore()
+	client := NewClient(WithExecutor(sessionExecutor(&calls)), WithSessionStore(store, time.Hour))
+
+	if _, err := client.QueryFor(ctx, "thread-1", "hello", nil); err != nil {
+		t.Fatalf("QueryFor() error = %v", err)
+	}
+	if hasArg(calls[0], "--resume") {
+		t.Errorf("first QueryFor() args = %v, want no --resume", calls[0])
+	}
+
+	record, err := store.Get(ctx, "thread-1")
+	if err != nil || record.SessionID != "session-1" {
+		t.Fatalf("store record = %+v, %v; want session-1", record, err)
+	}
+	record.Metadata = map[string]string{"user": "alice"}
+	if err := store.Put(ctx, record, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.QueryFor(ctx, "thread-1", "again", &Options{Model: "claude-3-opus"}); err != nil {
+		t.Fatalf("QueryFor() error = %v", err)
+	}
+	if argValue(calls[1], "--resume") != "session-1" || argValue(calls[1], "--model") != "claude-3-opus" {
+		t.Errorf("second QueryFor() args = %v, want resume of session-1", calls[1])
+	}
+	record, _ = store.Get(ctx, "thread-1")
+	if record.Metadata["user"] != "alice" {
+		t.Errorf("QueryFor() dropped record metadata: %+v", record)
+	}
+
+	if _, err := client.QueryFor(ctx, "thread-2", "other", nil); err != nil {
+		t.Fatalf("QueryFor() error = %v", err)
+	}
+	if hasArg(calls[2], "--resume") {
+		t.Errorf("QueryFor() for new key args = %v, want no --resume", calls[2])
+	}
+}
+
+func TestClient_QueryFor_MissingSession(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemorySessionStore()
+	if err := store.Put(ctx, &SessionRecord{Key: "thread", SessionID: "gone"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls [][]string
+	mockExecutor := &MockCommandExecutor{
+		ExecuteFunc: func(_ context.Context, _ string, args []string, _ string, _ string, _ map[string]string) ([]byte, error) {
+			calls = append(calls, args)
+			if argValue(args, "--resume") == "gone" {
+				return nil, &ProcessError{ExitCode: 1, Message: "No conversation found with session ID: gone"}
+			}
+			return []byte(`{"type": "result", "session_id": "fresh", "usage": {"input_tokens": 1, "output_tokens": 1}}`), nil
+		},
+	}
+
+	client := NewClient(WithExecutor(mockExecutor), WithSessionStore(store, 0))
+	result, err := client.QueryFor(ctx, "thread", "hello", nil)
+	if err != nil {
+		t.Fatalf("QueryFor() error = %v", err)
+	}
+	if result.SessionID != "fresh" || len(calls) != 2 {
+		t.Errorf("QueryFor() = %+v after %d calls, want fresh session after retry", result, len(calls))
+	}
+	record, _ := store.Get(ctx, "thread")
+	if record == nil || record.SessionID != "fresh" {
+		t.Errorf("store record = %+v, want fresh", record)
+	}
+}
+
+func TestClient_QueryFor_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := NewClient().QueryFor(ctx, "key", "prompt", nil); err == nil {
+		t.Error("QueryFor() without store expected error")
+	}
+
+	client := NewClient(WithSessionStore(NewMemorySessionStore(), 0))
+	_, err := client.QueryFor(ctx, "", "prompt", nil)
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("QueryFor() with empty key error = %v, want *ConfigError", err)
+	}
+}
+
+func TestClient_Query_ResultErrors(t *testing.T) {
+	ctx := context.Background()
+	output := `{"type": "result", "subtype": "error_max_turns", "is_error": true, "num_turns": 3, "session_id": "s1", "total_cost_usd": 0.25, "usage": {"input_tokens": 1, "output_tokens": 1}}`
+
+	mockExecutor := &MockCommandExecutor{
+		ExecuteFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) ([]byte, error) {
+			return []byte(output), nil
+		},
+		ExecuteStreamFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			return &mockReadCloser{Reader: strings.NewReader(output)}, nil
+		},
+	}
+	client := NewClientWithExecutor(mockExecutor)
+
+	result, err := client.Query(ctx, "test", nil)
+	if !errors.Is(err, ErrMaxTurns) {
+		t.Fatalf("Query() error = %v, want ErrMaxTurns", err)
+	}
+	if result == nil || result.SessionID != "s1" {
+		t.Errorf("Query() result = %+v, want the error result", result)
+	}
+	var maxTurns *MaxTurnsError
+	if !errors.As(err, &maxTurns) || maxTurns.TotalCostUSD() != 0.25 {
+		t.Errorf("Query() error = %#v, want *MaxTurnsError with cost", err)
+	}
+
+	stream, err := client.QueryStream(ctx, "test", nil)
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	var last MessageOrError
+	for msgOrErr := range stream.Messages {
+		last = msgOrErr
+	}
+	if _, ok := last.Message.(*ResultMessage); !ok || !errors.Is(last.Err, ErrMaxTurns) {
+		t.Errorf("stream result = %+v, want ResultMessage with ErrMaxTurns", last)
+	}
+}
+
+func TestClient_Query_Aborted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	mockExecutor := &MockCommandExecutor{
+		ExecuteFunc: func(ctx context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) ([]byte, error) {
+			cancel()
+			<-ctx.Done()
+			return nil, &ProcessError{ExitCode: -1, Message: "signal: killed"}
+		},
+	}
+	client := NewClientWithExecutor(mockExecutor)
+
+	_, err := client.Query(ctx, "test", nil)
+	var abortErr *AbortError
+	if !errors.As(err, &abortErr) {
+		t.Fatalf("Query() error = %v, want *AbortError", err)
+	}
+	if !errors.Is(err, ErrAborted) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Query() error = %v, want ErrAborted wrapping context.Canceled", err)
+	}
+}
+
+func TestClient_QueryStream_Aborted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockExecutor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(ctx context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			r, w := io.Pipe()
+			go func() {
+				_, _ = w.Write([]byte(`{"type": "system", "subtype": "init", "session_id": "s1"}` + "\n"))
+				<-ctx.Done()
+				w.CloseWithError(ctx.Err())
+			}()
+			return r, nil
+		},
+	}
+	client := NewClientWithExecutor(mockExecutor)
+
+	stream, err := client.QueryStream(ctx, "test", nil)
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+
+	first := <-stream.Messages
+	if first.Err != nil {
+		t.Fatalf("first message error = %v", first.Err)
+	}
+	cancel()
+
+	var errs []error
+	for msgOrErr := range stream.Messages {
+		errs = append(errs, msgOrErr.Err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got %d messages after cancel, want 1: %v", len(errs), errs)
+	}
+	if !errors.Is(errs[0], ErrAborted) || !errors.Is(errs[0], context.Canceled) {
+		t.Errorf("stream error = %v, want ErrAborted wrapping context.Canceled", errs[0])
+	}
+}
+
+func TestClient_QueryStream_CloseAbandonsAbort(t *testing.T) {
+	mockExecutor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(ctx context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			r, w := io.Pipe()
+			go func() {
+				<-ctx.Done()
+				w.CloseWithError(ctx.Err())
+			}()
+			return r, nil
+		},
+	}
+	client := NewClientWithExecutor(mockExecutor)
+
+	stream, err := client.QueryStream(context.Background(), "test", nil)
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	stream.Close()
+
+	// The channel is closed without the caller having to drain an abort error
+	select {
+	case <-stream.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream context was not cancelled")
+	}
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-stream.Messages:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("stream did not close after Close()")
+		}
+	}
+}
+
+func TestClient_QueryStream_ProcessError(t *testing.T) {
+	mockExecutor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			return &mockReadCloser{
+				Reader: strings.NewReader(""),
+				closeFunc: func() error {
+					return &ProcessError{ExitCode: 1, Message: "Invalid API key · Please run /login"}
+				},
+			}, nil
+		},
+	}
+	client := NewClientWithExecutor(mockExecutor)
+
+	stream, err := client.QueryStream(context.Background(), "test", nil)
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+
+	var errs []error
+	for msgOrErr := range stream.Messages {
+		errs = append(errs, msgOrErr.Err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrNotAuthenticated) {
+		t.Errorf("stream errors = %v, want one ErrNotAuthenticated", errs)
+	}
+}
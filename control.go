@@ -0,0 +1,370 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Control protocol message types and subtypes used in stream-json input mode
+const (
+	controlRequestType       = "control_request"
+	controlResponseType      = "control_response"
+	controlSubtypeCanUseTool = "can_use_tool"
+	controlSubtypeInterrupt  = "interrupt"
+	controlSubtypeSetMode    = "set_permission_mode"
+	controlSubtypeSetModel   = "set_model"
+	controlSubtypeSuccess    = "success"
+	controlSubtypeError      = "error"
+)
+
+// errNotInteractive is returned when responding on a stream without a control channel
+var errNotInteractive = errors.New("stream is not in bidirectional mode; set Options.PermissionPromptToolName to PermissionPromptToolStdio")
+
+// controlRequestEnvelope is a control request in either direction
+type controlRequestEnvelope struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id"`
+	Request   json.RawMessage `json:"request"`
+}
+
+// controlRequestBody is the body of a control request sent to the CLI
+type controlRequestBody struct {
+	Subtype string         `json:"subtype"`
+	Mode    PermissionMode `json:"mode,omitempty"`
+	// Model is nil to leave it out, which resets set_model to the default
+	Model *string `json:"model,omitempty"`
+}
+
+// canUseToolRequest is the body of a can_use_tool control request
+type canUseToolRequest struct {
+	Subtype               string             `json:"subtype"`
+	ToolName              string             `json:"tool_name"`
+	Input                 json.RawMessage    `json:"input"`
+	ToolUseID             string             `json:"tool_use_id,omitempty"`
+	PermissionSuggestions []PermissionUpdate `json:"permission_suggestions,omitempty"`
+	BlockedPath           string             `json:"blocked_path,omitempty"`
+}
+
+// controlResponseEnvelope is a control response in either direction
+type controlResponseEnvelope struct {
+	Type     string              `json:"type"`
+	Response controlResponseBody `json:"response"`
+}
+
+// controlResponseBody carries the outcome of a control request
+type controlResponseBody struct {
+	Subtype   string          `json:"subtype"`
+	RequestID string          `json:"request_id"`
+	Response  json.RawMessage `json:"response,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// userInputMessage is a user turn written to the CLI's stdin
+type userInputMessage struct {
+	Type            string           `json:"type"`
+	Message         userInputContent `json:"message"`
+	ParentToolUseID *string          `json:"parent_tool_use_id"`
+	SessionID       string           `json:"session_id"`
+}
+
+// userInputContent is the API message inside a userInputMessage
+type userInputContent struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+// controlChannel speaks the CLI's control protocol over stdin when it runs in
+// stream-json input mode. It answers permission requests, denying those that
+// are not answered within the timeout.
+type controlChannel struct {
+	timeout time.Duration
+
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+
+	mu          sync.Mutex
+	closed      bool
+	permissions map[string]*pendingPermission
+
+	// requests holds our own control requests awaiting a response
+	requests      map[string]chan controlResponseBody
+	nextRequestID int
+	failure       error
+}
+
+// pendingPermission is a permission request awaiting a decision
+type pendingPermission struct {
+	input json.RawMessage
+	timer *time.Timer
+}
+
+func newControlChannel(stdin io.WriteCloser, timeout time.Duration) *controlChannel {
+	if timeout <= 0 {
+		timeout = DefaultPermissionTimeout
+	}
+	return &controlChannel{
+		timeout:     timeout,
+		stdin:       stdin,
+		permissions: make(map[string]*pendingPermission),
+		requests:    make(map[string]chan controlResponseBody),
+	}
+}
+
+// request sends a control request to the CLI and waits for its response
+func (c *controlChannel) request(ctx context.Context, body controlRequestBody) (json.RawMessage, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s request: %w", body.Subtype, err)
+	}
+
+	c.mu.Lock()
+	if c.failure != nil {
+		err := c.failure
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.nextRequestID++
+	requestID := fmt.Sprintf("req_%d", c.nextRequestID)
+	response := make(chan controlResponseBody, 1)
+	c.requests[requestID] = response
+	c.mu.Unlock()
+
+	forget := func() {
+		c.mu.Lock()
+		delete(c.requests, requestID)
+		c.mu.Unlock()
+	}
+
+	err = c.write(controlRequestEnvelope{
+		Type:      controlRequestType,
+		RequestID: requestID,
+		Request:   encoded,
+	})
+	if err != nil {
+		forget()
+		return nil, err
+	}
+
+	select {
+	case reply, ok := <-response:
+		if !ok {
+			c.mu.Lock()
+			err := c.failure
+			c.mu.Unlock()
+			return nil, err
+		}
+		if reply.Subtype == controlSubtypeError {
+			return nil, &ControlError{Request: body.Subtype, Message: reply.Error}
+		}
+		return reply.Response, nil
+	case <-ctx.Done():
+		forget()
+		return nil, ctx.Err()
+	}
+}
+
+// deliver hands a control response to the request waiting for it
+func (c *controlChannel) deliver(body controlResponseBody) {
+	c.mu.Lock()
+	response, ok := c.requests[body.RequestID]
+	delete(c.requests, body.RequestID)
+	c.mu.Unlock()
+	if ok {
+		response <- body
+	}
+}
+
+// fail ends all waiting and future control requests with err, for use once
+// the CLI has exited
+func (c *controlChannel) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failure == nil {
+		c.failure = err
+	}
+	for requestID, response := range c.requests {
+		close(response)
+		delete(c.requests, requestID)
+	}
+}
+
+// sendUserMessage writes a user turn with the given content
+func (c *controlChannel) sendUserMessage(content any, sessionID string) error {
+	return c.write(userInputMessage{
+		Type:      "user",
+		Message:   userInputContent{Role: "user", Content: content},
+		SessionID: sessionID,
+	})
+}
+
+// track registers a permission request so it can be answered, and arms the
+// timer that denies it if no answer arrives
+func (c *controlChannel) track(req *PermissionRequestMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	requestID := req.RequestID
+	c.permissions[requestID] = &pendingPermission{
+		input: req.Input,
+		timer: time.AfterFunc(c.timeout, func() {
+			_ = c.respond(requestID, PermissionDecision{
+				Behavior: PermissionBehaviorDeny,
+				Message:  fmt.Sprintf("permission request was not answered within %s", c.timeout),
+			})
+		}),
+	}
+}
+
+// respond answers a tracked permission request
+func (c *controlChannel) respond(requestID string, decision PermissionDecision) error {
+	switch decision.Behavior {
+	case PermissionBehaviorAllow, PermissionBehaviorDeny:
+	default:
+		return &ConfigError{Field: "PermissionDecision.Behavior", Value: string(decision.Behavior), Reason: "must be 'allow' or 'deny'"}
+	}
+
+	c.mu.Lock()
+	pending, ok := c.permissions[requestID]
+	delete(c.permissions, requestID)
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no pending permission request %q", requestID)
+	}
+	pending.timer.Stop()
+
+	// The CLI requires the tool input on every allow decision
+	if decision.Behavior == PermissionBehaviorAllow && decision.UpdatedInput == nil {
+		decision.UpdatedInput = pending.input
+	}
+
+	body, err := json.Marshal(decision)
+	if err != nil {
+		return fmt.Errorf("failed to encode permission decision: %w", err)
+	}
+	return c.write(controlResponseEnvelope{
+		Type: controlResponseType,
+		Response: controlResponseBody{
+			Subtype:   controlSubtypeSuccess,
+			RequestID: requestID,
+			Response:  body,
+		},
+	})
+}
+
+// handleControlLine consumes control protocol lines so they never reach the
+// message parser. It reports whether line was consumed, and returns the
+// PermissionRequestMessage for a can_use_tool request. A nil channel consumes
+// nothing.
+func (c *controlChannel) handleControlLine(line string) (Message, bool, error) {
+	if c == nil {
+		return nil, false, nil
+	}
+	var base struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(line), &base); err != nil {
+		return nil, false, nil
+	}
+
+	switch base.Type {
+	case controlResponseType:
+		var envelope controlResponseEnvelope
+		if err := json.Unmarshal([]byte(line), &envelope); err == nil {
+			c.deliver(envelope.Response)
+		}
+		return nil, true, nil
+	case controlRequestType:
+		var envelope controlRequestEnvelope
+		if err := json.Unmarshal([]byte(line), &envelope); err != nil {
+			return nil, true, &ParseError{Line: line, Message: fmt.Sprintf("failed to parse control request: %v", err)}
+		}
+		msg, err := decodePermissionRequest(envelope)
+		if err == nil {
+			return msg, true, nil
+		}
+		// Tell the CLI we cannot serve the request rather than leave it waiting
+		_ = c.write(controlResponseEnvelope{
+			Type: controlResponseType,
+			Response: controlResponseBody{
+				Subtype:   controlSubtypeError,
+				RequestID: envelope.RequestID,
+				Error:     err.Error(),
+			},
+		})
+		if errors.Is(err, errUnsupportedControlRequest) {
+			return nil, true, nil
+		}
+		return nil, true, &ParseError{Line: line, Message: err.Error()}
+	default:
+		return nil, false, nil
+	}
+}
+
+// errUnsupportedControlRequest is returned for control requests other than can_use_tool
+var errUnsupportedControlRequest = errors.New("unsupported control request")
+
+// decodePermissionRequest decodes a can_use_tool control request
+func decodePermissionRequest(envelope controlRequestEnvelope) (*PermissionRequestMessage, error) {
+	var request canUseToolRequest
+	if err := json.Unmarshal(envelope.Request, &request); err != nil {
+		return nil, fmt.Errorf("failed to parse control request: %w", err)
+	}
+	if request.Subtype != controlSubtypeCanUseTool {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedControlRequest, request.Subtype)
+	}
+
+	return &PermissionRequestMessage{
+		Type:        "permission_request",
+		Subtype:     request.Subtype,
+		RequestID:   envelope.RequestID,
+		ToolName:    request.ToolName,
+		Input:       request.Input,
+		ToolUseID:   request.ToolUseID,
+		Suggestions: request.PermissionSuggestions,
+		BlockedPath: request.BlockedPath,
+	}, nil
+}
+
+// closeInput closes stdin so the CLI exits once it has finished
+func (c *controlChannel) closeInput() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	for requestID, pending := range c.permissions {
+		pending.timer.Stop()
+		delete(c.permissions, requestID)
+	}
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.stdin.Close()
+}
+
+// write sends one JSON line to the CLI
+func (c *controlChannel) write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode control message: %w", err)
+	}
+	data = append(data, '\n')
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.stdin.Write(data); err != nil {
+		return fmt.Errorf("failed to write to CLI: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package claude
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCLI plays the CLI side of stream-json input mode over pipes
+type fakeCLI struct {
+	t      *testing.T
+	args   []string
+	input  *bufio.Scanner
+	output *io.PipeWriter
+}
+
+// newFakeCLIExecutor returns an executor whose interactive commands are served
+// by run. The command's stdout is closed when run returns.
+func newFakeCLIExecutor(t *testing.T, run func(cli *fakeCLI)) *MockCommandExecutor {
+	return &MockCommandExecutor{
+		ExecuteInteractiveFunc: func(_ context.Context, _ string, args []string, _ string, _ map[string]string) (io.WriteCloser, io.ReadCloser, error) {
+			stdinR, stdinW := io.Pipe()
+			stdoutR, stdoutW := io.Pipe()
+			cli := &fakeCLI{t: t, args: args, input: bufio.NewScanner(stdinR), output: stdoutW}
+			go func() {
+				defer stdoutW.Close()
+				defer stdinR.Close()
+				run(cli)
+			}()
+			return stdinW, stdoutR, nil
+		},
+	}
+}
+
+// read returns the next JSON line written by the client, or nil at EOF
+func (c *fakeCLI) read() map[string]any {
+	if !c.input.Scan() {
+		return nil
+	}
+	var v map[string]any
+	if err := json.Unmarshal(c.input.Bytes(), &v); err != nil {
+		c.t.Errorf("client wrote invalid JSON %q: %v", c.input.Text(), err)
+	}
+	return v
+}
+
+// send writes one line of CLI output
+func (c *fakeCLI) send(line string) {
+	_, _ = io.WriteString(c.output, line+"\n")
+}
+
+// jsonPath returns the value at a dotted path in a decoded JSON object
+func jsonPath(v map[string]any, keys string) any {
+	var cur any = v
+	for _, key := range strings.Split(keys, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[key]
+	}
+	return cur
+}
+
+const (
+	testCanUseTool = `{"type":"control_request","request_id":"perm-1","request":{"subtype":"can_use_tool","tool_name":"Bash","input":{"command":"ls"},"tool_use_id":"toolu_1","permission_suggestions":[{"type":"addRules","rules":[{"toolName":"Bash","ruleContent":"ls"}],"behavior":"allow","destination":"session"}]}}`
+	testResult     = `{"type":"result","subtype":"success","is_error":false,"num_turns":1,"result":"done","session_id":"s1","total_cost_usd":0.01,"usage":{"input_tokens":1,"output_tokens":1}}`
+)
+
+func TestQueryStream_PermissionRespond(t *testing.T) {
+	responses := make(chan map[string]any, 1)
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		user := cli.read()
+		if jsonPath(user, "type") != "user" || jsonPath(user, "message.content") != "list files" {
+			t.Errorf("first input = %v, want the prompt as a user message", user)
+		}
+		cli.send(testCanUseTool)
+		responses <- cli.read()
+		cli.send(testResult)
+		if extra := cli.read(); extra != nil {
+			t.Errorf("stdin not closed after the result, got %v", extra)
+		}
+	})
+	client := NewClientWithExecutor(executor)
+
+	stream, err := client.QueryStream(context.Background(), "list files", &Options{PermissionPromptToolName: PermissionPromptToolStdio})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+
+	var result *ResultMessage
+	for msgOrErr := range stream.Messages {
+		if msgOrErr.Err != nil {
+			t.Fatalf("stream error = %v", msgOrErr.Err)
+		}
+		switch m := msgOrErr.Message.(type) {
+		case *PermissionRequestMessage:
+			if m.ToolName != "Bash" || m.ToolUseID != "toolu_1" || len(m.Suggestions) != 1 || m.Suggestions[0].Rules[0].RuleContent != "ls" {
+				t.Errorf("PermissionRequestMessage = %+v", m)
+			}
+			if err := stream.Respond(m.RequestID, PermissionDecision{Behavior: PermissionBehaviorAllow, UpdatedPermissions: m.Suggestions}); err != nil {
+				t.Errorf("Respond() error = %v", err)
+			}
+			if err := stream.Respond(m.RequestID, PermissionDecision{Behavior: PermissionBehaviorAllow}); err == nil {
+				t.Error("second Respond() error = nil, want error")
+			}
+		case *ResultMessage:
+			result = m
+		}
+	}
+	if result == nil || result.Result != "done" {
+		t.Errorf("result = %+v, want done", result)
+	}
+
+	response := <-responses
+	if jsonPath(response, "type") != "control_response" || jsonPath(response, "response.request_id") != "perm-1" || jsonPath(response, "response.subtype") != "success" {
+		t.Errorf("response envelope = %v", response)
+	}
+	if jsonPath(response, "response.response.behavior") != "allow" || jsonPath(response, "response.response.updatedInput.command") != "ls" {
+		t.Errorf("allow response = %v, want original input", response)
+	}
+	if rules, _ := jsonPath(response, "response.response.updatedPermissions").([]any); len(rules) != 1 {
+		t.Errorf("updatedPermissions = %v, want the suggestion", jsonPath(response, "response.response.updatedPermissions"))
+	}
+}
+
+func TestQueryStream_PermissionWithCustomParser(t *testing.T) {
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		if got := argValue(cli.args, "--permission-prompt-tool"); got != "stdio" {
+			t.Errorf("args = %v, want --permission-prompt-tool stdio", cli.args)
+		}
+		cli.read()
+		cli.send(testCanUseTool)
+		cli.read()
+		cli.send(testResult)
+	})
+	// A parser that only knows the message types it was written for
+	parser := &MockMessageParser{ParseMessageFunc: func(line string) (Message, error) {
+		if strings.Contains(line, "control_request") {
+			return nil, &ParseError{Line: line, Message: "unknown message type: control_request"}
+		}
+		return ParseMessage(line)
+	}}
+	client := NewClient(WithExecutor(executor), WithParser(parser))
+
+	stream, err := client.QueryStream(context.Background(), "list files", &Options{PermissionPromptToolName: PermissionPromptToolStdio})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	var perm *PermissionRequestMessage
+	for msgOrErr := range stream.Messages {
+		if msgOrErr.Err != nil {
+			t.Fatalf("stream error = %v", msgOrErr.Err)
+		}
+		if m, ok := msgOrErr.Message.(*PermissionRequestMessage); ok {
+			perm = m
+			if err := stream.Respond(m.RequestID, PermissionDecision{Behavior: PermissionBehaviorDeny}); err != nil {
+				t.Errorf("Respond() error = %v", err)
+			}
+		}
+	}
+	if perm == nil {
+		t.Fatal("stream had no PermissionRequestMessage")
+	}
+	if perm.Type != perm.messageType() {
+		t.Errorf("Type = %q, want %q", perm.Type, perm.messageType())
+	}
+}
+
+func TestQueryStream_PermissionTimeout(t *testing.T) {
+	responses := make(chan map[string]any, 1)
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		cli.read()
+		cli.send(testCanUseTool)
+		responses <- cli.read()
+		cli.send(testResult)
+	})
+	client := NewClientWithExecutor(executor)
+
+	stream, err := client.QueryStream(context.Background(), "list files", &Options{
+		PermissionPromptToolName: PermissionPromptToolStdio,
+		PermissionTimeout:        10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+
+	// Never respond; the request must be denied on our behalf
+	for range stream.Messages {
+	}
+
+	response := <-responses
+	if jsonPath(response, "response.response.behavior") != "deny" {
+		t.Errorf("response = %v, want deny", response)
+	}
+	if msg, _ := jsonPath(response, "response.response.message").(string); !strings.Contains(msg, "not answered") {
+		t.Errorf("deny message = %q", msg)
+	}
+}
+
+func TestQueryStream_UnsupportedControlRequest(t *testing.T) {
+	responses := make(chan map[string]any, 1)
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		cli.read()
+		cli.send(`{"type":"control_request","request_id":"hook-1","request":{"subtype":"hook_callback","callback_id":"cb"}}`)
+		responses <- cli.read()
+		cli.send(testResult)
+	})
+	client := NewClientWithExecutor(executor)
+
+	stream, err := client.QueryStream(context.Background(), "hi", &Options{PermissionPromptToolName: PermissionPromptToolStdio})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	var messages []Message
+	for msgOrErr := range stream.Messages {
+		if msgOrErr.Err != nil {
+			t.Fatalf("stream error = %v", msgOrErr.Err)
+		}
+		messages = append(messages, msgOrErr.Message)
+	}
+	if len(messages) != 1 {
+		t.Errorf("got %d messages, want only the result", len(messages))
+	}
+
+	response := <-responses
+	if jsonPath(response, "response.subtype") != "error" || jsonPath(response, "response.request_id") != "hook-1" {
+		t.Errorf("response = %v, want error for hook-1", response)
+	}
+}
+
+func TestMessageStream_Respond_Errors(t *testing.T) {
+	stream := &MessageStream{}
+	if err := stream.Respond("perm-1", PermissionDecision{Behavior: PermissionBehaviorAllow}); !errors.Is(err, errNotInteractive) {
+		t.Errorf("Respond() error = %v, want errNotInteractive", err)
+	}
+
+	control := newControlChannel(nopWriteCloser{io.Discard}, time.Minute)
+	stream = &MessageStream{control: control}
+	if err := stream.Respond("missing", PermissionDecision{Behavior: PermissionBehaviorDeny}); err == nil {
+		t.Error("Respond() for unknown request error = nil, want error")
+	}
+
+	control.track(&PermissionRequestMessage{RequestID: "perm-1"})
+	var configErr *ConfigError
+	if err := stream.Respond("perm-1", PermissionDecision{Behavior: "maybe"}); !errors.As(err, &configErr) {
+		t.Errorf("Respond() with invalid behavior error = %v, want *ConfigError", err)
+	}
+	_ = control.closeInput()
+}
+
+func TestQueryStream_NonInteractiveExecutor(t *testing.T) {
+	executor := struct{ CommandExecutor }{&MockCommandExecutor{}}
+	client := NewClientWithExecutor(executor)
+
+	_, err := client.QueryStream(context.Background(), "hi", &Options{PermissionPromptToolName: PermissionPromptToolStdio})
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Field != "PermissionPromptToolName" {
+		t.Errorf("QueryStream() error = %v, want ConfigError for PermissionPromptToolName", err)
+	}
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,237 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Exchange records one prompt sent in a Conversation and the result it produced
+type Exchange struct {
+	Prompt string         `json:"prompt"`
+	Result *ResultMessage `json:"result,omitempty"`
+}
+
+// Conversation threads the Claude session ID across queries so that each
+// Ask continues the previous one. It records the prompt/result history and
+// accumulates cost and token usage. A Conversation is safe for concurrent
+// use; each Ask waits for the previous one to record its result.
+type Conversation struct {
+	client Client
+	// turn is held from the start of a query until its result is recorded,
+	// so the next query resumes the session that query produced
+	turn chan struct{}
+
+	mu           sync.Mutex
+	opts         *Options
+	sessionID    string
+	forkNext     bool
+	history      []Exchange
+	totalCostUSD float64
+	usage        Usage
+}
+
+// conversationState is the serialized form of a Conversation
+type conversationState struct {
+	Options      *Options   `json:"options,omitempty"`
+	SessionID    string     `json:"session_id,omitempty"`
+	ForkNext     bool       `json:"fork_next,omitempty"`
+	History      []Exchange `json:"history,omitempty"`
+	TotalCostUSD float64    `json:"total_cost_usd"`
+	Usage        Usage      `json:"usage"`
+}
+
+// NewConversation starts a conversation on client. opts are used for every
+// query; Resume and ForkSession are managed by the conversation.
+func NewConversation(client Client, opts *Options) *Conversation {
+	return &Conversation{
+		client: client,
+		turn:   make(chan struct{}, 1),
+		opts:   MergeOptions(nil, opts),
+	}
+}
+
+// RestoreConversation recreates a conversation serialized with json.Marshal,
+// for example in another process, and attaches it to client
+func RestoreConversation(client Client, data []byte) (*Conversation, error) {
+	var state conversationState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse conversation: %w", err)
+	}
+	return &Conversation{
+		client:       client,
+		turn:         make(chan struct{}, 1),
+		opts:         MergeOptions(nil, state.Options),
+		sessionID:    state.SessionID,
+		forkNext:     state.ForkNext,
+		history:      state.History,
+		totalCostUSD: state.TotalCostUSD,
+		usage:        state.Usage,
+	}, nil
+}
+
+// MarshalJSON serializes the conversation state so it can be restored later
+func (c *Conversation) MarshalJSON() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return json.Marshal(conversationState{
+		Options:      c.opts,
+		SessionID:    c.sessionID,
+		ForkNext:     c.forkNext,
+		History:      c.history,
+		TotalCostUSD: c.totalCostUSD,
+		Usage:        c.usage,
+	})
+}
+
+// Ask sends prompt in the current session and waits for the result. It
+// waits for an earlier Ask or AskStream to finish first.
+func (c *Conversation) Ask(ctx context.Context, prompt string) (*ResultMessage, error) {
+	if err := c.acquireTurn(ctx); err != nil {
+		return nil, err
+	}
+	defer c.releaseTurn()
+
+	result, err := c.client.Query(ctx, prompt, c.options())
+	if result != nil {
+		// Error results still advance the session and incur cost
+		c.mu.Lock()
+		c.record(prompt, result)
+		c.mu.Unlock()
+	}
+	return result, err
+}
+
+// AskStream sends prompt in the current session and streams the response.
+// The conversation is updated when the ResultMessage passes through the
+// stream; until then, or until the stream is closed, the next Ask waits.
+func (c *Conversation) AskStream(ctx context.Context, prompt string) (*MessageStream, error) {
+	if err := c.acquireTurn(ctx); err != nil {
+		return nil, err
+	}
+	release := sync.OnceFunc(c.releaseTurn)
+
+	inner, err := c.client.QueryStream(ctx, prompt, c.options())
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	messages := make(chan MessageOrError)
+	go func() {
+		defer close(messages)
+		defer release()
+		for msgOrErr := range inner.Messages {
+			if result, ok := msgOrErr.Message.(*ResultMessage); ok {
+				c.mu.Lock()
+				c.record(prompt, result)
+				c.mu.Unlock()
+				release()
+			}
+			select {
+			case messages <- msgOrErr:
+			case <-inner.closed:
+				return
+			}
+		}
+	}()
+
+	stream := &MessageStream{
+		Messages:   messages,
+		ctx:        inner.ctx,
+		cancel:     inner.cancel,
+		closed:     inner.closed,
+		markClosed: inner.markClosed,
+		spend:      inner.spend,
+	}
+	stream.current.Store(inner)
+	return stream, nil
+}
+
+// Fork returns a copy of the conversation whose next query branches a new
+// session from the current point. The original conversation is unaffected.
+func (c *Conversation) Fork() *Conversation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &Conversation{
+		client:       c.client,
+		turn:         make(chan struct{}, 1),
+		opts:         MergeOptions(nil, c.opts),
+		sessionID:    c.sessionID,
+		forkNext:     c.sessionID != "",
+		history:      append([]Exchange(nil), c.history...),
+		totalCostUSD: c.totalCostUSD,
+		usage:        c.usage,
+	}
+}
+
+// SessionID returns the ID of the session the next query will resume
+func (c *Conversation) SessionID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionID
+}
+
+// History returns the prompts and results recorded so far
+func (c *Conversation) History() []Exchange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Exchange(nil), c.history...)
+}
+
+// TotalCostUSD returns the accumulated cost of all queries in the conversation
+func (c *Conversation) TotalCostUSD() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.totalCostUSD
+}
+
+// Usage returns the accumulated token usage of all queries in the conversation
+func (c *Conversation) Usage() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
+
+// acquireTurn waits until no other query of the conversation is running
+func (c *Conversation) acquireTurn(ctx context.Context) error {
+	select {
+	case c.turn <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return &AbortError{Message: "conversation turn aborted", Err: ctx.Err()}
+	}
+}
+
+// releaseTurn lets the next query start
+func (c *Conversation) releaseTurn() {
+	<-c.turn
+}
+
+// options returns the options for the next query
+func (c *Conversation) options() *Options {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	opts := MergeOptions(nil, c.opts)
+	if c.sessionID != "" {
+		opts.Continue = false
+		opts.Resume = c.sessionID
+		opts.ForkSession = c.forkNext
+	}
+	return opts
+}
+
+// record stores a completed exchange and advances the session; c.mu must be held
+func (c *Conversation) record(prompt string, result *ResultMessage) {
+	c.history = append(c.history, Exchange{Prompt: prompt, Result: result})
+	c.totalCostUSD += result.TotalCostUSD
+	c.usage = c.usage.Add(result.Usage)
+	if result.SessionID != "" {
+		c.sessionID = result.SessionID
+		c.forkNext = false
+	}
+}
@@ -0,0 +1,259 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// argValue returns the value following flag in args, or "" if absent
+func argValue(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// hasArg reports whether args contains flag
+func hasArg(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionExecutor simulates the CLI assigning session IDs; forked or fresh
+// queries get a new ID, resumed queries keep the resumed one
+func sessionExecutor(calls *[][]string) *MockCommandExecutor {
+	next := 0
+	respond := func(args []string) string {
+		*calls = append(*calls, args)
+		session := argValue(args, "--resume")
+		if session == "" || hasArg(args, "--fork-session") {
+			next++
+			session = fmt.Sprintf("session-%d", next)
+		}
+		return fmt.Sprintf(`{"type": "result", "subtype": "success", "session_id": %q, "total_cost_usd": 0.5, "usage": {"input_tokens": 10, "output_tokens": 5}}`, session)
+	}
+	return &MockCommandExecutor{
+		ExecuteFunc: func(_ context.Context, _ string, args []string, _ string, _ string, _ map[string]string) ([]byte, error) {
+			return []byte(respond(args)), nil
+		},
+		ExecuteStreamFunc: func(_ context.Context, _ string, args []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			return &mockReadCloser{Reader: strings.NewReader(respond(args))}, nil
+		},
+	}
+}
+
+func TestConversation_Ask(t *testing.T) {
+	ctx := context.Background()
+	var calls [][]string
+	conv := NewConversation(NewClientWithExecutor(sessionExecutor(&calls)), &Options{Model: "claude-3-opus"})
+
+	if _, err := conv.Ask(ctx, "first"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if _, err := conv.Ask(ctx, "second"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+
+	if hasArg(calls[0], "--resume") {
+		t.Errorf("first query args = %v, want no --resume", calls[0])
+	}
+	if got := argValue(calls[1], "--resume"); got != "session-1" {
+		t.Errorf("second query --resume = %q, want session-1", got)
+	}
+	if got := argValue(calls[1], "--model"); got != "claude-3-opus" {
+		t.Errorf("second query --model = %q, want claude-3-opus", got)
+	}
+
+	if conv.SessionID() != "session-1" {
+		t.Errorf("SessionID() = %q, want session-1", conv.SessionID())
+	}
+	history := conv.History()
+	if len(history) != 2 || history[0].Prompt != "first" || history[1].Prompt != "second" {
+		t.Errorf("History() = %+v, want two exchanges", history)
+	}
+	if conv.TotalCostUSD() != 1.0 {
+		t.Errorf("TotalCostUSD() = %v, want 1.0", conv.TotalCostUSD())
+	}
+	if usage := conv.Usage(); usage.InputTokens != 20 || usage.OutputTokens != 10 {
+		t.Errorf("Usage() = %+v, want 20 input and 10 output tokens", usage)
+	}
+}
+
+func TestConversation_AskStream(t *testing.T) {
+	ctx := context.Background()
+	var calls [][]string
+	conv := NewConversation(NewClientWithExecutor(sessionExecutor(&calls)), nil)
+
+	for i := 0; i < 2; i++ {
+		stream, err := conv.AskStream(ctx, "hello")
+		if err != nil {
+			t.Fatalf("AskStream() error = %v", err)
+		}
+		for msgOrErr := range stream.Messages {
+			if msgOrErr.Err != nil {
+				t.Fatalf("unexpected stream error: %v", msgOrErr.Err)
+			}
+		}
+	}
+
+	if got := argValue(calls[1], "--resume"); got != "session-1" {
+		t.Errorf("second stream --resume = %q, want session-1", got)
+	}
+	if len(conv.History()) != 2 {
+		t.Errorf("History() has %d exchanges, want 2", len(conv.History()))
+	}
+}
+
+func TestConversation_AskWaitsForStreamResult(t *testing.T) {
+	ctx := context.Background()
+	var calls [][]string
+	executor := sessionExecutor(&calls)
+	respond := executor.ExecuteStreamFunc
+	proceed := make(chan struct{})
+	executor.ExecuteStreamFunc = func(ctx context.Context, executable string, args []string, input string, workingDir string, env map[string]string) (io.ReadCloser, error) {
+		r, w := io.Pipe()
+		go func() {
+			<-proceed
+			output, _ := respond(ctx, executable, args, input, workingDir, env)
+			io.Copy(w, output)
+			w.Close()
+		}()
+		return r, nil
+	}
+	conv := NewConversation(NewClientWithExecutor(executor), nil)
+
+	stream, err := conv.AskStream(ctx, "first")
+	if err != nil {
+		t.Fatalf("AskStream() error = %v", err)
+	}
+	asked := make(chan error, 1)
+	go func() {
+		_, err := conv.Ask(ctx, "second")
+		asked <- err
+	}()
+
+	select {
+	case err := <-asked:
+		t.Fatalf("Ask() returned %v before the streamed result was recorded", err)
+	case <-time.After(30 * time.Millisecond):
+	}
+	// Accessors do not wait for the running query
+	if got := conv.SessionID(); got != "" {
+		t.Errorf("SessionID() = %q during the first query, want empty", got)
+	}
+
+	close(proceed)
+	if _, errs := drain(stream); len(errs) != 0 {
+		t.Fatalf("stream errors = %v", errs)
+	}
+	if err := <-asked; err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if got := argValue(calls[1], "--resume"); got != "session-1" {
+		t.Errorf("second query --resume = %q, want session-1", got)
+	}
+}
+
+func TestConversation_AskCanceledWhileWaiting(t *testing.T) {
+	executor := sessionExecutor(new([][]string))
+	executor.ExecuteStreamFunc = func(context.Context, string, []string, string, string, map[string]string) (io.ReadCloser, error) {
+		r, _ := io.Pipe()
+		return r, nil
+	}
+	conv := NewConversation(NewClientWithExecutor(executor), nil)
+
+	stream, err := conv.AskStream(context.Background(), "first")
+	if err != nil {
+		t.Fatalf("AskStream() error = %v", err)
+	}
+	defer stream.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := conv.Ask(ctx, "second"); !errors.Is(err, ErrAborted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Ask() error = %v, want an abort wrapping DeadlineExceeded", err)
+	}
+}
+
+func TestConversation_Fork(t *testing.T) {
+	ctx := context.Background()
+	var calls [][]string
+	conv := NewConversation(NewClientWithExecutor(sessionExecutor(&calls)), nil)
+
+	if _, err := conv.Ask(ctx, "setup"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+
+	fork := conv.Fork()
+	if _, err := fork.Ask(ctx, "branch"); err != nil {
+		t.Fatalf("fork Ask() error = %v", err)
+	}
+	if !hasArg(calls[1], "--fork-session") || argValue(calls[1], "--resume") != "session-1" {
+		t.Errorf("fork query args = %v, want --resume session-1 --fork-session", calls[1])
+	}
+	if fork.SessionID() != "session-2" {
+		t.Errorf("fork SessionID() = %q, want session-2", fork.SessionID())
+	}
+
+	// Later fork queries resume the new session without forking again
+	if _, err := fork.Ask(ctx, "more"); err != nil {
+		t.Fatalf("fork Ask() error = %v", err)
+	}
+	if hasArg(calls[2], "--fork-session") {
+		t.Errorf("second fork query args = %v, want no --fork-session", calls[2])
+	}
+
+	if conv.SessionID() != "session-1" || len(conv.History()) != 1 {
+		t.Errorf("original conversation changed: session %q, %d exchanges", conv.SessionID(), len(conv.History()))
+	}
+	if len(fork.History()) != 3 {
+		t.Errorf("fork History() has %d exchanges, want 3", len(fork.History()))
+	}
+}
+
+func TestConversation_Serialization(t *testing.T) {
+	ctx := context.Background()
+	var calls [][]string
+	client := NewClientWithExecutor(sessionExecutor(&calls))
+	conv := NewConversation(client, &Options{Model: "claude-3-opus"})
+
+	if _, err := conv.Ask(ctx, "first"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+
+	data, err := json.Marshal(conv)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	restored, err := RestoreConversation(client, data)
+	if err != nil {
+		t.Fatalf("RestoreConversation() error = %v", err)
+	}
+	if restored.SessionID() != "session-1" || restored.TotalCostUSD() != 0.5 || len(restored.History()) != 1 {
+		t.Errorf("restored conversation = session %q, cost %v, %d exchanges", restored.SessionID(), restored.TotalCostUSD(), len(restored.History()))
+	}
+
+	if _, err := restored.Ask(ctx, "second"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if argValue(calls[1], "--resume") != "session-1" || argValue(calls[1], "--model") != "claude-3-opus" {
+		t.Errorf("restored query args = %v, want resume of session-1 with model", calls[1])
+	}
+
+	if _, err := RestoreConversation(client, []byte(`{invalid`)); err == nil {
+		t.Error("RestoreConversation() expected error for invalid JSON")
+	}
+}
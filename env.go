@@ -0,0 +1,131 @@
+package claude
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// secretMask replaces secret values in error output
+const secretMask = "****"
+
+// minMaskedSecretLen is the shortest value that is masked; shorter values
+// would match too much unrelated output to be worth replacing
+const minMaskedSecretLen = 4
+
+// secretEnvKeyMarkers are substrings that mark an environment variable as secret
+var secretEnvKeyMarkers = []string{"KEY", "TOKEN", "SECRET", "PASSWORD", "CREDENTIAL", "AUTH"}
+
+// mergeEnv overlays overrides onto base, a list of KEY=VALUE entries as returned
+// by os.Environ. Overridden keys are replaced rather than duplicated.
+func mergeEnv(base []string, overrides map[string]string) []string {
+	merged := make([]string, 0, len(base)+len(overrides))
+	for _, entry := range base {
+		key, _, _ := strings.Cut(entry, "=")
+		if _, ok := overrides[key]; ok {
+			continue
+		}
+		merged = append(merged, entry)
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		merged = append(merged, key+"="+overrides[key])
+	}
+	return merged
+}
+
+// isSecretEnvKey reports whether an environment variable name looks like it holds a secret
+func isSecretEnvKey(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, marker := range secretEnvKeyMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskEnvSecrets replaces the values of secret variables in env wherever they appear in s
+func maskEnvSecrets(s string, env map[string]string) string {
+	for key, value := range env {
+		if len(value) < minMaskedSecretLen || !isSecretEnvKey(key) {
+			continue
+		}
+		s = strings.ReplaceAll(s, value, secretMask)
+	}
+	return s
+}
+
+// maskProcessError returns a copy of err with secrets from env masked in its message
+func maskProcessError(err *ProcessError, env map[string]string) *ProcessError {
+	return &ProcessError{
+		ExitCode: err.ExitCode,
+		Message:  maskEnvSecrets(err.Message, env),
+	}
+}
+
+// maskParseError hides secrets from env in an error from parsing a line of
+// CLI output, which may echo the secret
+func maskParseError(err error, env map[string]string) error {
+	if len(env) == 0 {
+		return err
+	}
+	if parseErr, ok := err.(*ParseError); ok {
+		return &ParseError{
+			Line:    maskEnvSecrets(parseErr.Line, env),
+			Message: maskEnvSecrets(parseErr.Message, env),
+		}
+	}
+	return &maskedError{err: err, env: env}
+}
+
+// notFoundError marks a failure to start the CLI because the executable is missing
+type notFoundError struct {
+	err error
+}
+
+func (e *notFoundError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notFoundError) Unwrap() []error {
+	return []error{e.err, ErrCLINotFound}
+}
+
+// maskedError hides secrets from env in the message of a wrapped error
+type maskedError struct {
+	err error
+	env map[string]string
+}
+
+func (e *maskedError) Error() string {
+	return maskEnvSecrets(e.err.Error(), e.env)
+}
+
+func (e *maskedError) Unwrap() error {
+	return e.err
+}
+
+// wrapExecError converts an executor error into the error returned to callers,
+// masking any secrets from env that the message may contain
+func wrapExecError(err error, env map[string]string) error {
+	if processErr, ok := err.(*ProcessError); ok {
+		return maskProcessError(processErr, env)
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		err = &notFoundError{err: err}
+	}
+	wrapped := fmt.Errorf("failed to execute command: %w", err)
+	if len(env) == 0 {
+		return wrapped
+	}
+	return &maskedError{err: wrapped, env: env}
+}
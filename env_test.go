@@ -0,0 +1,124 @@
+package claude
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	base := []string{"PATH=/usr/bin", "HOME=/home/user", "ANTHROPIC_BASE_URL=https://default"}
+	overrides := map[string]string{
+		"ANTHROPIC_BASE_URL": "https://proxy.example.com",
+		"CLAUDE_CONFIG_DIR":  "/tmp/claude",
+	}
+
+	got := mergeEnv(base, overrides)
+	want := []string{
+		"PATH=/usr/bin",
+		"HOME=/home/user",
+		"ANTHROPIC_BASE_URL=https://proxy.example.com",
+		"CLAUDE_CONFIG_DIR=/tmp/claude",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeEnv() = %v, want %v", got, want)
+	}
+}
+
+func TestIsSecretEnvKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"ANTHROPIC_API_KEY", true},
+		{"ANTHROPIC_AUTH_TOKEN", true},
+		{"db_password", true},
+		{"AWS_SECRET_ACCESS_KEY", true},
+		{"ANTHROPIC_BASE_URL", false},
+		{"CLAUDE_CONFIG_DIR", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := isSecretEnvKey(tt.key); got != tt.want {
+				t.Errorf("isSecretEnvKey(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskEnvSecrets(t *testing.T) {
+	env := map[string]string{
+		"ANTHROPIC_API_KEY":  "sk-ant-12345",
+		"ANTHROPIC_BASE_URL": "https://proxy.example.com",
+		"SHORT_TOKEN":        "ab",
+	}
+
+	got := maskEnvSecrets("invalid key sk-ant-12345 for https://proxy.example.com (ab)", env)
+	want := "invalid key **** for https://proxy.example.com (ab)"
+	if got != want {
+		t.Errorf("maskEnvSecrets() = %q, want %q", got, want)
+	}
+}
+
+func TestWrapExecError(t *testing.T) {
+	env := map[string]string{"ANTHROPIC_API_KEY": "sk-ant-12345"}
+
+	t.Run("process error", func(t *testing.T) {
+		err := wrapExecError(&ProcessError{ExitCode: 1, Message: "bad key sk-ant-12345"}, env)
+
+		var processErr *ProcessError
+		if !errors.As(err, &processErr) {
+			t.Fatalf("wrapExecError() = %T, want *ProcessError", err)
+		}
+		if processErr.ExitCode != 1 {
+			t.Errorf("ExitCode = %d, want 1", processErr.ExitCode)
+		}
+		if strings.Contains(processErr.Message, "sk-ant-12345") {
+			t.Errorf("Message = %q, secret not masked", processErr.Message)
+		}
+	})
+
+	t.Run("other error", func(t *testing.T) {
+		cause := errors.New("exec failed with sk-ant-12345")
+		err := wrapExecError(cause, env)
+
+		if strings.Contains(err.Error(), "sk-ant-12345") {
+			t.Errorf("Error() = %q, secret not masked", err.Error())
+		}
+		if !errors.Is(err, cause) {
+			t.Error("wrapExecError() should wrap the original error")
+		}
+	})
+
+	t.Run("no env", func(t *testing.T) {
+		cause := errors.New("exec failed")
+		err := wrapExecError(cause, nil)
+
+		if err.Error() != "failed to execute command: exec failed" {
+			t.Errorf("Error() = %q", err.Error())
+		}
+		if !errors.Is(err, cause) {
+			t.Error("wrapExecError() should wrap the original error")
+		}
+	})
+}
+
+func TestWrapExecError_NotFound(t *testing.T) {
+	err := wrapExecError(&exec.Error{Name: "claude", Err: exec.ErrNotFound}, nil)
+	if !errors.Is(err, ErrCLINotFound) {
+		t.Errorf("errors.Is(%v, ErrCLINotFound) = false", err)
+	}
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("errors.Is(%v, exec.ErrNotFound) = false", err)
+	}
+	if got := err.Error(); !strings.Contains(got, "executable file not found") {
+		t.Errorf("Error() = %q, want the underlying message", got)
+	}
+
+	if err := wrapExecError(errors.New("boom"), nil); errors.Is(err, ErrCLINotFound) {
+		t.Errorf("errors.Is(%v, ErrCLINotFound) = true", err)
+	}
+}
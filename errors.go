@@ -1,10 +1,30 @@
 package claude
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Sentinel errors describing why the CLI failed. A *ProcessError unwraps to
+// one of them when its exit code or output matches a known pattern, and an
+// *AbortError matches ErrAborted.
+var (
+	ErrCLINotFound      = errors.New("claude CLI not found")
+	ErrNotAuthenticated = errors.New("claude CLI is not authenticated")
+	ErrRateLimited      = errors.New("rate limited")
+	ErrOverloaded       = errors.New("API overloaded")
+	ErrInvalidModel     = errors.New("invalid model")
+	ErrAborted          = errors.New("operation aborted")
+)
 
 // AbortError represents an operation that was aborted
 type AbortError struct {
 	Message string
+	// Err is the cause, typically context.Canceled or context.DeadlineExceeded
+	Err error
 }
 
 func (e *AbortError) Error() string {
@@ -14,6 +34,27 @@ func (e *AbortError) Error() string {
 	return e.Message
 }
 
+// Unwrap returns the cause of the abort
+func (e *AbortError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrAborted
+func (e *AbortError) Is(target error) bool {
+	return target == ErrAborted
+}
+
+// ControlError reports that the CLI rejected a control request
+type ControlError struct {
+	// Request is the control request subtype, such as "interrupt"
+	Request string
+	Message string
+}
+
+func (e *ControlError) Error() string {
+	return fmt.Sprintf("control request %s failed: %s", e.Request, e.Message)
+}
+
 // ProcessError represents an error from the Claude Code CLI process
 type ProcessError struct {
 	ExitCode int
@@ -24,6 +65,73 @@ func (e *ProcessError) Error() string {
 	return fmt.Sprintf("process exited with code %d: %s", e.ExitCode, e.Message)
 }
 
+// Unwrap returns the sentinel error matching the exit code and output, or nil
+func (e *ProcessError) Unwrap() error {
+	return ClassifyProcessError(e.ExitCode, e.Message)
+}
+
+// ProcessErrorPattern maps CLI failures to a sentinel error. A pattern
+// matches when ExitCode matches (if non-zero) and Pattern matches the
+// process output (if non-nil); at least one of the two must be set.
+type ProcessErrorPattern struct {
+	ExitCode int
+	Pattern  *regexp.Regexp
+	Err      error
+}
+
+func (p ProcessErrorPattern) matches(exitCode int, message string) bool {
+	if p.ExitCode == 0 && p.Pattern == nil {
+		return false
+	}
+	if p.ExitCode != 0 && p.ExitCode != exitCode {
+		return false
+	}
+	return p.Pattern == nil || p.Pattern.MatchString(message)
+}
+
+// defaultProcessErrorPatterns is the built-in classification table, checked
+// after any patterns added with RegisterProcessErrorPattern
+var defaultProcessErrorPatterns = []ProcessErrorPattern{
+	{ExitCode: 127, Err: ErrCLINotFound},
+	{ExitCode: 130, Err: ErrAborted},
+	{ExitCode: 143, Err: ErrAborted},
+	{Pattern: regexp.MustCompile(`(?i)claude: (command )?not found`), Err: ErrCLINotFound},
+	{Pattern: regexp.MustCompile(`(?i)invalid api key|authentication_error|not (logged in|authenticated)|please run /login|oauth token (has )?expired`), Err: ErrNotAuthenticated},
+	{Pattern: regexp.MustCompile(`(?i)rate[ _]limit|too many requests|\b429\b|usage limit reached`), Err: ErrRateLimited},
+	{Pattern: regexp.MustCompile(`(?i)overloaded|\b529\b`), Err: ErrOverloaded},
+	{Pattern: regexp.MustCompile(`(?i)invalid model|unknown model|model\b.*\bnot found|not_found_error.*model`), Err: ErrInvalidModel},
+}
+
+var (
+	processErrorPatternsMu     sync.RWMutex
+	customProcessErrorPatterns []ProcessErrorPattern
+)
+
+// RegisterProcessErrorPattern adds a pattern that is checked before the
+// built-in ones, so it can refine or override their classification
+func RegisterProcessErrorPattern(pattern ProcessErrorPattern) {
+	processErrorPatternsMu.Lock()
+	defer processErrorPatternsMu.Unlock()
+	customProcessErrorPatterns = append([]ProcessErrorPattern{pattern}, customProcessErrorPatterns...)
+}
+
+// ClassifyProcessError returns the sentinel error for a CLI exit code and
+// output, or nil if no pattern matches
+func ClassifyProcessError(exitCode int, message string) error {
+	processErrorPatternsMu.RLock()
+	custom := customProcessErrorPatterns
+	processErrorPatternsMu.RUnlock()
+
+	for _, patterns := range [][]ProcessErrorPattern{custom, defaultProcessErrorPatterns} {
+		for _, p := range patterns {
+			if p.matches(exitCode, message) {
+				return p.Err
+			}
+		}
+	}
+	return nil
+}
+
 // ParseError represents an error parsing messages from the CLI
 type ParseError struct {
 	Line    string
@@ -48,3 +156,135 @@ func (e *ConfigError) Error() string {
 	}
 	return fmt.Sprintf("configuration error in field '%s' with value '%s': %s", e.Field, e.Value, e.Reason)
 }
+
+// SessionBusyError is returned when a session is already in use by another
+// query and the client is configured to reject concurrent use
+type SessionBusyError struct {
+	SessionID string
+	// CrossProcess is true when another process holds the session
+	CrossProcess bool
+}
+
+func (e *SessionBusyError) Error() string {
+	if e.CrossProcess {
+		return fmt.Sprintf("session %s is in use by another process", e.SessionID)
+	}
+	return fmt.Sprintf("session %s is in use", e.SessionID)
+}
+
+// Sentinel errors matched with errors.Is against the typed result errors
+var (
+	// ErrMaxTurns matches a *MaxTurnsError
+	ErrMaxTurns = errors.New("maximum turns reached")
+	// ErrExecution matches an *ExecutionError
+	ErrExecution = errors.New("error during execution")
+	// ErrBudget matches a *BudgetError
+	ErrBudget = errors.New("budget exceeded")
+)
+
+// ResultError carries the ResultMessage of a run that ended in an error.
+// It is embedded in MaxTurnsError, ExecutionError and BudgetError.
+type ResultError struct {
+	Result *ResultMessage
+}
+
+// SessionID returns the session the failed run belongs to, for resuming
+func (e *ResultError) SessionID() string {
+	return e.Result.SessionID
+}
+
+// TotalCostUSD returns the cost incurred before the run failed
+func (e *ResultError) TotalCostUSD() float64 {
+	return e.Result.TotalCostUSD
+}
+
+// PartialResult returns whatever result text the run produced before failing
+func (e *ResultError) PartialResult() string {
+	return e.Result.Result
+}
+
+// MaxTurnsError is returned when a run stops at the MaxTurns limit
+type MaxTurnsError struct {
+	ResultError
+}
+
+func (e *MaxTurnsError) Error() string {
+	return fmt.Sprintf("maximum turns reached after %d turns (session %s)", e.Result.NumTurns, e.Result.SessionID)
+}
+
+func (e *MaxTurnsError) Unwrap() error {
+	return ErrMaxTurns
+}
+
+// ExecutionError is returned when a run fails during execution
+type ExecutionError struct {
+	ResultError
+}
+
+func (e *ExecutionError) Error() string {
+	if e.Result.Result != "" {
+		return fmt.Sprintf("error during execution (session %s): %s", e.Result.SessionID, e.Result.Result)
+	}
+	return fmt.Sprintf("error during execution (session %s)", e.Result.SessionID)
+}
+
+func (e *ExecutionError) Unwrap() error {
+	return ErrExecution
+}
+
+// BudgetError is returned when a run stops because its spending limit was reached
+type BudgetError struct {
+	ResultError
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("budget exceeded after $%.4f (session %s)", e.Result.TotalCostUSD, e.Result.SessionID)
+}
+
+func (e *BudgetError) Unwrap() error {
+	return ErrBudget
+}
+
+// resultError returns the typed error for a ResultMessage, or nil if the run succeeded
+func resultError(result *ResultMessage) error {
+	switch {
+	case result.Subtype == ResultSubtypeErrorMaxTurns:
+		return &MaxTurnsError{ResultError{Result: result}}
+	case result.Subtype == ResultSubtypeErrorMaxBudget:
+		return &BudgetError{ResultError{Result: result}}
+	case result.Subtype == ResultSubtypeErrorDuringExecution, result.IsError:
+		return &ExecutionError{ResultError{Result: result}}
+	default:
+		return nil
+	}
+}
+
+// ErrQuotaExceeded matches a *QuotaExceededError
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaExceededError is returned before a query starts when it would exceed
+// one of the client's quotas
+type QuotaExceededError struct {
+	Quota Quota
+	// SpentUSD is the ledger total for the quota's current period
+	SpentUSD float64
+	// ReservedUSD is held by queries of this client that are still running
+	ReservedUSD float64
+	// EstimatedUSD is the estimated cost of the refused query's prompt
+	EstimatedUSD float64
+	// ResetsAt is when the next period begins
+	ResetsAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	reserved := ""
+	if e.ReservedUSD > 0 {
+		reserved = fmt.Sprintf(" and $%.4f reserved by running queries", e.ReservedUSD)
+	}
+	return fmt.Sprintf("%s quota of $%.2f for %s exceeded: $%.4f spent%s, resets at %s",
+		e.Quota.Period, e.Quota.LimitUSD, formatLabels(e.Quota.Labels), e.SpentUSD, reserved, e.ResetsAt.Format(time.RFC3339))
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrQuotaExceeded
+}
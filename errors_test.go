@@ -1,6 +1,9 @@
 package claude
 
 import (
+	"context"
+	"errors"
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -249,3 +252,183 @@ func TestErrorTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestSessionBusyError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     *SessionBusyError
+		wantMsg string
+	}{
+		{
+			name:    "in process",
+			err:     &SessionBusyError{SessionID: "abc"},
+			wantMsg: "session abc is in use",
+		},
+		{
+			name:    "cross process",
+			err:     &SessionBusyError{SessionID: "abc", CrossProcess: true},
+			wantMsg: "session abc is in use by another process",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.wantMsg {
+				t.Errorf("SessionBusyError.Error() = %v, want %v", got, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestResultError(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   *ResultMessage
+		sentinel error
+		wantMsg  string
+	}{
+		{
+			name:     "max turns",
+			result:   &ResultMessage{Subtype: ResultSubtypeErrorMaxTurns, IsError: true, NumTurns: 5, SessionID: "s1", TotalCostUSD: 0.2},
+			sentinel: ErrMaxTurns,
+			wantMsg:  "maximum turns reached after 5 turns (session s1)",
+		},
+		{
+			name:     "execution",
+			result:   &ResultMessage{Subtype: ResultSubtypeErrorDuringExecution, IsError: true, SessionID: "s1"},
+			sentinel: ErrExecution,
+			wantMsg:  "error during execution (session s1)",
+		},
+		{
+			name:     "error flag on success subtype",
+			result:   &ResultMessage{Subtype: ResultSubtypeSuccess, IsError: true, SessionID: "s1", Result: "API Error: 500"},
+			sentinel: ErrExecution,
+			wantMsg:  "error during execution (session s1): API Error: 500",
+		},
+		{
+			name:     "budget",
+			result:   &ResultMessage{Subtype: ResultSubtypeErrorMaxBudget, IsError: true, SessionID: "s1", TotalCostUSD: 1.5},
+			sentinel: ErrBudget,
+			wantMsg:  "budget exceeded after $1.5000 (session s1)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := resultError(tt.result)
+			if err == nil {
+				t.Fatal("resultError() = nil, want error")
+			}
+			if got := err.Error(); got != tt.wantMsg {
+				t.Errorf("Error() = %v, want %v", got, tt.wantMsg)
+			}
+			if !errors.Is(err, tt.sentinel) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.sentinel)
+			}
+			for _, other := range []error{ErrMaxTurns, ErrExecution, ErrBudget} {
+				if other != tt.sentinel && errors.Is(err, other) {
+					t.Errorf("errors.Is(%v, %v) = true, want false", err, other)
+				}
+			}
+		})
+	}
+
+	if err := resultError(&ResultMessage{Subtype: ResultSubtypeSuccess}); err != nil {
+		t.Errorf("resultError() for success = %v, want nil", err)
+	}
+}
+
+func TestResultError_Accessors(t *testing.T) {
+	result := &ResultMessage{Subtype: ResultSubtypeErrorMaxTurns, IsError: true, SessionID: "s1", TotalCostUSD: 0.3, Result: "partial"}
+
+	var maxTurns *MaxTurnsError
+	if !errors.As(resultError(result), &maxTurns) {
+		t.Fatal("errors.As() did not match *MaxTurnsError")
+	}
+	if maxTurns.SessionID() != "s1" || maxTurns.TotalCostUSD() != 0.3 || maxTurns.PartialResult() != "partial" {
+		t.Errorf("accessors = %q, %v, %q", maxTurns.SessionID(), maxTurns.TotalCostUSD(), maxTurns.PartialResult())
+	}
+	if maxTurns.Result != result {
+		t.Error("MaxTurnsError.Result does not wrap the ResultMessage")
+	}
+}
+
+func TestAbortError_Unwrap(t *testing.T) {
+	err := error(&AbortError{Message: "stream aborted", Err: context.Canceled})
+	if !errors.Is(err, ErrAborted) {
+		t.Error("errors.Is(err, ErrAborted) = false")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Error("errors.Is(err, context.Canceled) = false")
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Error("errors.Is(err, context.DeadlineExceeded) = true")
+	}
+}
+
+func TestClassifyProcessError(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		message  string
+		want     error
+	}{
+		{"command not found exit code", 127, "", ErrCLINotFound},
+		{"shell not found message", 1, "sh: claude: command not found", ErrCLINotFound},
+		{"interrupted", 130, "", ErrAborted},
+		{"terminated", 143, "", ErrAborted},
+		{"invalid api key", 1, "Invalid API key · Please run /login", ErrNotAuthenticated},
+		{"authentication error", 1, `API Error: 401 {"type":"error","error":{"type":"authentication_error"}}`, ErrNotAuthenticated},
+		{"expired oauth token", 1, "OAuth token has expired", ErrNotAuthenticated},
+		{"rate limit", 1, "API Error: Rate limit reached", ErrRateLimited},
+		{"429 status", 1, "API Error: 429 Too Many Requests", ErrRateLimited},
+		{"usage limit", 1, "Claude AI usage limit reached|1760000000", ErrRateLimited},
+		{"overloaded", 1, `API Error: 529 {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, ErrOverloaded},
+		{"invalid model", 1, "Error: invalid model name claude-foo", ErrInvalidModel},
+		{"model not found", 1, `API Error: 404 {"type":"error","error":{"type":"not_found_error","message":"model: claude-foo"}}`, ErrInvalidModel},
+		{"unclassified", 1, "something else went wrong", nil},
+		{"status code substring", 1, "processed 14290 tokens", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyProcessError(tt.exitCode, tt.message); got != tt.want {
+				t.Errorf("ClassifyProcessError(%d, %q) = %v, want %v", tt.exitCode, tt.message, got, tt.want)
+			}
+
+			err := error(&ProcessError{ExitCode: tt.exitCode, Message: tt.message})
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.want)
+			}
+			if tt.want == nil && errors.Unwrap(err) != nil {
+				t.Errorf("errors.Unwrap(%v) = %v, want nil", err, errors.Unwrap(err))
+			}
+		})
+	}
+}
+
+func TestRegisterProcessErrorPattern(t *testing.T) {
+	saved := customProcessErrorPatterns
+	t.Cleanup(func() { customProcessErrorPatterns = saved })
+
+	errQuota := errors.New("quota exhausted")
+	RegisterProcessErrorPattern(ProcessErrorPattern{Pattern: regexp.MustCompile(`(?i)credit balance is too low`), Err: errQuota})
+	// Custom patterns take precedence over the built-in table
+	RegisterProcessErrorPattern(ProcessErrorPattern{ExitCode: 2, Pattern: regexp.MustCompile(`overloaded`), Err: ErrRateLimited})
+
+	if got := ClassifyProcessError(1, "Credit balance is too low"); got != errQuota {
+		t.Errorf("ClassifyProcessError() = %v, want %v", got, errQuota)
+	}
+	if got := ClassifyProcessError(2, "overloaded"); got != ErrRateLimited {
+		t.Errorf("ClassifyProcessError() = %v, want %v", got, ErrRateLimited)
+	}
+	if got := ClassifyProcessError(1, "overloaded"); got != ErrOverloaded {
+		t.Errorf("ClassifyProcessError() = %v, want %v", got, ErrOverloaded)
+	}
+
+	// A pattern with neither an exit code nor a regexp never matches
+	RegisterProcessErrorPattern(ProcessErrorPattern{Err: errQuota})
+	if got := ClassifyProcessError(1, "unrelated"); got != nil {
+		t.Errorf("ClassifyProcessError() = %v, want nil", got)
+	}
+}
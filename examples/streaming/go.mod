@@ -1,6 +1,6 @@
 module github.com/upamune/claude-code-go/examples/streaming
 
-go 1.22
+go 1.24
 
 replace github.com/upamune/claude-code-go => ../..
 
@@ -2,10 +2,12 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/upamune/claude-code-go"
 )
@@ -27,6 +29,8 @@ func main() {
 	// Create options (optional)
 	opts := &claude.Options{
 		Model: "claude-3-5-sonnet-20241022", // Use latest model
+		// Ask us before Claude uses a tool that needs permission
+		PermissionPromptToolName: claude.PermissionPromptToolStdio,
 	}
 
 	fmt.Println("Querying Claude with streaming...")
@@ -41,6 +45,7 @@ func main() {
 	defer stream.Close()
 
 	// Process messages from channel
+	stdin := bufio.NewReader(os.Stdin)
 	for msgOrErr := range stream.Messages {
 		if msgOrErr.Err != nil {
 			log.Fatalf("Stream error: %v", msgOrErr.Err)
@@ -55,16 +60,20 @@ func main() {
 		case *claude.ResultMessage:
 			fmt.Println("\n---")
 			fmt.Println("[RESULT]")
-			fmt.Println("Status:", m.Status)
-			if m.Error != nil {
-				fmt.Println("Error:", *m.Error)
-			}
-		case *claude.SystemMessage:
+			fmt.Println("Subtype:", m.Subtype)
+			fmt.Printf("Cost: $%.4f\n", m.TotalCostUSD)
+		case *claude.InitMessage:
 			// System messages are typically verbose, you might want to skip them
-			// fmt.Println("[SYSTEM]", m.Message)
+			// fmt.Println("[SYSTEM]", m.Model)
 		case *claude.PermissionRequestMessage:
-			fmt.Printf("\n[PERMISSION] %s (tool: %s)\n", m.Message, m.Tool)
-			// In a real application, you would handle permission requests
+			fmt.Printf("\n[PERMISSION] %s %s - allow? [y/N] ", m.ToolName, m.Input)
+			decision := claude.PermissionDecision{Behavior: claude.PermissionBehaviorDeny, Message: "denied by user"}
+			if answer, _ := stdin.ReadString('\n'); strings.TrimSpace(answer) == "y" {
+				decision = claude.PermissionDecision{Behavior: claude.PermissionBehaviorAllow}
+			}
+			if err := stream.Respond(m.RequestID, decision); err != nil {
+				log.Printf("Failed to respond: %v", err)
+			}
 		}
 	}
 
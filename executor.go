@@ -4,29 +4,45 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"os"
 	"os/exec"
 	"strings"
 )
 
 // Compile-time check that implementations satisfy the interface
-var _ CommandExecutor = (*DefaultCommandExecutor)(nil)
+var (
+	_ CommandExecutor            = (*DefaultCommandExecutor)(nil)
+	_ InteractiveCommandExecutor = (*DefaultCommandExecutor)(nil)
+)
 
 // CommandExecutor is an interface for executing commands
 type CommandExecutor interface {
-	Execute(ctx context.Context, name string, args []string, stdin string, workingDir string) ([]byte, error)
-	ExecuteStream(ctx context.Context, name string, args []string, stdin string, workingDir string) (io.ReadCloser, error)
+	// env holds per-command overrides merged over the inherited environment
+	Execute(ctx context.Context, name string, args []string, stdin string, workingDir string, env map[string]string) ([]byte, error)
+	ExecuteStream(ctx context.Context, name string, args []string, stdin string, workingDir string, env map[string]string) (io.ReadCloser, error)
+}
+
+// InteractiveCommandExecutor is implemented by executors that can keep the
+// command's stdin open, as needed for the CLI's stream-json input mode
+type InteractiveCommandExecutor interface {
+	// ExecuteInteractive starts a command and returns its stdin and stdout.
+	// Closing stdout waits for the command to exit.
+	ExecuteInteractive(ctx context.Context, name string, args []string, workingDir string, env map[string]string) (io.WriteCloser, io.ReadCloser, error)
 }
 
 // DefaultCommandExecutor implements CommandExecutor using os/exec
 type DefaultCommandExecutor struct{}
 
 // Execute runs a command and returns its output
-func (e *DefaultCommandExecutor) Execute(ctx context.Context, name string, args []string, stdin string, workingDir string) ([]byte, error) {
+func (e *DefaultCommandExecutor) Execute(ctx context.Context, name string, args []string, stdin string, workingDir string, env map[string]string) ([]byte, error) {
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Stdin = strings.NewReader(stdin)
 	if workingDir != "" {
 		cmd.Dir = workingDir
 	}
+	if len(env) > 0 {
+		cmd.Env = mergeEnv(os.Environ(), env)
+	}
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -42,12 +58,15 @@ func (e *DefaultCommandExecutor) Execute(ctx context.Context, name string, args
 }
 
 // ExecuteStream runs a command and returns a stream of its output
-func (e *DefaultCommandExecutor) ExecuteStream(ctx context.Context, name string, args []string, stdin string, workingDir string) (io.ReadCloser, error) {
+func (e *DefaultCommandExecutor) ExecuteStream(ctx context.Context, name string, args []string, stdin string, workingDir string, env map[string]string) (io.ReadCloser, error) {
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Stdin = strings.NewReader(stdin)
 	if workingDir != "" {
 		cmd.Dir = workingDir
 	}
+	if len(env) > 0 {
+		cmd.Env = mergeEnv(os.Environ(), env)
+	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -69,6 +88,40 @@ func (e *DefaultCommandExecutor) ExecuteStream(ctx context.Context, name string,
 	}, nil
 }
 
+// ExecuteInteractive runs a command with stdin kept open for writing
+func (e *DefaultCommandExecutor) ExecuteInteractive(ctx context.Context, name string, args []string, workingDir string, env map[string]string) (io.WriteCloser, io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if workingDir != "" {
+		cmd.Dir = workingDir
+	}
+	if len(env) > 0 {
+		cmd.Env = mergeEnv(os.Environ(), env)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Capture stderr for error reporting
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	return stdin, &streamReader{
+		reader:    stdout,
+		cmd:       cmd,
+		stderrBuf: &stderrBuf,
+	}, nil
+}
+
 // streamReader wraps stdout pipe and ensures command cleanup
 type streamReader struct {
 	reader    io.ReadCloser
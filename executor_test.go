@@ -9,29 +9,40 @@ import (
 	"time"
 )
 
-// Compile-time check that MockCommandExecutor implements CommandExecutor
-var _ CommandExecutor = (*MockCommandExecutor)(nil)
+// Compile-time check that MockCommandExecutor implements the executor interfaces
+var (
+	_ CommandExecutor            = (*MockCommandExecutor)(nil)
+	_ InteractiveCommandExecutor = (*MockCommandExecutor)(nil)
+)
 
 // MockCommandExecutor is a mock implementation of CommandExecutor for testing
 type MockCommandExecutor struct {
-	ExecuteFunc       func(ctx context.Context, name string, args []string, stdin string, workingDir string) ([]byte, error)
-	ExecuteStreamFunc func(ctx context.Context, name string, args []string, stdin string, workingDir string) (io.ReadCloser, error)
+	ExecuteFunc            func(ctx context.Context, name string, args []string, stdin string, workingDir string, env map[string]string) ([]byte, error)
+	ExecuteStreamFunc      func(ctx context.Context, name string, args []string, stdin string, workingDir string, env map[string]string) (io.ReadCloser, error)
+	ExecuteInteractiveFunc func(ctx context.Context, name string, args []string, workingDir string, env map[string]string) (io.WriteCloser, io.ReadCloser, error)
 }
 
-func (m *MockCommandExecutor) Execute(ctx context.Context, name string, args []string, stdin string, workingDir string) ([]byte, error) {
+func (m *MockCommandExecutor) Execute(ctx context.Context, name string, args []string, stdin string, workingDir string, env map[string]string) ([]byte, error) {
 	if m.ExecuteFunc != nil {
-		return m.ExecuteFunc(ctx, name, args, stdin, workingDir)
+		return m.ExecuteFunc(ctx, name, args, stdin, workingDir, env)
 	}
 	return nil, errors.New("ExecuteFunc not implemented")
 }
 
-func (m *MockCommandExecutor) ExecuteStream(ctx context.Context, name string, args []string, stdin string, workingDir string) (io.ReadCloser, error) {
+func (m *MockCommandExecutor) ExecuteStream(ctx context.Context, name string, args []string, stdin string, workingDir string, env map[string]string) (io.ReadCloser, error) {
 	if m.ExecuteStreamFunc != nil {
-		return m.ExecuteStreamFunc(ctx, name, args, stdin, workingDir)
+		return m.ExecuteStreamFunc(ctx, name, args, stdin, workingDir, env)
 	}
 	return nil, errors.New("ExecuteStreamFunc not implemented")
 }
 
+func (m *MockCommandExecutor) ExecuteInteractive(ctx context.Context, name string, args []string, workingDir string, env map[string]string) (io.WriteCloser, io.ReadCloser, error) {
+	if m.ExecuteInteractiveFunc != nil {
+		return m.ExecuteInteractiveFunc(ctx, name, args, workingDir, env)
+	}
+	return nil, nil, errors.New("ExecuteInteractiveFunc not implemented")
+}
+
 // mockReadCloser implements io.ReadCloser for testing
 type mockReadCloser struct {
 	*strings.Reader
@@ -86,7 +97,7 @@ func TestDefaultCommandExecutor_Execute(t *testing.T) {
 				t.Skip("Skipping command execution test in short mode")
 			}
 
-			output, err := executor.Execute(ctx, tt.command, tt.args, tt.stdin, "")
+			output, err := executor.Execute(ctx, tt.command, tt.args, tt.stdin, "", nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Execute() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -110,7 +121,7 @@ func TestDefaultCommandExecutor_Execute_Context(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // Cancel immediately
 
-	_, err := executor.Execute(ctx, "sleep", []string{"5"}, "", "")
+	_, err := executor.Execute(ctx, "sleep", []string{"5"}, "", "", nil)
 	if err == nil {
 		t.Error("Expected error when context is cancelled")
 	}
@@ -121,7 +132,7 @@ func TestDefaultCommandExecutor_Execute_ProcessError(t *testing.T) {
 	ctx := context.Background()
 
 	// Test command that exits with non-zero status
-	_, err := executor.Execute(ctx, "sh", []string{"-c", "echo 'error message' >&2; exit 1"}, "", "")
+	_, err := executor.Execute(ctx, "sh", []string{"-c", "echo 'error message' >&2; exit 1"}, "", "", nil)
 	if err == nil {
 		t.Fatal("Expected error for command with non-zero exit")
 	}
@@ -173,7 +184,7 @@ func TestDefaultCommandExecutor_ExecuteStream(t *testing.T) {
 				t.Skip("Skipping command execution test in short mode")
 			}
 
-			reader, err := executor.ExecuteStream(ctx, tt.command, tt.args, tt.stdin, "")
+			reader, err := executor.ExecuteStream(ctx, tt.command, tt.args, tt.stdin, "", nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ExecuteStream() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -229,7 +240,7 @@ func TestMockCommandExecutor(t *testing.T) {
 
 	t.Run("Execute", func(t *testing.T) {
 		mock := &MockCommandExecutor{
-			ExecuteFunc: func(_ context.Context, name string, args []string, stdin string, _ string) ([]byte, error) {
+			ExecuteFunc: func(_ context.Context, name string, args []string, stdin string, _ string, _ map[string]string) ([]byte, error) {
 				if name == "test" && len(args) == 1 && args[0] == "arg" && stdin == "input" {
 					return []byte("output"), nil
 				}
@@ -237,7 +248,7 @@ func TestMockCommandExecutor(t *testing.T) {
 			},
 		}
 
-		output, err := mock.Execute(ctx, "test", []string{"arg"}, "input", "")
+		output, err := mock.Execute(ctx, "test", []string{"arg"}, "input", "", nil)
 		if err != nil {
 			t.Errorf("Execute() error = %v", err)
 		}
@@ -248,7 +259,7 @@ func TestMockCommandExecutor(t *testing.T) {
 
 	t.Run("ExecuteStream", func(t *testing.T) {
 		mock := &MockCommandExecutor{
-			ExecuteStreamFunc: func(_ context.Context, name string, _ []string, _ string, _ string) (io.ReadCloser, error) {
+			ExecuteStreamFunc: func(_ context.Context, name string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
 				if name == "stream" {
 					return &mockReadCloser{
 						Reader: strings.NewReader("stream data"),
@@ -258,7 +269,7 @@ func TestMockCommandExecutor(t *testing.T) {
 			},
 		}
 
-		reader, err := mock.ExecuteStream(ctx, "stream", nil, "", "")
+		reader, err := mock.ExecuteStream(ctx, "stream", nil, "", "", nil)
 		if err != nil {
 			t.Errorf("ExecuteStream() error = %v", err)
 		}
@@ -276,12 +287,12 @@ func TestMockCommandExecutor(t *testing.T) {
 	t.Run("NotImplemented", func(t *testing.T) {
 		mock := &MockCommandExecutor{}
 
-		_, err := mock.Execute(ctx, "test", nil, "", "")
+		_, err := mock.Execute(ctx, "test", nil, "", "", nil)
 		if err == nil || !strings.Contains(err.Error(), "not implemented") {
 			t.Errorf("Execute() error = %v, want 'not implemented'", err)
 		}
 
-		_, err = mock.ExecuteStream(ctx, "test", nil, "", "")
+		_, err = mock.ExecuteStream(ctx, "test", nil, "", "", nil)
 		if err == nil || !strings.Contains(err.Error(), "not implemented") {
 			t.Errorf("ExecuteStream() error = %v, want 'not implemented'", err)
 		}
@@ -293,7 +304,7 @@ func TestDefaultCommandExecutor_ExecuteStream_ProcessError(t *testing.T) {
 	ctx := context.Background()
 
 	// Test command that exits with error
-	reader, err := executor.ExecuteStream(ctx, "sh", []string{"-c", "echo 'stream error' >&2; exit 2"}, "", "")
+	reader, err := executor.ExecuteStream(ctx, "sh", []string{"-c", "echo 'stream error' >&2; exit 2"}, "", "", nil)
 	if err != nil {
 		t.Fatalf("ExecuteStream() initial error = %v", err)
 	}
@@ -334,7 +345,7 @@ func TestDefaultCommandExecutor_ExecuteStream_Timeout(t *testing.T) {
 	defer cancel()
 
 	// This should timeout
-	reader, err := executor.ExecuteStream(ctx, "sleep", []string{"5"}, "", "")
+	reader, err := executor.ExecuteStream(ctx, "sleep", []string{"5"}, "", "", nil)
 	if err != nil {
 		// Context timeout should cause an error
 		return
@@ -354,3 +365,64 @@ func TestDefaultCommandExecutor_ExecuteStream_Timeout(t *testing.T) {
 		}
 	}
 }
+
+func TestDefaultCommandExecutor_Env(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping command execution test in short mode")
+	}
+
+	executor := &DefaultCommandExecutor{}
+	ctx := context.Background()
+	t.Setenv("CLAUDE_GO_INHERITED", "inherited")
+
+	env := map[string]string{"CLAUDE_GO_OVERRIDE": "override"}
+	output, err := executor.Execute(ctx, "sh", []string{"-c", "echo $CLAUDE_GO_INHERITED $CLAUDE_GO_OVERRIDE"}, "", "", env)
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if got := strings.TrimSpace(string(output)); got != "inherited override" {
+		t.Errorf("Execute() output = %q, want %q", got, "inherited override")
+	}
+
+	reader, err := executor.ExecuteStream(ctx, "sh", []string{"-c", "echo $CLAUDE_GO_OVERRIDE"}, "", "", env)
+	if err != nil {
+		t.Fatalf("ExecuteStream() error = %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "override" {
+		t.Errorf("ExecuteStream() output = %q, want %q", got, "override")
+	}
+}
+
+func TestDefaultCommandExecutor_ExecuteInteractive(t *testing.T) {
+	executor := &DefaultCommandExecutor{}
+
+	stdin, stdout, err := executor.ExecuteInteractive(context.Background(), "cat", nil, "", nil)
+	if err != nil {
+		t.Fatalf("ExecuteInteractive() error = %v", err)
+	}
+
+	if _, err := io.WriteString(stdin, "line one\n"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := stdin.Close(); err != nil {
+		t.Fatalf("stdin.Close() error = %v", err)
+	}
+
+	output, err := io.ReadAll(stdout)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(output) != "line one\n" {
+		t.Errorf("output = %q, want %q", output, "line one\n")
+	}
+	if err := stdout.Close(); err != nil {
+		t.Errorf("stdout.Close() error = %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package claude
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrSlowConsumer is reported by Subscription.Err when a subscriber with the
+// SlowConsumerDisconnect policy fell behind and was disconnected
+var ErrSlowConsumer = errors.New("subscriber disconnected: too slow")
+
+// SlowConsumerPolicy decides what happens when a subscriber's buffer is full
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerBlock waits for the subscriber, holding back every
+	// subscriber and the CLI's output
+	SlowConsumerBlock SlowConsumerPolicy = "block"
+	// SlowConsumerDropOldest discards the oldest buffered message
+	SlowConsumerDropOldest SlowConsumerPolicy = "drop_oldest"
+	// SlowConsumerDisconnect ends the subscription with ErrSlowConsumer
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+// SubscribeOptions configures a Subscription
+type SubscribeOptions struct {
+	// Buffer is the number of messages held for the subscriber. The
+	// drop-oldest and disconnect policies buffer at least one.
+	Buffer int
+	// Policy applies when the buffer is full; empty means SlowConsumerBlock
+	Policy SlowConsumerPolicy
+	// Optional subscribers, such as a UI that may go away, do not keep the
+	// CLI running. The stream is closed once every required subscriber has
+	// finished.
+	Optional bool
+}
+
+// Subscription receives a copy of every message of a MessageStream
+type Subscription struct {
+	fan      *fanout
+	policy   SlowConsumerPolicy
+	required bool
+
+	out       chan MessageOrError
+	done      chan struct{}
+	closeDone func()
+
+	// Guarded by fan.mu
+	finished bool
+	detached bool
+	err      error
+	dropped  int
+}
+
+// Messages returns the subscriber's channel, which is closed when the stream
+// ends, the subscription is closed or the subscriber is disconnected. The
+// first call on any of a stream's subscriptions starts delivery, so
+// subscribers added before then receive every message.
+func (s *Subscription) Messages() <-chan MessageOrError {
+	s.fan.start()
+	return s.out
+}
+
+// Close ends the subscription. Once every required subscriber has finished,
+// the stream is closed.
+func (s *Subscription) Close() {
+	s.closeDone()
+	s.fan.finish(s, nil)
+}
+
+// Err returns ErrSlowConsumer if the subscriber was disconnected
+func (s *Subscription) Err() error {
+	s.fan.mu.Lock()
+	defer s.fan.mu.Unlock()
+	return s.err
+}
+
+// Dropped returns the number of messages discarded by SlowConsumerDropOldest
+func (s *Subscription) Dropped() int {
+	s.fan.mu.Lock()
+	defer s.fan.mu.Unlock()
+	return s.dropped
+}
+
+// Subscribe adds a subscriber that receives a copy of every message. Once a
+// stream has subscribers, its Messages channel must not be read directly and
+// Close waits for the required subscribers to finish before stopping the CLI.
+// Subscribers added after delivery has started receive messages from then on.
+func (s *MessageStream) Subscribe(opts SubscribeOptions) *Subscription {
+	return s.fanout().subscribe(opts)
+}
+
+// Tee returns n required subscribers that each receive every message. They
+// are unbuffered and block, so every subscriber sees each message before the
+// next one is read.
+func (s *MessageStream) Tee(n int) []*Subscription {
+	subs := make([]*Subscription, n)
+	for i := range subs {
+		subs[i] = s.Subscribe(SubscribeOptions{})
+	}
+	return subs
+}
+
+// fanout returns the stream's broadcaster, creating it on first use
+func (s *MessageStream) fanout() *fanout {
+	s.fanMu.Lock()
+	defer s.fanMu.Unlock()
+	if s.fan == nil {
+		s.fan = &fanout{stream: s}
+	}
+	return s.fan
+}
+
+// fanout broadcasts a stream's messages to its subscribers
+type fanout struct {
+	stream    *MessageStream
+	startOnce sync.Once
+
+	mu          sync.Mutex
+	subs        []*Subscription
+	required    int
+	hadRequired bool
+	ownerClosed bool
+	ended       bool
+}
+
+func (f *fanout) subscribe(opts SubscribeOptions) *Subscription {
+	policy := opts.Policy
+	if policy == "" {
+		policy = SlowConsumerBlock
+	}
+	buffer := max(opts.Buffer, 0)
+	if policy != SlowConsumerBlock {
+		buffer = max(buffer, 1)
+	}
+	done := make(chan struct{})
+	sub := &Subscription{
+		fan:       f,
+		policy:    policy,
+		required:  !opts.Optional,
+		out:       make(chan MessageOrError, buffer),
+		done:      done,
+		closeDone: sync.OnceFunc(func() { close(done) }),
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ended {
+		sub.finished = true
+		sub.detached = true
+		close(sub.out)
+		return sub
+	}
+	f.subs = append(f.subs, sub)
+	if sub.required {
+		f.required++
+		f.hadRequired = true
+	}
+	return sub
+}
+
+// start begins reading the stream
+func (f *fanout) start() {
+	f.startOnce.Do(func() { go f.run() })
+}
+
+func (f *fanout) run() {
+	for item := range f.stream.Messages {
+		f.mu.Lock()
+		subs := append([]*Subscription(nil), f.subs...)
+		f.mu.Unlock()
+
+		for _, sub := range subs {
+			f.deliver(sub, item)
+		}
+	}
+
+	f.mu.Lock()
+	f.ended = true
+	subs := append([]*Subscription(nil), f.subs...)
+	f.mu.Unlock()
+	for _, sub := range subs {
+		f.finish(sub, nil)
+		f.detach(sub)
+	}
+}
+
+// deliver hands item to sub according to its policy
+func (f *fanout) deliver(sub *Subscription, item MessageOrError) {
+	select {
+	case <-sub.done:
+		f.detach(sub)
+		return
+	default:
+	}
+
+	switch sub.policy {
+	case SlowConsumerDropOldest:
+		for {
+			select {
+			case sub.out <- item:
+				return
+			default:
+			}
+			// Make room by discarding the oldest buffered message
+			select {
+			case <-sub.out:
+				f.mu.Lock()
+				sub.dropped++
+				f.mu.Unlock()
+			default:
+			}
+		}
+	case SlowConsumerDisconnect:
+		select {
+		case sub.out <- item:
+		default:
+			f.finish(sub, ErrSlowConsumer)
+			f.detach(sub)
+		}
+	default:
+		select {
+		case sub.out <- item:
+		case <-sub.done:
+			f.detach(sub)
+		}
+	}
+}
+
+// finish marks sub as finished and closes the stream once no required
+// subscribers remain
+func (f *fanout) finish(sub *Subscription, err error) {
+	f.mu.Lock()
+	if sub.finished {
+		f.mu.Unlock()
+		return
+	}
+	sub.finished = true
+	sub.err = err
+	if sub.required {
+		f.required--
+	}
+	closeStream := f.shouldCloseLocked()
+	f.mu.Unlock()
+
+	if closeStream {
+		f.stream.closeNow()
+	}
+}
+
+// detach removes sub and closes its channel. Only the run goroutine sends
+// on subscriber channels, so only it detaches.
+func (f *fanout) detach(sub *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if sub.detached {
+		return
+	}
+	sub.detached = true
+	for i, s := range f.subs {
+		if s == sub {
+			f.subs = append(f.subs[:i], f.subs[i+1:]...)
+			break
+		}
+	}
+	close(sub.out)
+}
+
+// ownerClose handles MessageStream.Close, which waits for the required
+// subscribers to finish
+func (f *fanout) ownerClose() {
+	f.mu.Lock()
+	f.ownerClosed = true
+	closeStream := f.shouldCloseLocked()
+	f.mu.Unlock()
+
+	if closeStream {
+		f.stream.closeNow()
+	}
+}
+
+// shouldCloseLocked reports whether the stream can be closed: no required
+// subscriber is left and either some existed or the owner asked to close
+func (f *fanout) shouldCloseLocked() bool {
+	return f.required == 0 && (f.hadRequired || f.ownerClosed)
+}
@@ -0,0 +1,161 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// fanoutStream starts a stream over executor
+func fanoutStream(t *testing.T, executor CommandExecutor) *MessageStream {
+	t.Helper()
+	stream, err := NewClientWithExecutor(executor).QueryStream(context.Background(), "hi", nil)
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	return stream
+}
+
+// collect reads a subscription until its channel is closed
+func collect(sub *Subscription) []MessageOrError {
+	var items []MessageOrError
+	for item := range sub.Messages() {
+		items = append(items, item)
+	}
+	return items
+}
+
+func TestMessageStream_Tee(t *testing.T) {
+	executor := pipeExecutor(func(w *io.PipeWriter) {
+		writeLines(w, 0, testInitLine, testAssistantLine, testResult)
+		w.Close()
+	})
+	stream := fanoutStream(t, executor)
+
+	subs := stream.Tee(3)
+	results := make(chan []MessageOrError, len(subs))
+	for _, sub := range subs {
+		go func() { results <- collect(sub) }()
+	}
+
+	for range subs {
+		items := <-results
+		if len(items) != 3 {
+			t.Fatalf("subscriber received %d items, want 3", len(items))
+		}
+		if _, ok := items[0].Message.(*InitMessage); !ok {
+			t.Errorf("first item = %+v, want init", items[0])
+		}
+		if _, ok := items[2].Message.(*ResultMessage); !ok {
+			t.Errorf("last item = %+v, want result", items[2])
+		}
+	}
+	for _, sub := range subs {
+		if err := sub.Err(); err != nil {
+			t.Errorf("Err() = %v", err)
+		}
+	}
+
+	// Subscribing after the stream ended yields a closed channel
+	if items := collect(stream.Subscribe(SubscribeOptions{})); len(items) != 0 {
+		t.Errorf("late subscriber received %v", items)
+	}
+}
+
+func TestMessageStream_SubscribeDropOldest(t *testing.T) {
+	executor := pipeExecutor(func(w *io.PipeWriter) {
+		writeLines(w, 0, testInitLine, testAssistantLine, testAssistantLine, testResult)
+		w.Close()
+	})
+	stream := fanoutStream(t, executor)
+
+	slow := stream.Subscribe(SubscribeOptions{Buffer: 1, Policy: SlowConsumerDropOldest, Optional: true})
+	fast := stream.Subscribe(SubscribeOptions{})
+	if items := collect(fast); len(items) != 4 {
+		t.Fatalf("fast subscriber received %d items, want 4", len(items))
+	}
+
+	items := collect(slow)
+	if len(items) != 1 {
+		t.Fatalf("slow subscriber received %d items, want the newest", len(items))
+	}
+	if _, ok := items[0].Message.(*ResultMessage); !ok {
+		t.Errorf("slow subscriber kept %+v, want result", items[0])
+	}
+	if got := slow.Dropped(); got != 3 {
+		t.Errorf("Dropped() = %d, want 3", got)
+	}
+	if err := slow.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestMessageStream_SubscribeDisconnect(t *testing.T) {
+	executor := pipeExecutor(func(w *io.PipeWriter) {
+		writeLines(w, 0, testInitLine, testAssistantLine, testResult)
+		w.Close()
+	})
+	stream := fanoutStream(t, executor)
+
+	slow := stream.Subscribe(SubscribeOptions{Buffer: 1, Policy: SlowConsumerDisconnect, Optional: true})
+	fast := stream.Subscribe(SubscribeOptions{})
+	if items := collect(fast); len(items) != 3 {
+		t.Fatalf("fast subscriber received %d items, want 3", len(items))
+	}
+
+	items := collect(slow)
+	if len(items) != 1 {
+		t.Fatalf("slow subscriber received %d items, want the buffered one", len(items))
+	}
+	if _, ok := items[0].Message.(*InitMessage); !ok {
+		t.Errorf("slow subscriber received %+v, want init", items[0])
+	}
+	if err := slow.Err(); !errors.Is(err, ErrSlowConsumer) {
+		t.Errorf("Err() = %v, want ErrSlowConsumer", err)
+	}
+}
+
+func TestMessageStream_RequiredSubscribersKeepStreamOpen(t *testing.T) {
+	closed := make(chan struct{})
+	executor := pipeExecutor(func(w *io.PipeWriter) {
+		for writeLines(w, 5*time.Millisecond, testAssistantLine) {
+		}
+		close(closed)
+	})
+	stream := fanoutStream(t, executor)
+
+	writer := stream.Subscribe(SubscribeOptions{Buffer: 16})
+	ui := stream.Subscribe(SubscribeOptions{Buffer: 1, Policy: SlowConsumerDropOldest, Optional: true})
+	<-writer.Messages()
+
+	stream.Close()
+	select {
+	case <-closed:
+		t.Fatal("Close() stopped the CLI while a required subscriber was active")
+	case <-time.After(30 * time.Millisecond):
+	}
+	if _, ok := <-writer.Messages(); !ok {
+		t.Fatal("required subscriber stopped receiving after Close()")
+	}
+
+	writer.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("CLI still running after the last required subscriber finished")
+	}
+
+	// The optional subscriber's channel is closed once the stream ends
+	done := make(chan struct{})
+	go func() {
+		collect(ui)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("optional subscriber channel not closed after the stream ended")
+	}
+}
@@ -0,0 +1,419 @@
+package claude
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LedgerEntry records the cost of one query
+type LedgerEntry struct {
+	Time time.Time `json:"time"`
+	// Labels are the query's Options.Labels, such as team, job and user
+	Labels    map[string]string `json:"labels,omitempty"`
+	SessionID string            `json:"session_id,omitempty"`
+	// Model is the model requested for the query; empty means the CLI default
+	Model   string  `json:"model,omitempty"`
+	CostUSD float64 `json:"cost_usd"`
+	Usage   Usage   `json:"usage"`
+}
+
+// matches reports whether the entry carries every label in match
+func (e *LedgerEntry) matches(match map[string]string) bool {
+	for k, v := range match {
+		if e.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Ledger records query costs so that spend can be shared and capped across
+// queries, clients and processes
+type Ledger interface {
+	// Record appends entry. A zero Time is set to the current time.
+	Record(ctx context.Context, entry LedgerEntry) error
+	// Total returns the summed cost of entries at or after since whose
+	// labels include every label in match; a nil match selects all entries
+	Total(ctx context.Context, match map[string]string, since time.Time) (float64, error)
+	// Entries returns the entries Total would sum, oldest first
+	Entries(ctx context.Context, match map[string]string, since time.Time) ([]LedgerEntry, error)
+}
+
+// Compile-time check that implementations satisfy the interface
+var (
+	_ Ledger = (*MemoryLedger)(nil)
+	_ Ledger = (*FileLedger)(nil)
+)
+
+// MemoryLedger is a Ledger held in process memory
+type MemoryLedger struct {
+	mu      sync.Mutex
+	entries []LedgerEntry
+	now     func() time.Time
+}
+
+// NewMemoryLedger creates an empty in-memory Ledger
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{now: time.Now}
+}
+
+// Record appends entry
+func (l *MemoryLedger) Record(_ context.Context, entry LedgerEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, stampLedgerEntry(entry, l.now()))
+	return nil
+}
+
+// Total returns the summed cost of matching entries
+func (l *MemoryLedger) Total(ctx context.Context, match map[string]string, since time.Time) (float64, error) {
+	entries, err := l.Entries(ctx, match, since)
+	return sumLedgerEntries(entries), err
+}
+
+// Entries returns the matching entries
+func (l *MemoryLedger) Entries(_ context.Context, match map[string]string, since time.Time) ([]LedgerEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return filterLedgerEntries(l.entries, match, since), nil
+}
+
+// FileLedger is a Ledger persisted as a file of JSON lines. Entries are
+// appended with a single write, so several processes can share the file.
+type FileLedger struct {
+	path string
+	mu   sync.Mutex
+	now  func() time.Time
+}
+
+// NewFileLedger creates a Ledger backed by the file at path. The file is
+// created on the first Record.
+func NewFileLedger(path string) *FileLedger {
+	return &FileLedger{path: path, now: time.Now}
+}
+
+// Record appends entry to the file
+func (l *FileLedger) Record(_ context.Context, entry LedgerEntry) error {
+	data, err := json.Marshal(stampLedgerEntry(entry, l.now()))
+	if err != nil {
+		return fmt.Errorf("failed to encode ledger entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open ledger %s: %w", l.path, err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write ledger %s: %w", l.path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write ledger %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// Total returns the summed cost of matching entries
+func (l *FileLedger) Total(ctx context.Context, match map[string]string, since time.Time) (float64, error) {
+	entries, err := l.Entries(ctx, match, since)
+	return sumLedgerEntries(entries), err
+}
+
+// Entries returns the matching entries
+func (l *FileLedger) Entries(_ context.Context, match map[string]string, since time.Time) ([]LedgerEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	return filterLedgerEntries(entries, match, since), nil
+}
+
+// load reads all entries from the file; a missing file holds no entries
+func (l *FileLedger) load() ([]LedgerEntry, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read ledger %s: %w", l.path, err)
+	}
+	defer f.Close()
+
+	var entries []LedgerEntry
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry LedgerEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse ledger %s line %d: %w", l.path, lineNo, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ledger %s: %w", l.path, err)
+	}
+	return entries, nil
+}
+
+// stampLedgerEntry returns a copy of entry with its time set
+func stampLedgerEntry(entry LedgerEntry, now time.Time) LedgerEntry {
+	if entry.Time.IsZero() {
+		entry.Time = now
+	}
+	entry.Labels = copyLabels(entry.Labels)
+	return entry
+}
+
+// filterLedgerEntries returns copies of the entries matching match and since,
+// oldest first
+func filterLedgerEntries(entries []LedgerEntry, match map[string]string, since time.Time) []LedgerEntry {
+	var matched []LedgerEntry
+	for i := range entries {
+		if entries[i].Time.Before(since) || !entries[i].matches(match) {
+			continue
+		}
+		entry := entries[i]
+		entry.Labels = copyLabels(entry.Labels)
+		matched = append(matched, entry)
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Time.Before(matched[j].Time) })
+	return matched
+}
+
+func sumLedgerEntries(entries []LedgerEntry) float64 {
+	total := 0.0
+	for _, entry := range entries {
+		total += entry.CostUSD
+	}
+	return total
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	clone := make(map[string]string, len(labels))
+	for k, v := range labels {
+		clone[k] = v
+	}
+	return clone
+}
+
+// QuotaPeriod is the window a Quota's limit applies to
+type QuotaPeriod string
+
+const (
+	// QuotaDaily resets at midnight in the quota's Location
+	QuotaDaily QuotaPeriod = "daily"
+	// QuotaMonthly resets at midnight on the first day of each month, in the
+	// quota's Location
+	QuotaMonthly QuotaPeriod = "monthly"
+)
+
+// Quota caps the spend of queries carrying a set of labels
+type Quota struct {
+	// Labels selects the queries the quota applies to: those whose
+	// Options.Labels include every label here. Empty applies to all queries.
+	Labels   map[string]string `json:"labels,omitempty"`
+	Period   QuotaPeriod       `json:"period"`
+	LimitUSD float64           `json:"limit_usd"`
+	// ReserveUSD is held against the quota for each running query, on top
+	// of its prompt's estimated cost, until the query's cost is recorded.
+	// Zero holds only the prompt estimate.
+	ReserveUSD float64 `json:"reserve_usd,omitempty"`
+	// Location sets where days and months begin; nil uses UTC
+	Location *time.Location `json:"-"`
+}
+
+// applies reports whether the quota covers a query with labels
+func (q *Quota) applies(labels map[string]string) bool {
+	for k, v := range q.Labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// window returns the start of the period containing now and the start of the next
+func (q *Quota) window(now time.Time) (time.Time, time.Time) {
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	now = now.In(loc)
+	if q.Period == QuotaMonthly {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// validateQuota checks that a quota can be enforced
+func validateQuota(q Quota) error {
+	if q.Period != QuotaDaily && q.Period != QuotaMonthly {
+		return &ConfigError{Field: "Quota.Period", Value: string(q.Period), Reason: "must be daily or monthly"}
+	}
+	if q.LimitUSD <= 0 {
+		return &ConfigError{Field: "Quota.LimitUSD", Value: fmt.Sprint(q.LimitUSD), Reason: "must be positive"}
+	}
+	if q.ReserveUSD < 0 {
+		return &ConfigError{Field: "Quota.ReserveUSD", Value: fmt.Sprint(q.ReserveUSD), Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// formatLabels renders labels as sorted key=value pairs
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "all queries"
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// spendGuard records query costs in a Ledger and enforces quotas before
+// queries start. Running queries hold a reservation so that concurrent
+// queries in this process cannot overshoot a quota together; queries in
+// other processes sharing the ledger are only seen once recorded.
+type spendGuard struct {
+	ledger  Ledger
+	quotas  []Quota
+	pricing *PricingRegistry
+	now     func() time.Time
+
+	mu      sync.Mutex
+	pending map[*spendReservation]struct{}
+}
+
+// spendReservation holds a running query's estimated cost against the
+// quotas until its result is recorded
+type spendReservation struct {
+	guard        *spendGuard
+	labels       map[string]string
+	estimatedUSD float64
+}
+
+// release drops the reservation; it is safe to call more than once
+func (r *spendReservation) release() {
+	if r == nil {
+		return
+	}
+	r.guard.mu.Lock()
+	defer r.guard.mu.Unlock()
+	delete(r.guard.pending, r)
+}
+
+// reservedLocked returns the cost held against quota by running queries;
+// g.mu must be held
+func (g *spendGuard) reservedLocked(quota *Quota) float64 {
+	reserved := 0.0
+	for r := range g.pending {
+		if quota.applies(r.labels) {
+			reserved += r.estimatedUSD + quota.ReserveUSD
+		}
+	}
+	return reserved
+}
+
+// check returns a *QuotaExceededError if starting a query with labels on
+// model for prompt would exceed a quota. Otherwise it reserves the query's
+// estimated cost until the returned reservation is released.
+func (g *spendGuard) check(ctx context.Context, labels map[string]string, model, prompt string) (*spendReservation, error) {
+	if g == nil {
+		return nil, nil
+	}
+	for _, quota := range g.quotas {
+		if err := validateQuota(quota); err != nil {
+			return nil, err
+		}
+	}
+
+	// The prompt's own input tokens are the part of the cost known up front
+	estimated := 0.0
+	if prompt != "" {
+		pricing := g.pricing
+		if pricing == nil {
+			pricing = DefaultPricing
+		}
+		estimated, _ = pricing.EstimatePromptCost(model, prompt, 0)
+	}
+
+	// Hold the lock across the ledger reads so that concurrent checks see
+	// each other's reservations
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	for _, quota := range g.quotas {
+		if !quota.applies(labels) {
+			continue
+		}
+		start, end := quota.window(now)
+		spent, err := g.ledger.Total(ctx, quota.Labels, start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ledger: %w", err)
+		}
+		reserved := g.reservedLocked(&quota)
+		committed := spent + reserved
+		if committed >= quota.LimitUSD || committed+estimated+quota.ReserveUSD > quota.LimitUSD {
+			return nil, &QuotaExceededError{
+				Quota:        quota,
+				SpentUSD:     spent,
+				ReservedUSD:  reserved,
+				EstimatedUSD: estimated,
+				ResetsAt:     end,
+			}
+		}
+	}
+
+	reservation := &spendReservation{guard: g, labels: labels, estimatedUSD: estimated}
+	if g.pending == nil {
+		g.pending = make(map[*spendReservation]struct{})
+	}
+	g.pending[reservation] = struct{}{}
+	return reservation, nil
+}
+
+// record adds the cost of result to the ledger
+func (g *spendGuard) record(ctx context.Context, labels map[string]string, model string, result *ResultMessage) error {
+	if g == nil || result == nil {
+		return nil
+	}
+	entry := LedgerEntry{
+		Time:      g.now(),
+		Labels:    labels,
+		SessionID: result.SessionID,
+		Model:     model,
+		CostUSD:   result.TotalCostUSD,
+		Usage:     result.Usage,
+	}
+	if err := g.ledger.Record(ctx, entry); err != nil {
+		return fmt.Errorf("failed to record spend in ledger: %w", err)
+	}
+	return nil
+}
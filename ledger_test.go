@@ -0,0 +1,303 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLedgers(t *testing.T) {
+	ledgers := map[string]func(t *testing.T, clock *fakeClock) Ledger{
+		"memory": func(_ *testing.T, clock *fakeClock) Ledger {
+			ledger := NewMemoryLedger()
+			ledger.now = clock.Now
+			return ledger
+		},
+		"file": func(t *testing.T, clock *fakeClock) Ledger {
+			ledger := NewFileLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+			ledger.now = clock.Now
+			return ledger
+		},
+	}
+
+	for name, newLedger := range ledgers {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+			clock := &fakeClock{now: start}
+			ledger := newLedger(t, clock)
+
+			if total, err := ledger.Total(ctx, nil, time.Time{}); err != nil || total != 0 {
+				t.Fatalf("Total() on empty ledger = %v, %v", total, err)
+			}
+
+			labels := map[string]string{"team": "search", "user": "ana"}
+			record := func(cost float64, labels map[string]string) {
+				t.Helper()
+				if err := ledger.Record(ctx, LedgerEntry{Labels: labels, CostUSD: cost, SessionID: "s"}); err != nil {
+					t.Fatalf("Record() error = %v", err)
+				}
+			}
+			record(1.5, labels)
+			// The ledger keeps its own copy of the labels
+			labels["user"] = "changed"
+			clock.now = start.Add(time.Hour)
+			record(2, map[string]string{"team": "search", "user": "bo"})
+			record(4, map[string]string{"team": "ads"})
+
+			tests := []struct {
+				name  string
+				match map[string]string
+				since time.Time
+				want  float64
+			}{
+				{name: "all", want: 7.5},
+				{name: "team", match: map[string]string{"team": "search"}, want: 3.5},
+				{name: "team and user", match: map[string]string{"team": "search", "user": "ana"}, want: 1.5},
+				{name: "since", match: map[string]string{"team": "search"}, since: start.Add(time.Minute), want: 2},
+				{name: "no match", match: map[string]string{"team": "none"}, want: 0},
+			}
+			for _, tt := range tests {
+				total, err := ledger.Total(ctx, tt.match, tt.since)
+				if err != nil {
+					t.Fatalf("%s: Total() error = %v", tt.name, err)
+				}
+				if !approxEqual(total, tt.want) {
+					t.Errorf("%s: Total() = %v, want %v", tt.name, total, tt.want)
+				}
+			}
+
+			entries, err := ledger.Entries(ctx, map[string]string{"team": "search"}, time.Time{})
+			if err != nil {
+				t.Fatalf("Entries() error = %v", err)
+			}
+			if len(entries) != 2 || !entries[0].Time.Equal(start) || entries[0].Labels["user"] != "ana" {
+				t.Errorf("Entries() = %+v", entries)
+			}
+		})
+	}
+}
+
+func TestFileLedger_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	if err := os.WriteFile(path, []byte("{\"cost_usd\":1}\nnot json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewFileLedger(path).Total(context.Background(), nil, time.Time{})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Total() error = %v, want parse error for line 2", err)
+	}
+}
+
+func TestQuota_Window(t *testing.T) {
+	now := time.Date(2025, 3, 15, 23, 30, 0, 0, time.UTC)
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	tests := []struct {
+		name      string
+		quota     Quota
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "daily",
+			quota:     Quota{Period: QuotaDaily},
+			wantStart: time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "monthly",
+			quota:     Quota{Period: QuotaMonthly},
+			wantStart: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "daily in location",
+			quota:     Quota{Period: QuotaDaily, Location: tokyo},
+			wantStart: time.Date(2025, 3, 16, 0, 0, 0, 0, tokyo),
+			wantEnd:   time.Date(2025, 3, 17, 0, 0, 0, 0, tokyo),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.quota.window(now)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("window() = %v, %v, want %v, %v", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+// resultExecutor answers every query with a result costing cost
+func resultExecutor(cost string) *MockCommandExecutor {
+	result := `{"type":"result","subtype":"success","session_id":"s1","result":"done","total_cost_usd":` + cost + `}`
+	return &MockCommandExecutor{
+		ExecuteFunc: func(context.Context, string, []string, string, string, map[string]string) ([]byte, error) {
+			return []byte(result), nil
+		},
+		ExecuteStreamFunc: func(context.Context, string, []string, string, string, map[string]string) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(result + "\n")), nil
+		},
+	}
+}
+
+func TestClient_LedgerQuota(t *testing.T) {
+	ctx := context.Background()
+	ledger := NewMemoryLedger()
+	quota := Quota{Labels: map[string]string{"team": "search"}, Period: QuotaDaily, LimitUSD: 1}
+	client := NewClient(WithExecutor(resultExecutor("0.6")), WithLedger(ledger, quota))
+	opts := &Options{Labels: map[string]string{"team": "search", "job": "nightly"}}
+
+	if _, err := client.Query(ctx, "first", opts); err != nil {
+		t.Fatalf("first Query() error = %v", err)
+	}
+	stream, err := client.QueryStream(ctx, "second", opts)
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	if _, err := collectResult(stream); err != nil {
+		t.Fatalf("QueryStream result error = %v", err)
+	}
+
+	_, err = client.Query(ctx, "third", opts)
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("third Query() error = %v, want *QuotaExceededError", err)
+	}
+	if !approxEqual(quotaErr.SpentUSD, 1.2) {
+		t.Errorf("SpentUSD = %v, want 1.2", quotaErr.SpentUSD)
+	}
+	if !strings.Contains(err.Error(), "daily quota of $1.00 for team=search exceeded") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	if _, err := client.QueryStream(ctx, "third", opts); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("QueryStream() error = %v, want ErrQuotaExceeded", err)
+	}
+
+	// Other teams are not subject to the quota
+	if _, err := client.Query(ctx, "other", &Options{Labels: map[string]string{"team": "ads"}}); err != nil {
+		t.Errorf("Query() for another team error = %v", err)
+	}
+
+	entries, _ := ledger.Entries(ctx, nil, time.Time{})
+	if len(entries) != 3 || entries[0].Labels["job"] != "nightly" || entries[0].SessionID != "s1" {
+		t.Errorf("ledger entries = %+v", entries)
+	}
+}
+
+func TestClient_LedgerQuotaEstimate(t *testing.T) {
+	ctx := context.Background()
+	pricing := NewPricingRegistry()
+	pricing.Set("pricey", ModelPricing{Input: 1_000_000})
+	client := NewClient(
+		WithExecutor(resultExecutor("0")),
+		WithPricing(pricing),
+		WithLedger(NewMemoryLedger(), Quota{Period: QuotaMonthly, LimitUSD: 5}),
+	)
+
+	// Each estimated prompt token costs $1
+	if _, err := client.Query(ctx, "one two", &Options{Model: "pricey"}); err != nil {
+		t.Errorf("Query() within quota error = %v", err)
+	}
+	_, err := client.Query(ctx, "one two three four five six", &Options{Model: "pricey"})
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) || quotaErr.EstimatedUSD <= 5 {
+		t.Errorf("Query() error = %v, want *QuotaExceededError from the estimate", err)
+	}
+}
+
+func TestClient_LedgerQuotaConcurrent(t *testing.T) {
+	unblock := make(chan struct{})
+	result := `{"type":"result","subtype":"success","session_id":"s1","result":"done","total_cost_usd":0.3}`
+	executor := &MockCommandExecutor{
+		ExecuteFunc: func(context.Context, string, []string, string, string, map[string]string) ([]byte, error) {
+			<-unblock
+			return []byte(result), nil
+		},
+	}
+	ledger := NewMemoryLedger()
+	client := NewClient(WithExecutor(executor), WithLedger(ledger, Quota{Period: QuotaDaily, LimitUSD: 1, ReserveUSD: 0.3}))
+
+	// Only three queries fit while their costs are still unknown
+	errs := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := client.Query(context.Background(), "hi", nil)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			var quotaErr *QuotaExceededError
+			if !errors.As(err, &quotaErr) || !approxEqual(quotaErr.ReservedUSD, 0.9) {
+				t.Fatalf("Query() error = %v, want *QuotaExceededError with $0.90 reserved", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("running queries did not hold reservations")
+		}
+	}
+	close(unblock)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Query() error = %v", err)
+		}
+	}
+
+	// Recorded costs replace the released reservations
+	total, _ := ledger.Total(context.Background(), nil, time.Time{})
+	if !approxEqual(total, 0.9) {
+		t.Errorf("ledger total = %v, want 0.9", total)
+	}
+	if _, err := client.Query(context.Background(), "hi", nil); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Query() after recording error = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestClient_LedgerInvalidQuota(t *testing.T) {
+	client := NewClient(WithExecutor(resultExecutor("0")), WithLedger(NewMemoryLedger(), Quota{Period: "weekly", LimitUSD: 1}))
+	_, err := client.Query(context.Background(), "hi", nil)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Field != "Quota.Period" {
+		t.Errorf("Query() error = %v, want ConfigError for Quota.Period", err)
+	}
+}
+
+func TestSession_LedgerQuota(t *testing.T) {
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		for cli.read() != nil {
+			cli.send(`{"type":"result","subtype":"success","session_id":"s1","result":"done","total_cost_usd":1}`)
+		}
+	})
+	ledger := NewMemoryLedger()
+	client := NewClient(WithExecutor(executor), WithLedger(ledger, Quota{Period: QuotaDaily, LimitUSD: 2}))
+
+	session, err := client.StartSession(context.Background(), &Options{Labels: map[string]string{"user": "ana"}})
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	defer session.Close()
+
+	for i := 0; i < 2; i++ {
+		stream, err := session.Send(context.Background(), "hi")
+		if err != nil {
+			t.Fatalf("Send() %d error = %v", i+1, err)
+		}
+		if _, errs := drain(stream); len(errs) != 0 {
+			t.Fatalf("turn %d errors = %v", i+1, errs)
+		}
+	}
+	if _, err := session.Send(context.Background(), "hi"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("third Send() error = %v, want ErrQuotaExceeded", err)
+	}
+
+	total, _ := ledger.Total(context.Background(), map[string]string{"user": "ana"}, time.Time{})
+	if total != 2 {
+		t.Errorf("ledger total = %v, want 2", total)
+	}
+}
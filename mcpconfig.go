@@ -0,0 +1,270 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// MCP server transport types used in .mcp.json files
+const (
+	mcpTypeStdio = "stdio"
+	mcpTypeSSE   = "sse"
+	mcpTypeHTTP  = "http"
+)
+
+// mcpConfigFile is the on-disk layout shared by .mcp.json and Claude settings files
+type mcpConfigFile struct {
+	MCPServers map[string]mcpServerJSON `json:"mcpServers"`
+}
+
+// mcpServerJSON is the file representation of a single MCP server entry
+type mcpServerJSON struct {
+	Type    string            `json:"type,omitempty"`
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// envVarPattern matches ${VAR} and ${VAR:-default} references
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// LoadMCPConfig reads MCP server definitions from a .mcp.json or Claude settings file.
+// ${VAR} and ${VAR:-default} references are expanded from the process environment;
+// a reference to an unset variable without a default is a configuration error.
+func LoadMCPConfig(path string) (map[string]MCPServerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MCP config %s: %w", path, err)
+	}
+
+	var file mcpConfigFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse MCP config %s: %w", path, err)
+	}
+
+	servers := make(map[string]MCPServerConfig, len(file.MCPServers))
+	for name, raw := range file.MCPServers {
+		expanded, err := raw.expand(name)
+		if err != nil {
+			return nil, err
+		}
+		server, err := expanded.toConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		servers[name] = server
+	}
+	return servers, nil
+}
+
+// LoadMCPConfigs loads several MCP config files and merges them in order.
+// Files listed later take precedence, so pass them from lowest to highest
+// priority (for example user, then project, then local). Files that do not
+// exist are skipped.
+func LoadMCPConfigs(paths ...string) (map[string]MCPServerConfig, error) {
+	configs := make([]map[string]MCPServerConfig, 0, len(paths))
+	for _, path := range paths {
+		servers, err := LoadMCPConfig(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		configs = append(configs, servers)
+	}
+	return MergeMCPConfigs(configs...), nil
+}
+
+// MergeMCPConfigs merges server maps by name, with later maps overriding earlier ones
+func MergeMCPConfigs(configs ...map[string]MCPServerConfig) map[string]MCPServerConfig {
+	merged := make(map[string]MCPServerConfig)
+	for _, config := range configs {
+		for name, server := range config {
+			merged[name] = server
+		}
+	}
+	return merged
+}
+
+// WriteMCPConfig writes MCP server definitions to path in .mcp.json format.
+// If the file already exists, keys other than "mcpServers" are preserved so that
+// Claude settings files can be edited in place. Values are written verbatim,
+// so keep ${VAR} references in the configs to avoid persisting secrets.
+func WriteMCPConfig(path string, servers map[string]MCPServerConfig) error {
+	doc := map[string]json.RawMessage{}
+	perm := os.FileMode(0o644)
+
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read MCP config %s: %w", path, err)
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &doc); err != nil {
+				return fmt.Errorf("failed to parse MCP config %s: %w", path, err)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to stat MCP config %s: %w", path, err)
+	}
+
+	entries := make(map[string]mcpServerJSON, len(servers))
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry, err := mcpServerToJSON(name, servers[name])
+		if err != nil {
+			return err
+		}
+		entries[name] = entry
+	}
+
+	encoded, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode MCP servers: %w", err)
+	}
+	doc["mcpServers"] = encoded
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode MCP config: %w", err)
+	}
+	out = append(out, '\n')
+
+	if err := writeFileAtomic(path, out, perm); err != nil {
+		return fmt.Errorf("failed to write MCP config %s: %w", path, err)
+	}
+	return nil
+}
+
+// expand returns a copy of the entry with environment references resolved
+func (s mcpServerJSON) expand(name string) (mcpServerJSON, error) {
+	field := fmt.Sprintf("MCPServers[%s]", name)
+	var err error
+
+	if s.Command, err = expandEnvRefs(field, s.Command); err != nil {
+		return s, err
+	}
+	if s.URL, err = expandEnvRefs(field, s.URL); err != nil {
+		return s, err
+	}
+
+	if s.Args != nil {
+		args := make([]string, len(s.Args))
+		for i, arg := range s.Args {
+			if args[i], err = expandEnvRefs(field, arg); err != nil {
+				return s, err
+			}
+		}
+		s.Args = args
+	}
+
+	if s.Env, err = expandEnvMap(field, s.Env); err != nil {
+		return s, err
+	}
+	if s.Headers, err = expandEnvMap(field, s.Headers); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// toConfig converts the file entry into the matching MCPServerConfig type
+func (s mcpServerJSON) toConfig(name string) (MCPServerConfig, error) {
+	field := fmt.Sprintf("MCPServers[%s]", name)
+
+	serverType := s.Type
+	if serverType == "" {
+		// Entries without a type are stdio servers, matching the CLI
+		serverType = mcpTypeStdio
+	}
+
+	switch serverType {
+	case mcpTypeStdio:
+		if s.Command == "" {
+			return nil, &ConfigError{Field: field, Value: serverType, Reason: "stdio server requires a command"}
+		}
+		return &MCPStdioServerConfig{Command: s.Command, Args: s.Args, Env: s.Env}, nil
+	case mcpTypeSSE:
+		if s.URL == "" {
+			return nil, &ConfigError{Field: field, Value: serverType, Reason: "sse server requires a url"}
+		}
+		return &MCPSSEServerConfig{URL: s.URL, Headers: s.Headers}, nil
+	case mcpTypeHTTP:
+		if s.URL == "" {
+			return nil, &ConfigError{Field: field, Value: serverType, Reason: "http server requires a url"}
+		}
+		return &MCPHTTPServerConfig{URL: s.URL, Headers: s.Headers}, nil
+	default:
+		return nil, &ConfigError{Field: field, Value: serverType, Reason: "type must be 'stdio', 'sse', or 'http'"}
+	}
+}
+
+// mcpServerToJSON converts an MCPServerConfig into its file representation
+func mcpServerToJSON(name string, server MCPServerConfig) (mcpServerJSON, error) {
+	switch c := server.(type) {
+	case *MCPStdioServerConfig:
+		return mcpServerJSON{Type: mcpTypeStdio, Command: c.Command, Args: c.Args, Env: c.Env}, nil
+	case MCPStdioServerConfig:
+		return mcpServerJSON{Type: mcpTypeStdio, Command: c.Command, Args: c.Args, Env: c.Env}, nil
+	case *MCPSSEServerConfig:
+		return mcpServerJSON{Type: mcpTypeSSE, URL: c.URL, Headers: c.Headers}, nil
+	case MCPSSEServerConfig:
+		return mcpServerJSON{Type: mcpTypeSSE, URL: c.URL, Headers: c.Headers}, nil
+	case *MCPHTTPServerConfig:
+		return mcpServerJSON{Type: mcpTypeHTTP, URL: c.URL, Headers: c.Headers}, nil
+	case MCPHTTPServerConfig:
+		return mcpServerJSON{Type: mcpTypeHTTP, URL: c.URL, Headers: c.Headers}, nil
+	default:
+		return mcpServerJSON{}, &ConfigError{Field: fmt.Sprintf("MCPServers[%s]", name), Value: fmt.Sprintf("%T", server), Reason: "unsupported server config type"}
+	}
+}
+
+// expandEnvMap expands environment references in every value of m
+func expandEnvMap(field string, m map[string]string) (map[string]string, error) {
+	if m == nil {
+		return nil, nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		expanded, err := expandEnvRefs(field, v)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = expanded
+	}
+	return out, nil
+}
+
+// expandEnvRefs replaces ${VAR} and ${VAR:-default} references in s
+func expandEnvRefs(field, s string) (string, error) {
+	var missing string
+	expanded := envVarPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		parts := envVarPattern.FindStringSubmatch(ref)
+		if value, ok := os.LookupEnv(parts[1]); ok {
+			return value
+		}
+		if len(ref) > len(parts[1])+3 {
+			// The reference carries a ":-default" clause
+			return parts[2]
+		}
+		if missing == "" {
+			missing = parts[1]
+		}
+		return ref
+	})
+	if missing != "" {
+		return "", &ConfigError{Field: field, Value: missing, Reason: "environment variable is not set and has no default"}
+	}
+	return expanded, nil
+}
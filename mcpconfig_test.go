@@ -0,0 +1,239 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestLoadMCPConfig(t *testing.T) {
+	t.Setenv("MCP_TEST_TOKEN", "secret-token")
+	t.Setenv("MCP_TEST_BIN", "/opt/bin/server")
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]MCPServerConfig
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name: "all server types",
+			content: `{"mcpServers": {
+				"local": {"command": "node", "args": ["server.js"], "env": {"NODE_ENV": "production"}},
+				"events": {"type": "sse", "url": "https://example.com/sse", "headers": {"Authorization": "Bearer x"}},
+				"api": {"type": "http", "url": "https://api.example.com"}
+			}}`,
+			want: map[string]MCPServerConfig{
+				"local":  &MCPStdioServerConfig{Command: "node", Args: []string{"server.js"}, Env: map[string]string{"NODE_ENV": "production"}},
+				"events": &MCPSSEServerConfig{URL: "https://example.com/sse", Headers: map[string]string{"Authorization": "Bearer x"}},
+				"api":    &MCPHTTPServerConfig{URL: "https://api.example.com"},
+			},
+		},
+		{
+			name: "environment expansion",
+			content: `{"mcpServers": {
+				"local": {"type": "stdio", "command": "${MCP_TEST_BIN}", "args": ["--port", "${MCP_TEST_PORT:-8080}"], "env": {"TOKEN": "${MCP_TEST_TOKEN}"}},
+				"api": {"type": "http", "url": "https://api.example.com", "headers": {"Authorization": "Bearer ${MCP_TEST_TOKEN}"}}
+			}}`,
+			want: map[string]MCPServerConfig{
+				"local": &MCPStdioServerConfig{Command: "/opt/bin/server", Args: []string{"--port", "8080"}, Env: map[string]string{"TOKEN": "secret-token"}},
+				"api":   &MCPHTTPServerConfig{URL: "https://api.example.com", Headers: map[string]string{"Authorization": "Bearer secret-token"}},
+			},
+		},
+		{
+			name:    "unset variable without default",
+			content: `{"mcpServers": {"local": {"command": "${MCP_TEST_UNSET_VARIABLE}"}}}`,
+			wantErr: true,
+			errMsg:  "MCPServers[local]",
+		},
+		{
+			name:    "unknown type",
+			content: `{"mcpServers": {"bad": {"type": "websocket", "url": "ws://localhost"}}}`,
+			wantErr: true,
+			errMsg:  "MCPServers[bad]",
+		},
+		{
+			name:    "stdio without command",
+			content: `{"mcpServers": {"bad": {"type": "stdio"}}}`,
+			wantErr: true,
+			errMsg:  "MCPServers[bad]",
+		},
+		{
+			name:    "invalid JSON",
+			content: `{invalid`,
+			wantErr: true,
+		},
+		{
+			name:    "no servers",
+			content: `{"otherSetting": true}`,
+			want:    map[string]MCPServerConfig{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), ".mcp.json", tt.content)
+
+			got, err := LoadMCPConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadMCPConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if tt.errMsg != "" {
+					configErr, ok := err.(*ConfigError)
+					if !ok {
+						t.Fatalf("LoadMCPConfig() error type = %T, want *ConfigError", err)
+					}
+					if configErr.Field != tt.errMsg {
+						t.Errorf("ConfigError.Field = %v, want %v", configErr.Field, tt.errMsg)
+					}
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadMCPConfig() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMCPConfig_MissingFile(t *testing.T) {
+	_, err := LoadMCPConfig(filepath.Join(t.TempDir(), "missing.json"))
+	if err == nil {
+		t.Fatal("LoadMCPConfig() expected error for missing file")
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadMCPConfig() error = %v, want not-exist error", err)
+	}
+}
+
+func TestLoadMCPConfigs_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	user := writeTestFile(t, dir, "user.json", `{"mcpServers": {
+		"shared": {"command": "user-server"},
+		"user-only": {"type": "sse", "url": "https://user.example.com"}
+	}}`)
+	project := writeTestFile(t, dir, "project.json", `{"mcpServers": {
+		"shared": {"command": "project-server"}
+	}}`)
+
+	got, err := LoadMCPConfigs(user, filepath.Join(dir, "missing.json"), project)
+	if err != nil {
+		t.Fatalf("LoadMCPConfigs() error = %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("LoadMCPConfigs() returned %d servers, want 2", len(got))
+	}
+	shared, ok := got["shared"].(*MCPStdioServerConfig)
+	if !ok || shared.Command != "project-server" {
+		t.Errorf("shared server = %#v, want project-server to win", got["shared"])
+	}
+	if _, ok := got["user-only"].(*MCPSSEServerConfig); !ok {
+		t.Errorf("user-only server = %#v, want *MCPSSEServerConfig", got["user-only"])
+	}
+}
+
+func TestMergeMCPConfigs(t *testing.T) {
+	a := map[string]MCPServerConfig{"x": &MCPStdioServerConfig{Command: "a"}}
+	b := map[string]MCPServerConfig{"x": &MCPStdioServerConfig{Command: "b"}, "y": &MCPHTTPServerConfig{URL: "u"}}
+
+	got := MergeMCPConfigs(a, nil, b)
+	if len(got) != 2 {
+		t.Fatalf("MergeMCPConfigs() returned %d servers, want 2", len(got))
+	}
+	if got["x"].(*MCPStdioServerConfig).Command != "b" {
+		t.Errorf("MergeMCPConfigs() x = %#v, want later config to win", got["x"])
+	}
+}
+
+func TestWriteMCPConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "settings.json", `{"theme": "dark", "mcpServers": {"old": {"command": "old"}}}`)
+
+	servers := map[string]MCPServerConfig{
+		"local":  &MCPStdioServerConfig{Command: "node", Args: []string{"server.js"}, Env: map[string]string{"TOKEN": "${TOKEN}"}},
+		"events": MCPSSEServerConfig{URL: "https://example.com/sse"},
+		"api":    &MCPHTTPServerConfig{URL: "https://api.example.com", Headers: map[string]string{"X-Key": "k"}},
+	}
+	if err := WriteMCPConfig(path, servers); err != nil {
+		t.Fatalf("WriteMCPConfig() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read written file: %v", err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("written file is not valid JSON: %v", err)
+	}
+	if string(doc["theme"]) != `"dark"` {
+		t.Errorf("WriteMCPConfig() did not preserve unrelated keys: %s", data)
+	}
+	if strings.Contains(string(data), `"old"`) {
+		t.Errorf("WriteMCPConfig() kept replaced server: %s", data)
+	}
+	if !strings.Contains(string(data), `"${TOKEN}"`) {
+		t.Errorf("WriteMCPConfig() should write values verbatim: %s", data)
+	}
+
+	t.Setenv("TOKEN", "expanded")
+	got, err := LoadMCPConfig(path)
+	if err != nil {
+		t.Fatalf("LoadMCPConfig() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("round trip returned %d servers, want 3", len(got))
+	}
+	if local := got["local"].(*MCPStdioServerConfig); local.Env["TOKEN"] != "expanded" {
+		t.Errorf("round trip local env = %v, want expanded token", local.Env)
+	}
+	if _, ok := got["events"].(*MCPSSEServerConfig); !ok {
+		t.Errorf("round trip events = %#v, want *MCPSSEServerConfig", got["events"])
+	}
+}
+
+func TestWriteMCPConfig_NewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".mcp.json")
+
+	if err := WriteMCPConfig(path, map[string]MCPServerConfig{"local": &MCPStdioServerConfig{Command: "node"}}); err != nil {
+		t.Fatalf("WriteMCPConfig() error = %v", err)
+	}
+
+	got, err := LoadMCPConfig(path)
+	if err != nil {
+		t.Fatalf("LoadMCPConfig() error = %v", err)
+	}
+	if _, ok := got["local"].(*MCPStdioServerConfig); !ok {
+		t.Errorf("LoadMCPConfig() local = %#v, want *MCPStdioServerConfig", got["local"])
+	}
+}
+
+func TestWriteMCPConfig_NilServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".mcp.json")
+
+	err := WriteMCPConfig(path, map[string]MCPServerConfig{"bad": nil})
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("WriteMCPConfig() error = %v, want *ConfigError", err)
+	}
+	if _, statErr := os.Stat(path); !os.IsNotExist(statErr) {
+		t.Error("WriteMCPConfig() should not create a file on error")
+	}
+}
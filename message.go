@@ -52,14 +52,7 @@ func ParseMessage(line string) (Message, error) {
 		return &msg, nil
 
 	case "system":
-		var msg SystemMessage
-		if err := json.Unmarshal([]byte(line), &msg); err != nil {
-			return nil, &ParseError{
-				Line:    line,
-				Message: fmt.Sprintf("failed to parse system message: %v", err),
-			}
-		}
-		return &msg, nil
+		return parseSystemMessage(line)
 
 	case "permission_request":
 		var msg PermissionRequestMessage
@@ -71,6 +64,9 @@ func ParseMessage(line string) (Message, error) {
 		}
 		return &msg, nil
 
+	case controlRequestType:
+		return parseControlRequest(line)
+
 	default:
 		return nil, &ParseError{
 			Line:    line,
@@ -78,3 +74,57 @@ func ParseMessage(line string) (Message, error) {
 		}
 	}
 }
+
+// parseSystemMessage parses a system message into the type for its subtype,
+// falling back to SystemMessage for subtypes without a dedicated type
+func parseSystemMessage(line string) (Message, error) {
+	var base struct {
+		Subtype string `json:"subtype"`
+	}
+	if err := json.Unmarshal([]byte(line), &base); err != nil {
+		return nil, &ParseError{
+			Line:    line,
+			Message: fmt.Sprintf("failed to parse system message: %v", err),
+		}
+	}
+
+	var msg Message
+	switch base.Subtype {
+	case SystemSubtypeInit:
+		msg = &InitMessage{}
+	case SystemSubtypeCompactBoundary:
+		msg = &CompactBoundaryMessage{}
+	case SystemSubtypeHookResponse:
+		msg = &HookResponseMessage{}
+	case SystemSubtypeStatus:
+		msg = &StatusMessage{}
+	default:
+		msg = &SystemMessage{}
+	}
+
+	if err := json.Unmarshal([]byte(line), msg); err != nil {
+		return nil, &ParseError{
+			Line:    line,
+			Message: fmt.Sprintf("failed to parse system %s message: %v", base.Subtype, err),
+		}
+	}
+	return msg, nil
+}
+
+// parseControlRequest parses a control request sent by the CLI. Only
+// can_use_tool requests are messages; they become PermissionRequestMessages.
+func parseControlRequest(line string) (Message, error) {
+	var envelope controlRequestEnvelope
+	if err := json.Unmarshal([]byte(line), &envelope); err != nil {
+		return nil, &ParseError{
+			Line:    line,
+			Message: fmt.Sprintf("failed to parse control request: %v", err),
+		}
+	}
+
+	msg, err := decodePermissionRequest(envelope)
+	if err != nil {
+		return nil, &ParseError{Line: line, Message: err.Error()}
+	}
+	return msg, nil
+}
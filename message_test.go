@@ -2,6 +2,7 @@ package claude
 
 import (
 	"encoding/json"
+	"reflect"
 	"testing"
 )
 
@@ -67,11 +68,11 @@ func TestParseMessage(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name: "valid system message",
-			line: `{"type": "system", "subtype": "info", "apiKeySource": "env", "cwd": "/home/user", "session_id": "test-session", "tools": ["bash", "read"], "mcp_servers": [], "model": "claude-3", "permissionMode": "ask"}`,
-			want: &SystemMessage{
+			name: "valid system init message",
+			line: `{"type": "system", "subtype": "init", "apiKeySource": "env", "cwd": "/home/user", "session_id": "test-session", "tools": ["bash", "read"], "mcp_servers": [], "model": "claude-3", "permissionMode": "ask"}`,
+			want: &InitMessage{
 				Type:           "system",
-				Subtype:        "info",
+				Subtype:        "init",
 				APIKeySource:   "env",
 				CWD:            "/home/user",
 				SessionID:      "test-session",
@@ -167,6 +168,186 @@ func TestParseMessage_ParseError(t *testing.T) {
 	}
 }
 
+func TestParseMessage_FullResult(t *testing.T) {
+	line := `{"type":"result","subtype":"success","uuid":"u-1","is_error":false,"duration_ms":2000,"duration_api_ms":1500,"num_turns":3,"result":"Done","session_id":"s1","total_cost_usd":0.042,` +
+		`"usage":{"input_tokens":10,"output_tokens":20,"cache_read_input_tokens":5,"server_tool_use":{"web_search_requests":2},"service_tier":"standard"},` +
+		`"modelUsage":{"claude-sonnet-4-5":{"inputTokens":8,"outputTokens":15,"cacheReadInputTokens":5,"cacheCreationInputTokens":0,"webSearchRequests":2,"costUSD":0.04,"contextWindow":200000},` +
+		`"claude-haiku-4-5":{"inputTokens":2,"outputTokens":5,"cacheReadInputTokens":0,"cacheCreationInputTokens":0,"webSearchRequests":0,"costUSD":0.002}},` +
+		`"permission_denials":[{"tool_name":"Bash","tool_use_id":"t1","tool_input":{"command":"rm -rf /"}},{"tool_name":"Write","tool_use_id":"t2"},{"tool_name":"Bash","tool_use_id":"t3"}],` +
+		`"structured_output":{"answer":42},"future_field":{"nested":true}}`
+
+	msg, err := ParseMessage(line)
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+	result := msg.(*ResultMessage)
+
+	if result.UUID != "u-1" {
+		t.Errorf("UUID = %q, want u-1", result.UUID)
+	}
+	if result.Usage.ServiceTier != "standard" || result.Usage.ServerToolUse == nil || result.Usage.ServerToolUse.WebSearchRequests != 2 {
+		t.Errorf("Usage = %+v, want service tier and server tool use", result.Usage)
+	}
+	if got := result.ModelUsage["claude-sonnet-4-5"]; got.OutputTokens != 15 || got.ContextWindow != 200000 {
+		t.Errorf("ModelUsage[claude-sonnet-4-5] = %+v", got)
+	}
+	if got, want := result.CostByModel(), map[string]float64{"claude-sonnet-4-5": 0.04, "claude-haiku-4-5": 0.002}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CostByModel() = %v, want %v", got, want)
+	}
+	if got, want := result.DeniedTools(), []string{"Bash", "Write"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DeniedTools() = %v, want %v", got, want)
+	}
+	if got := string(result.PermissionDenials[0].ToolInput); got != `{"command":"rm -rf /"}` {
+		t.Errorf("PermissionDenials[0].ToolInput = %s", got)
+	}
+
+	var output struct {
+		Answer int `json:"answer"`
+	}
+	if err := result.DecodeStructuredOutput(&output); err != nil || output.Answer != 42 {
+		t.Errorf("DecodeStructuredOutput() = %+v, %v", output, err)
+	}
+
+	if len(result.Extra) != 1 || string(result.Extra["future_field"]) != `{"nested":true}` {
+		t.Errorf("Extra = %v, want only future_field", result.Extra)
+	}
+
+	// Unknown fields survive a round trip
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var decoded ResultMessage
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(&decoded, result) {
+		t.Errorf("round trip = %+v, want %+v", decoded, result)
+	}
+}
+
+func TestResultMessage_NoStructuredOutput(t *testing.T) {
+	result := &ResultMessage{}
+	var v map[string]any
+	if err := result.DecodeStructuredOutput(&v); err == nil {
+		t.Error("DecodeStructuredOutput() error = nil, want error")
+	}
+	if tools := result.DeniedTools(); tools != nil {
+		t.Errorf("DeniedTools() = %v, want nil", tools)
+	}
+}
+
+func TestParseMessage_SystemSubtypes(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want Message
+	}{
+		{
+			name: "init",
+			line: `{"type":"system","subtype":"init","uuid":"u1","session_id":"s1","cwd":"/repo","tools":["Bash"],"mcp_servers":[],"model":"claude-sonnet-4-5","permissionMode":"default","slash_commands":["compact","review"],"output_style":"default","agents":["general-purpose"],"claude_code_version":"2.0.0","apiKeySource":"none"}`,
+			want: &InitMessage{
+				Type: "system", Subtype: "init", UUID: "u1", SessionID: "s1", CWD: "/repo",
+				Tools: []string{"Bash"}, MCPServers: []MCPServerStatus{}, Model: "claude-sonnet-4-5",
+				PermissionMode: "default", SlashCommands: []string{"compact", "review"}, OutputStyle: "default",
+				Agents: []string{"general-purpose"}, ClaudeCodeVersion: "2.0.0", APIKeySource: "none",
+			},
+		},
+		{
+			name: "compact boundary",
+			line: `{"type":"system","subtype":"compact_boundary","session_id":"s1","uuid":"u2","compact_metadata":{"trigger":"auto","pre_tokens":150000}}`,
+			want: &CompactBoundaryMessage{
+				Type: "system", Subtype: "compact_boundary", SessionID: "s1", UUID: "u2",
+				CompactMetadata: CompactMetadata{Trigger: "auto", PreTokens: 150000},
+			},
+		},
+		{
+			name: "hook response",
+			line: `{"type":"system","subtype":"hook_response","session_id":"s1","hook_name":"SessionStart:startup","hook_event":"SessionStart","stdout":"ok","stderr":"","exit_code":0}`,
+			want: &HookResponseMessage{
+				Type: "system", Subtype: "hook_response", SessionID: "s1", HookName: "SessionStart:startup",
+				HookEvent: "SessionStart", Stdout: "ok", ExitCode: intPtr(0),
+			},
+		},
+		{
+			name: "status",
+			line: `{"type":"system","subtype":"status","session_id":"s1","status":"compacting"}`,
+			want: &StatusMessage{Type: "system", Subtype: "status", SessionID: "s1", Status: "compacting"},
+		},
+		{
+			name: "status cleared",
+			line: `{"type":"system","subtype":"status","session_id":"s1","status":null}`,
+			want: &StatusMessage{Type: "system", Subtype: "status", SessionID: "s1"},
+		},
+		{
+			name: "unknown subtype",
+			line: `{"type":"system","subtype":"informational","session_id":"s1","content":"heads up","level":"info"}`,
+			want: &SystemMessage{
+				Type: "system", Subtype: "informational", SessionID: "s1",
+				Extra: map[string]json.RawMessage{
+					"content": json.RawMessage(`"heads up"`),
+					"level":   json.RawMessage(`"info"`),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMessage(tt.line)
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMessage() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemMessage_RoundTrip(t *testing.T) {
+	line := `{"type":"system","subtype":"informational","session_id":"s1","content":"heads up"}`
+	msg, err := ParseMessage(line)
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var got, want map[string]any
+	_ = json.Unmarshal(data, &got)
+	_ = json.Unmarshal([]byte(line), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %s, want %s", data, line)
+	}
+}
+
+func TestParseMessage_ControlRequest(t *testing.T) {
+	line := `{"type":"control_request","request_id":"perm-1","request":{"subtype":"can_use_tool","tool_name":"Write","input":{"file_path":"/etc/hosts"},"blocked_path":"/etc/hosts"}}`
+	msg, err := ParseMessage(line)
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+	want := &PermissionRequestMessage{
+		Type:        "permission_request",
+		Subtype:     "can_use_tool",
+		RequestID:   "perm-1",
+		ToolName:    "Write",
+		Input:       json.RawMessage(`{"file_path":"/etc/hosts"}`),
+		BlockedPath: "/etc/hosts",
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("ParseMessage() = %+v, want %+v", msg, want)
+	}
+
+	_, err = ParseMessage(`{"type":"control_request","request_id":"r","request":{"subtype":"hook_callback"}}`)
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("ParseMessage() for unsupported control request error = %v, want *ParseError", err)
+	}
+}
+
 func TestMessageTypes(t *testing.T) {
 	tests := []struct {
 		name string
@@ -177,6 +358,10 @@ func TestMessageTypes(t *testing.T) {
 		{"AssistantMessage", &AssistantMessage{}, "assistant"},
 		{"ResultMessage", &ResultMessage{}, "result"},
 		{"SystemMessage", &SystemMessage{}, "system"},
+		{"InitMessage", &InitMessage{}, "system"},
+		{"CompactBoundaryMessage", &CompactBoundaryMessage{}, "system"},
+		{"HookResponseMessage", &HookResponseMessage{}, "system"},
+		{"StatusMessage", &StatusMessage{}, "system"},
 		{"PermissionRequestMessage", &PermissionRequestMessage{}, "permission_request"},
 	}
 
@@ -223,12 +408,12 @@ func messageEqual(a, b Message) bool {
 			return false
 		}
 		return resultMessagesEqual(va, vb)
-	case *SystemMessage:
-		vb, ok := b.(*SystemMessage)
+	case *InitMessage:
+		vb, ok := b.(*InitMessage)
 		if !ok {
 			return false
 		}
-		return systemMessageEqual(va, vb)
+		return initMessageEqual(va, vb)
 	case *PermissionRequestMessage:
 		vb, ok := b.(*PermissionRequestMessage)
 		if !ok {
@@ -288,7 +473,7 @@ func resultMessagesEqual(a, b *ResultMessage) bool {
 		a.Usage.CacheReadInputTokens == b.Usage.CacheReadInputTokens
 }
 
-func systemMessageEqual(a, b *SystemMessage) bool {
+func initMessageEqual(a, b *InitMessage) bool {
 	if a.Type != b.Type || a.Subtype != b.Subtype ||
 		a.APIKeySource != b.APIKeySource || a.CWD != b.CWD ||
 		a.SessionID != b.SessionID || a.Model != b.Model ||
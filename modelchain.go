@@ -0,0 +1,202 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ModelAttempt records a model in Options.ModelChain that failed
+type ModelAttempt struct {
+	Model string
+	// SessionID is the session the failed run started, which the next
+	// model resumes with FallbackContinuePrompt; empty if the run failed
+	// before starting one
+	SessionID string
+	Err       error
+}
+
+// ModelFallback describes the client moving from one model of
+// Options.ModelChain to the next
+type ModelFallback struct {
+	From      string
+	To        string
+	SessionID string
+	Err       error
+}
+
+// WithModelFallbackHandler sets a function called each time a query falls
+// back to the next model in Options.ModelChain, for example to count
+// fallbacks in metrics. It is called from the goroutine running the query.
+func WithModelFallbackHandler(handler func(ModelFallback)) ClientOption {
+	return func(c *clientImpl) {
+		c.onFallback = handler
+	}
+}
+
+// FallbackContinuePrompt is sent to the next model when it resumes the
+// session of a failed attempt. That session already holds the original
+// prompt, so sending it again would repeat the request.
+const FallbackContinuePrompt = "The previous attempt was interrupted by an API error. Continue from where it left off."
+
+// fallbackErrors are the failures that move a query to the next model
+var fallbackErrors = []error{ErrOverloaded, ErrRateLimited, ErrInvalidModel}
+
+// shouldFallback reports whether err means the next model should be tried.
+// Besides process errors, error results whose text names an API overload,
+// rate limit or invalid model qualify.
+func shouldFallback(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, target := range fallbackErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	var execErr *ExecutionError
+	if errors.As(err, &execErr) && execErr.Result.Result != "" {
+		classified := ClassifyProcessError(0, execErr.Result.Result)
+		for _, target := range fallbackErrors {
+			if classified == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// attemptOptions returns opts set up to run on model, resuming sessionID
+// if it is not empty
+func attemptOptions(opts *Options, model, sessionID string) *Options {
+	attempt := MergeOptions(nil, opts)
+	attempt.Model = model
+	if sessionID != "" {
+		attempt.Continue = false
+		attempt.Resume = sessionID
+		// The failed run already forked if asked to; continue its session
+		attempt.ForkSession = false
+	}
+	return attempt
+}
+
+// fallback records a failed attempt and notifies the handler
+func (c *clientImpl) fallback(attempts []ModelAttempt, from, to, sessionID string, err error) []ModelAttempt {
+	if c.onFallback != nil {
+		c.onFallback(ModelFallback{From: from, To: to, SessionID: sessionID, Err: err})
+	}
+	return append(attempts, ModelAttempt{Model: from, SessionID: sessionID, Err: err})
+}
+
+// queryChain runs a query on each model of opts.ModelChain in turn until one
+// answers or fails for a reason other than the model
+func (c *clientImpl) queryChain(ctx context.Context, prompt string, opts *Options) (*ResultMessage, error) {
+	models := opts.ModelChain
+	var attempts []ModelAttempt
+	sessionID := ""
+	for i, model := range models {
+		attemptPrompt := prompt
+		if sessionID != "" {
+			attemptPrompt = FallbackContinuePrompt
+		}
+		result, err := c.query(ctx, attemptPrompt, attemptOptions(opts, model, sessionID))
+		if i == len(models)-1 || !shouldFallback(err) {
+			if result != nil {
+				result.ModelAttempts = attempts
+			}
+			return result, err
+		}
+		if result != nil && result.SessionID != "" {
+			sessionID = result.SessionID
+		}
+		attempts = c.fallback(attempts, model, models[i+1], sessionID, err)
+	}
+	// Unreachable: the last model always returns
+	return nil, errors.New("empty model chain")
+}
+
+// streamChain streams a query on each model of opts.ModelChain in turn. The
+// messages of every attempt are delivered, except the error that triggers a
+// fallback.
+func (c *clientImpl) streamChain(ctx context.Context, input promptInput, opts *Options) (*MessageStream, error) {
+	models := opts.ModelChain
+	chainCtx, cancel := context.WithCancel(ctx)
+	spend := newSpendTracker(c.pricing)
+
+	first, err := c.streamResolved(chainCtx, input, attemptOptions(opts, models[0], ""), spend)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	messages := make(chan MessageOrError)
+	closed := make(chan struct{})
+	stream := &MessageStream{
+		Messages:   messages,
+		ctx:        chainCtx,
+		cancel:     cancel,
+		closed:     closed,
+		markClosed: sync.OnceFunc(func() { close(closed) }),
+		spend:      spend,
+	}
+	stream.current.Store(first)
+
+	go func() {
+		defer close(messages)
+		defer cancel()
+
+		current := first
+		var attempts []ModelAttempt
+		sessionID := ""
+		for i := 0; ; i++ {
+			var failure error
+			for item := range current.Messages {
+				switch m := item.Message.(type) {
+				case *InitMessage:
+					if m.SessionID != "" {
+						sessionID = m.SessionID
+					}
+				case *ResultMessage:
+					if m.SessionID != "" {
+						sessionID = m.SessionID
+					}
+					m.Model = models[i]
+					m.ModelAttempts = attempts
+				}
+				if i < len(models)-1 && shouldFallback(item.Err) {
+					failure = item.Err
+					continue
+				}
+				select {
+				case messages <- item:
+				case <-closed:
+					current.Close()
+					for range current.Messages {
+					}
+					return
+				}
+			}
+			if failure == nil {
+				return
+			}
+
+			attempts = c.fallback(attempts, models[i], models[i+1], sessionID, failure)
+			nextInput := input
+			if sessionID != "" {
+				nextInput = promptInput{text: FallbackContinuePrompt}
+			}
+			next, err := c.streamResolved(chainCtx, nextInput, attemptOptions(opts, models[i+1], sessionID), spend)
+			if err != nil {
+				select {
+				case messages <- MessageOrError{Err: err}:
+				case <-closed:
+				}
+				return
+			}
+			stream.current.Store(next)
+			current = next
+		}
+	}()
+
+	return stream, nil
+}
@@ -0,0 +1,267 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShouldFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "overloaded process", err: &ProcessError{ExitCode: 1, Message: "API Error: 529 Overloaded"}, want: true},
+		{name: "rate limited process", err: &ProcessError{ExitCode: 1, Message: "rate_limit_error"}, want: true},
+		{name: "invalid model process", err: &ProcessError{ExitCode: 1, Message: "invalid model: claude-foo"}, want: true},
+		{name: "not authenticated", err: &ProcessError{ExitCode: 1, Message: "Invalid API key"}, want: false},
+		{name: "overloaded result", err: &ExecutionError{ResultError{Result: &ResultMessage{Result: "API Error: Overloaded"}}}, want: true},
+		{name: "other result", err: &ExecutionError{ResultError{Result: &ResultMessage{Result: "tool failed"}}}, want: false},
+		{name: "max turns", err: &MaxTurnsError{ResultError{Result: &ResultMessage{}}}, want: false},
+		{name: "joined", err: errors.Join(errors.New("ledger"), &ProcessError{Message: "overloaded"}), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldFallback(tt.err); got != tt.want {
+				t.Errorf("shouldFallback(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_QueryModelChain(t *testing.T) {
+	var mu sync.Mutex
+	var calls [][]string
+	var prompts []string
+	executor := &MockCommandExecutor{
+		ExecuteFunc: func(_ context.Context, _ string, args []string, input string, _ string, _ map[string]string) ([]byte, error) {
+			mu.Lock()
+			calls = append(calls, args)
+			prompts = append(prompts, input)
+			mu.Unlock()
+			switch argValue(args, "--model") {
+			case "big":
+				// The run started a session before the API was overloaded
+				return []byte(`{"type":"result","subtype":"error_during_execution","is_error":true,"result":"API Error: 529 Overloaded","session_id":"s1"}`), nil
+			case "medium":
+				return nil, &ProcessError{ExitCode: 1, Message: "API Error: 429 rate_limit_error"}
+			default:
+				return []byte(`{"type":"result","subtype":"success","result":"done","session_id":"s1","total_cost_usd":0.5}`), nil
+			}
+		},
+	}
+	var fallbacks []ModelFallback
+	ledger := NewMemoryLedger()
+	client := NewClient(
+		WithExecutor(executor),
+		WithLedger(ledger),
+		WithModelFallbackHandler(func(f ModelFallback) { fallbacks = append(fallbacks, f) }),
+	)
+
+	result, err := client.Query(context.Background(), "hello", &Options{ModelChain: []string{"big", "medium", "small"}})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if result.Model != "small" || result.Result != "done" {
+		t.Errorf("result Model = %q, Result = %q, want small, done", result.Model, result.Result)
+	}
+
+	var attempted []string
+	for _, attempt := range result.ModelAttempts {
+		attempted = append(attempted, attempt.Model)
+	}
+	if !reflect.DeepEqual(attempted, []string{"big", "medium"}) {
+		t.Errorf("ModelAttempts = %v, want [big medium]", attempted)
+	}
+	if !errors.Is(result.ModelAttempts[1].Err, ErrRateLimited) {
+		t.Errorf("ModelAttempts[1].Err = %v, want ErrRateLimited", result.ModelAttempts[1].Err)
+	}
+
+	if len(fallbacks) != 2 || fallbacks[0].From != "big" || fallbacks[0].To != "medium" || fallbacks[0].SessionID != "s1" {
+		t.Errorf("fallbacks = %+v", fallbacks)
+	}
+
+	// Later attempts resume the session the first one started and ask it to
+	// continue rather than repeating the prompt it already holds
+	if len(calls) != 3 {
+		t.Fatalf("executed %d times, want 3", len(calls))
+	}
+	if hasArg(calls[0], "--resume") || prompts[0] != "hello" {
+		t.Errorf("first attempt args = %v, prompt = %q, want hello without --resume", calls[0], prompts[0])
+	}
+	for i, args := range calls[1:] {
+		if got := argValue(args, "--resume"); got != "s1" {
+			t.Errorf("fallback args = %v, want --resume s1", args)
+		}
+		if prompts[i+1] != FallbackContinuePrompt {
+			t.Errorf("fallback prompt = %q, want FallbackContinuePrompt", prompts[i+1])
+		}
+	}
+
+	// Each run that produced a result is recorded under its model
+	entries, _ := ledger.Entries(context.Background(), nil, time.Time{})
+	var recorded []string
+	for _, entry := range entries {
+		recorded = append(recorded, entry.Model)
+	}
+	if !reflect.DeepEqual(recorded, []string{"big", "small"}) {
+		t.Errorf("ledger models = %v, want [big small]", recorded)
+	}
+}
+
+func TestClient_QueryModelOverridesDefaultChain(t *testing.T) {
+	var models []string
+	executor := &MockCommandExecutor{
+		ExecuteFunc: func(_ context.Context, _ string, args []string, _ string, _ string, _ map[string]string) ([]byte, error) {
+			models = append(models, argValue(args, "--model"))
+			return []byte(`{"type":"result","subtype":"success","result":"done","session_id":"s1"}`), nil
+		},
+	}
+	client := NewClient(WithExecutor(executor), WithDefaults(&Options{ModelChain: []string{"opus", "sonnet"}}))
+
+	result, err := client.Query(context.Background(), "hello", &Options{Model: "haiku"})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if result.Model != "haiku" || !reflect.DeepEqual(models, []string{"haiku"}) {
+		t.Errorf("result Model = %q, executed models = %v, want haiku only", result.Model, models)
+	}
+}
+
+func TestClient_QueryModelChainStops(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		err       error
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "non-model error",
+			output:    `{"type":"result","subtype":"error_max_turns","is_error":true,"session_id":"s1"}`,
+			wantCalls: 1,
+			wantErr:   ErrMaxTurns,
+		},
+		{
+			name:      "every model overloaded",
+			err:       &ProcessError{ExitCode: 1, Message: "Overloaded"},
+			wantCalls: 2,
+			wantErr:   ErrOverloaded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			executor := &MockCommandExecutor{
+				ExecuteFunc: func(context.Context, string, []string, string, string, map[string]string) ([]byte, error) {
+					calls++
+					if tt.err != nil {
+						return nil, tt.err
+					}
+					return []byte(tt.output), nil
+				},
+			}
+			client := NewClientWithExecutor(executor)
+
+			_, err := client.Query(context.Background(), "hello", &Options{ModelChain: []string{"a", "b"}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Query() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("executed %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestClient_QueryStreamModelChain(t *testing.T) {
+	var mu sync.Mutex
+	var calls [][]string
+	var prompts []string
+	executor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(_ context.Context, _ string, args []string, input string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			mu.Lock()
+			calls = append(calls, args)
+			prompts = append(prompts, input)
+			mu.Unlock()
+			var lines []string
+			if argValue(args, "--model") == "big" {
+				lines = []string{
+					`{"type":"system","subtype":"init","session_id":"s1","model":"big"}`,
+					`{"type":"result","subtype":"error_during_execution","is_error":true,"result":"API Error: Overloaded","session_id":"s1"}`,
+				}
+			} else {
+				lines = []string{
+					`{"type":"assistant","message":{"id":"m1","model":"small","usage":{"input_tokens":10}},"session_id":"s1"}`,
+					`{"type":"result","subtype":"success","result":"done","session_id":"s1"}`,
+				}
+			}
+			return io.NopCloser(strings.NewReader(strings.Join(lines, "\n"))), nil
+		},
+	}
+	client := NewClientWithExecutor(executor)
+
+	stream, err := client.QueryStream(context.Background(), "hello", &Options{ModelChain: []string{"big", "small"}})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	messages, errs := drain(stream)
+	if len(errs) != 0 {
+		t.Fatalf("stream errors = %v", errs)
+	}
+
+	var types []string
+	for _, msg := range messages {
+		types = append(types, msg.messageType())
+	}
+	// The overloaded result is replaced by the next model's messages
+	if want := []string{"system", "assistant", "result"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("message types = %v, want %v", types, want)
+	}
+	result := messages[len(messages)-1].(*ResultMessage)
+	if result.Model != "small" || len(result.ModelAttempts) != 1 || result.ModelAttempts[0].Model != "big" {
+		t.Errorf("result Model = %q, ModelAttempts = %+v", result.Model, result.ModelAttempts)
+	}
+	if len(calls) != 2 || argValue(calls[1], "--resume") != "s1" || argValue(calls[1], "--model") != "small" {
+		t.Errorf("calls = %v, want the second to resume s1 on small", calls)
+	}
+	if !reflect.DeepEqual(prompts, []string{"hello", FallbackContinuePrompt}) {
+		t.Errorf("prompts = %q, want hello then FallbackContinuePrompt", prompts)
+	}
+	if got := stream.Spend().Usage.InputTokens; got != 10 {
+		t.Errorf("Spend().Usage.InputTokens = %d, want 10", got)
+	}
+}
+
+func TestClient_QueryStreamModelChainClose(t *testing.T) {
+	executor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(ctx context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			r, w := io.Pipe()
+			go func() {
+				w.Write([]byte(`{"type":"system","subtype":"init","session_id":"s1"}` + "\n"))
+				<-ctx.Done()
+				w.CloseWithError(ctx.Err())
+			}()
+			return r, nil
+		},
+	}
+	client := NewClientWithExecutor(executor)
+
+	stream, err := client.QueryStream(context.Background(), "hello", &Options{ModelChain: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	if item := <-stream.Messages; item.Message == nil {
+		t.Fatalf("first item = %+v, want init message", item)
+	}
+	stream.Close()
+	for range stream.Messages {
+	}
+}
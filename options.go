@@ -0,0 +1,257 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MergeOptions combines client defaults with per-call overrides and returns a
+// new Options; neither argument is modified. Fields are merged as follows:
+//
+//   - Scalars, strings and pointers: a non-zero override replaces the default.
+//     A false bool therefore cannot switch off a default of true.
+//   - Slices: a non-nil override (including an empty slice) replaces the default.
+//   - Maps: entries are merged by key, with override entries winning.
+//   - Model and ModelChain: an override Model without a ModelChain replaces
+//     the default chain too, so a single model can be picked per call.
+func MergeOptions(defaults, override *Options) *Options {
+	merged := &Options{}
+	if defaults != nil {
+		*merged = *defaults
+	}
+	if override == nil {
+		return cloneOptionMaps(merged)
+	}
+
+	dst := reflect.ValueOf(merged).Elem()
+	src := reflect.ValueOf(override).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		from := src.Field(i)
+		to := dst.Field(i)
+
+		if from.Kind() == reflect.Map {
+			to.Set(mergeMaps(to, from))
+			continue
+		}
+		if !from.IsZero() {
+			to.Set(from)
+		}
+	}
+	if override.Model != "" && override.ModelChain == nil {
+		merged.ModelChain = nil
+	}
+	return merged
+}
+
+// mergeMaps returns a new map holding the entries of base overlaid with override
+func mergeMaps(base, override reflect.Value) reflect.Value {
+	if base.IsNil() && override.IsNil() {
+		return base
+	}
+	out := reflect.MakeMapWithSize(base.Type(), base.Len()+override.Len())
+	for _, m := range []reflect.Value{base, override} {
+		iter := m.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), iter.Value())
+		}
+	}
+	return out
+}
+
+// cloneOptionMaps copies map fields so the merged Options never aliases the
+// caller's maps
+func cloneOptionMaps(opts *Options) *Options {
+	v := reflect.ValueOf(opts).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Map && !field.IsNil() {
+			field.Set(mergeMaps(field, reflect.Zero(field.Type())))
+		}
+	}
+	return opts
+}
+
+// optionsAlias has the fields of Options without its JSON methods
+type optionsAlias Options
+
+// optionsJSON is the wire form of Options with MCP servers in .mcp.json form
+// and durations as strings such as "30s"
+type optionsJSON struct {
+	*optionsAlias
+	MCPServers        map[string]mcpServerJSON `json:"mcp_servers,omitempty"`
+	PermissionTimeout jsonDuration             `json:"permission_timeout,omitempty"`
+	IdleTimeout       jsonDuration             `json:"idle_timeout,omitempty"`
+	TurnTimeout       jsonDuration             `json:"turn_timeout,omitempty"`
+}
+
+// jsonDuration is a time.Duration encoded as a Go duration string
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %s", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = jsonDuration(parsed)
+	return nil
+}
+
+// MarshalJSON encodes Options, writing each MCP server with a "type"
+// discriminator and durations as strings such as "30s"
+func (o Options) MarshalJSON() ([]byte, error) {
+	alias := optionsAlias(o)
+	wire := optionsJSON{
+		optionsAlias:      &alias,
+		PermissionTimeout: jsonDuration(o.PermissionTimeout),
+		IdleTimeout:       jsonDuration(o.IdleTimeout),
+		TurnTimeout:       jsonDuration(o.TurnTimeout),
+	}
+
+	if len(o.MCPServers) > 0 {
+		wire.MCPServers = make(map[string]mcpServerJSON, len(o.MCPServers))
+		for name, server := range o.MCPServers {
+			entry, err := mcpServerToJSON(name, server)
+			if err != nil {
+				return nil, err
+			}
+			wire.MCPServers[name] = entry
+		}
+	}
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON decodes Options, restoring MCP servers to their concrete config types
+func (o *Options) UnmarshalJSON(data []byte) error {
+	var alias optionsAlias
+	wire := optionsJSON{optionsAlias: &alias}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+
+	if wire.MCPServers != nil {
+		alias.MCPServers = make(map[string]MCPServerConfig, len(wire.MCPServers))
+		for name, entry := range wire.MCPServers {
+			server, err := entry.toConfig(name)
+			if err != nil {
+				return err
+			}
+			alias.MCPServers[name] = server
+		}
+	}
+
+	alias.PermissionTimeout = time.Duration(wire.PermissionTimeout)
+	alias.IdleTimeout = time.Duration(wire.IdleTimeout)
+	alias.TurnTimeout = time.Duration(wire.TurnTimeout)
+	*o = Options(alias)
+	return nil
+}
+
+// profileFile is the layout of a profile configuration file
+type profileFile struct {
+	// Defaults apply to every profile
+	Defaults *Options `json:"defaults"`
+	// Profiles holds named option sets merged over Defaults
+	Profiles map[string]*Options `json:"profiles"`
+}
+
+// LoadProfile reads the named profile from a JSON profile file of the form
+//
+//	{"defaults": {...}, "profiles": {"name": {...}}}
+//
+// The profile is merged over the file's defaults, and CLAUDE_GO_* environment
+// variables (see OptionsFromEnv) are applied on top.
+func LoadProfile(path, name string) (*Options, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile file %s: %w", path, err)
+	}
+
+	var file profileFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse profile file %s: %w", path, err)
+	}
+
+	profile, ok := file.Profiles[name]
+	if !ok {
+		return nil, &ConfigError{Field: "profile", Value: name, Reason: fmt.Sprintf("profile not found in %s", path)}
+	}
+
+	overlay, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return MergeOptions(MergeOptions(file.Defaults, profile), overlay), nil
+}
+
+// envOverlay describes how one CLAUDE_GO_* variable maps onto Options
+type envOverlay struct {
+	name  string
+	apply func(opts *Options, value string) error
+}
+
+// envOverlays lists the environment variables understood by OptionsFromEnv
+var envOverlays = []envOverlay{
+	{"CLAUDE_GO_MODEL", func(o *Options, v string) error { o.Model = v; return nil }},
+	{"CLAUDE_GO_FALLBACK_MODEL", func(o *Options, v string) error { o.FallbackModel = v; return nil }},
+	{"CLAUDE_GO_PERMISSION_MODE", func(o *Options, v string) error { o.PermissionMode = PermissionMode(v); return nil }},
+	{"CLAUDE_GO_WORKING_DIR", func(o *Options, v string) error { o.WorkingDir = v; return nil }},
+	{"CLAUDE_GO_EXECUTABLE", func(o *Options, v string) error { o.PathToClaudeCodeExecutable = v; return nil }},
+	{"CLAUDE_GO_APPEND_SYSTEM_PROMPT", func(o *Options, v string) error { o.AppendSystemPrompt = v; return nil }},
+	{"CLAUDE_GO_ALLOWED_TOOLS", func(o *Options, v string) error { o.AllowedTools = splitList(v); return nil }},
+	{"CLAUDE_GO_DISALLOWED_TOOLS", func(o *Options, v string) error { o.DisallowedTools = splitList(v); return nil }},
+	{"CLAUDE_GO_MAX_TURNS", func(o *Options, v string) error { return parseIntOverlay(&o.MaxTurns, v) }},
+	{"CLAUDE_GO_MAX_THINKING_TOKENS", func(o *Options, v string) error { return parseIntOverlay(&o.MaxThinkingTokens, v) }},
+}
+
+// OptionsFromEnv builds Options from CLAUDE_GO_* environment variables such as
+// CLAUDE_GO_MODEL, CLAUDE_GO_PERMISSION_MODE and CLAUDE_GO_MAX_TURNS. Tool lists
+// are comma-separated. Unset variables leave the corresponding field zero, so
+// the result can be merged over other Options with MergeOptions.
+func OptionsFromEnv() (*Options, error) {
+	opts := &Options{}
+	for _, overlay := range envOverlays {
+		value, ok := os.LookupEnv(overlay.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := overlay.apply(opts, value); err != nil {
+			return nil, &ConfigError{Field: overlay.name, Value: value, Reason: err.Error()}
+		}
+	}
+	return opts, nil
+}
+
+// splitList splits a comma-separated list, trimming blanks
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseIntOverlay parses an integer environment value into dst
+func parseIntOverlay(dst **int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return errors.New("must be an integer")
+	}
+	*dst = &n
+	return nil
+}
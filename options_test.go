@@ -0,0 +1,259 @@
+package claude
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeOptions(t *testing.T) {
+	defaults := &Options{
+		Model:        "claude-3-sonnet",
+		AllowedTools: []string{"Read", "Grep"},
+		MaxTurns:     intPtr(5),
+		Continue:     true,
+		Env:          map[string]string{"A": "default", "B": "default"},
+		MCPServers: map[string]MCPServerConfig{
+			"shared": &MCPStdioServerConfig{Command: "default"},
+			"base":   &MCPHTTPServerConfig{URL: "https://base.example.com"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		defaults *Options
+		override *Options
+		want     *Options
+	}{
+		{
+			name: "both nil",
+			want: &Options{},
+		},
+		{
+			name:     "nil override returns defaults",
+			defaults: defaults,
+			want:     defaults,
+		},
+		{
+			name:     "nil defaults returns override",
+			override: &Options{Model: "claude-3-opus"},
+			want:     &Options{Model: "claude-3-opus"},
+		},
+		{
+			name:     "field by field override",
+			defaults: defaults,
+			override: &Options{
+				Model:        "claude-3-opus",
+				AllowedTools: []string{},
+				Env:          map[string]string{"B": "override", "C": "override"},
+				MCPServers: map[string]MCPServerConfig{
+					"shared": &MCPStdioServerConfig{Command: "override"},
+				},
+			},
+			want: &Options{
+				Model:        "claude-3-opus",
+				AllowedTools: []string{},
+				MaxTurns:     intPtr(5),
+				Continue:     true,
+				Env:          map[string]string{"A": "default", "B": "override", "C": "override"},
+				MCPServers: map[string]MCPServerConfig{
+					"shared": &MCPStdioServerConfig{Command: "override"},
+					"base":   &MCPHTTPServerConfig{URL: "https://base.example.com"},
+				},
+			},
+		},
+		{
+			name:     "nil slice inherits default",
+			defaults: &Options{AllowedTools: []string{"Read"}},
+			override: &Options{MaxTurns: intPtr(1)},
+			want:     &Options{AllowedTools: []string{"Read"}, MaxTurns: intPtr(1)},
+		},
+		{
+			name:     "model replaces default chain",
+			defaults: &Options{ModelChain: []string{"opus", "sonnet"}},
+			override: &Options{Model: "haiku"},
+			want:     &Options{Model: "haiku"},
+		},
+		{
+			name:     "chain inherited without model",
+			defaults: &Options{ModelChain: []string{"opus", "sonnet"}},
+			override: &Options{MaxTurns: intPtr(1)},
+			want:     &Options{ModelChain: []string{"opus", "sonnet"}, MaxTurns: intPtr(1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MergeOptions(tt.defaults, tt.override)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeOptions_DoesNotAlias(t *testing.T) {
+	defaults := &Options{Env: map[string]string{"A": "1"}}
+	override := &Options{Env: map[string]string{"B": "2"}}
+
+	merged := MergeOptions(defaults, override)
+	merged.Env["C"] = "3"
+
+	if len(defaults.Env) != 1 || len(override.Env) != 1 {
+		t.Errorf("MergeOptions() result aliases input maps: defaults=%v override=%v", defaults.Env, override.Env)
+	}
+
+	cloned := MergeOptions(defaults, nil)
+	cloned.Env["D"] = "4"
+	if _, ok := defaults.Env["D"]; ok {
+		t.Error("MergeOptions() with nil override aliases default maps")
+	}
+}
+
+func TestOptions_JSONRoundTrip(t *testing.T) {
+	opts := &Options{
+		Model:          "claude-3-opus",
+		AllowedTools:   []string{"Read"},
+		MaxTurns:       intPtr(3),
+		PermissionMode: PermissionPlan,
+		IdleTimeout:    30 * time.Second,
+		TurnTimeout:    15 * time.Minute,
+		SettingSources: []SettingSource{},
+		Env:            map[string]string{"ANTHROPIC_BASE_URL": "https://proxy.example.com"},
+		ExtraArgs:      map[string]*string{"debug": nil, "session-name": stringPtr("x")},
+		Agents: map[string]AgentDefinition{
+			"reviewer": {Description: "Reviews code", Prompt: "Review"},
+		},
+		MCPServers: map[string]MCPServerConfig{
+			"local":  &MCPStdioServerConfig{Command: "node", Args: []string{"server.js"}},
+			"events": &MCPSSEServerConfig{URL: "https://example.com/sse"},
+			"api":    MCPHTTPServerConfig{URL: "https://api.example.com"},
+		},
+	}
+
+	data, err := json.Marshal(opts)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	for _, want := range []string{`"model":"claude-3-opus"`, `"mcp_servers":`, `"type":"sse"`, `"setting_sources":[]`, `"idle_timeout":"30s"`, `"turn_timeout":"15m0s"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("json.Marshal() = %s, want to contain %s", data, want)
+		}
+	}
+
+	var got Options
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	// Value configs come back as pointers
+	opts.MCPServers["api"] = &MCPHTTPServerConfig{URL: "https://api.example.com"}
+	if !reflect.DeepEqual(&got, opts) {
+		t.Errorf("round trip = %+v, want %+v", got, *opts)
+	}
+}
+
+func TestOptions_UnmarshalJSON_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"invalid JSON", `{"model": `},
+		{"unknown MCP type", `{"mcp_servers": {"bad": {"type": "ws", "url": "ws://x"}}}`},
+		{"wrong field type", `{"max_turns": "three"}`},
+		{"duration as number", `{"idle_timeout": 30000000000}`},
+		{"invalid duration", `{"turn_timeout": "soon"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts Options
+			if err := json.Unmarshal([]byte(tt.data), &opts); err == nil {
+				t.Error("json.Unmarshal() expected error")
+			}
+		})
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "profiles.json", `{
+		"defaults": {"model": "claude-3-sonnet", "allowed_tools": ["Read"], "env": {"A": "1"}},
+		"profiles": {
+			"review": {"append_system_prompt": "Review carefully", "env": {"B": "2"}},
+			"fast": {"model": "claude-3-haiku", "max_turns": 1,
+				"mcp_servers": {"docs": {"type": "http", "url": "https://docs.example.com"}}}
+		}
+	}`)
+
+	t.Run("merges defaults", func(t *testing.T) {
+		got, err := LoadProfile(path, "review")
+		if err != nil {
+			t.Fatalf("LoadProfile() error = %v", err)
+		}
+		want := &Options{
+			Model:              "claude-3-sonnet",
+			AllowedTools:       []string{"Read"},
+			AppendSystemPrompt: "Review carefully",
+			Env:                map[string]string{"A": "1", "B": "2"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("LoadProfile() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("environment overlay", func(t *testing.T) {
+		t.Setenv("CLAUDE_GO_MODEL", "claude-3-opus")
+		t.Setenv("CLAUDE_GO_MAX_TURNS", "7")
+
+		got, err := LoadProfile(path, "fast")
+		if err != nil {
+			t.Fatalf("LoadProfile() error = %v", err)
+		}
+		if got.Model != "claude-3-opus" {
+			t.Errorf("Model = %v, want claude-3-opus", got.Model)
+		}
+		if got.MaxTurns == nil || *got.MaxTurns != 7 {
+			t.Errorf("MaxTurns = %v, want 7", got.MaxTurns)
+		}
+		if _, ok := got.MCPServers["docs"].(*MCPHTTPServerConfig); !ok {
+			t.Errorf("MCPServers[docs] = %#v, want *MCPHTTPServerConfig", got.MCPServers["docs"])
+		}
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		_, err := LoadProfile(path, "missing")
+		if _, ok := err.(*ConfigError); !ok {
+			t.Errorf("LoadProfile() error = %v, want *ConfigError", err)
+		}
+	})
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv("CLAUDE_GO_MODEL", "claude-3-opus")
+	t.Setenv("CLAUDE_GO_PERMISSION_MODE", "acceptEdits")
+	t.Setenv("CLAUDE_GO_ALLOWED_TOOLS", "Read, Grep,,Bash")
+	t.Setenv("CLAUDE_GO_MAX_THINKING_TOKENS", "2000")
+
+	got, err := OptionsFromEnv()
+	if err != nil {
+		t.Fatalf("OptionsFromEnv() error = %v", err)
+	}
+	want := &Options{
+		Model:             "claude-3-opus",
+		PermissionMode:    PermissionAcceptEdits,
+		AllowedTools:      []string{"Read", "Grep", "Bash"},
+		MaxThinkingTokens: intPtr(2000),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OptionsFromEnv() = %+v, want %+v", got, want)
+	}
+
+	t.Setenv("CLAUDE_GO_MAX_TURNS", "many")
+	_, err = OptionsFromEnv()
+	configErr, ok := err.(*ConfigError)
+	if !ok || configErr.Field != "CLAUDE_GO_MAX_TURNS" {
+		t.Errorf("OptionsFromEnv() error = %v, want ConfigError for CLAUDE_GO_MAX_TURNS", err)
+	}
+}
@@ -0,0 +1,318 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownModelPricing is returned when no pricing is registered for a model
+var ErrUnknownModelPricing = errors.New("no pricing for model")
+
+// ModelPricing holds a model's rates in USD per million tokens
+type ModelPricing struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheWrite float64 `json:"cache_write"`
+	CacheRead  float64 `json:"cache_read"`
+	// WebSearch is the price in USD of one web search request
+	WebSearch float64 `json:"web_search,omitempty"`
+}
+
+// Cost returns the cost in USD of usage at these rates
+func (p ModelPricing) Cost(usage Usage) float64 {
+	cost := (float64(usage.InputTokens)*p.Input +
+		float64(usage.OutputTokens)*p.Output +
+		float64(usage.CacheCreationInputTokens)*p.CacheWrite +
+		float64(usage.CacheReadInputTokens)*p.CacheRead) / 1e6
+	if usage.ServerToolUse != nil {
+		cost += float64(usage.ServerToolUse.WebSearchRequests) * p.WebSearch
+	}
+	return cost
+}
+
+// webSearchPrice is the price of one web search request
+const webSearchPrice = 0.01
+
+// defaultPricing lists published rates for Claude models
+var defaultPricing = map[string]ModelPricing{
+	"claude-opus-4-5":   {Input: 5, Output: 25, CacheWrite: 6.25, CacheRead: 0.5, WebSearch: webSearchPrice},
+	"claude-opus-4-1":   {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.5, WebSearch: webSearchPrice},
+	"claude-opus-4":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.5, WebSearch: webSearchPrice},
+	"claude-sonnet-4-5": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3, WebSearch: webSearchPrice},
+	"claude-sonnet-4":   {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3, WebSearch: webSearchPrice},
+	"claude-3-7-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3, WebSearch: webSearchPrice},
+	"claude-3-5-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3, WebSearch: webSearchPrice},
+	"claude-haiku-4-5":  {Input: 1, Output: 5, CacheWrite: 1.25, CacheRead: 0.1, WebSearch: webSearchPrice},
+	"claude-3-5-haiku":  {Input: 0.8, Output: 4, CacheWrite: 1, CacheRead: 0.08, WebSearch: webSearchPrice},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25, CacheWrite: 0.3, CacheRead: 0.03, WebSearch: webSearchPrice},
+	"claude-3-opus":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.5, WebSearch: webSearchPrice},
+}
+
+// defaultAliases maps the CLI's model aliases to registered models
+var defaultAliases = map[string]string{
+	"opus":   "claude-opus-4-5",
+	"sonnet": "claude-sonnet-4-5",
+	"haiku":  "claude-haiku-4-5",
+}
+
+// PricingRegistry maps model names to their pricing. It is safe for
+// concurrent use.
+type PricingRegistry struct {
+	mu      sync.RWMutex
+	models  map[string]ModelPricing
+	aliases map[string]string
+}
+
+// DefaultPricing is the registry used by EstimateCost and by clients created
+// without WithPricing. Load a JSON file into it to override rates globally.
+var DefaultPricing = NewPricingRegistry()
+
+// NewPricingRegistry returns a registry holding the published rates for
+// Claude models and the CLI's model aliases
+func NewPricingRegistry() *PricingRegistry {
+	r := &PricingRegistry{
+		models:  make(map[string]ModelPricing, len(defaultPricing)),
+		aliases: make(map[string]string, len(defaultAliases)),
+	}
+	for model, pricing := range defaultPricing {
+		r.models[model] = pricing
+	}
+	for alias, model := range defaultAliases {
+		r.aliases[alias] = model
+	}
+	return r
+}
+
+// LoadPricingFile returns the default registry with the rates in the JSON
+// file at path applied over it
+func LoadPricingFile(path string) (*PricingRegistry, error) {
+	r := NewPricingRegistry()
+	if err := r.LoadFile(path); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// pricingFile is the JSON format read by PricingRegistry.Load
+type pricingFile struct {
+	Models  map[string]ModelPricing `json:"models"`
+	Aliases map[string]string       `json:"aliases,omitempty"`
+}
+
+// Load applies pricing from JSON of the form
+//
+//	{"models": {"claude-sonnet-4-5": {"input": 3, "output": 15, "cache_write": 3.75, "cache_read": 0.3}},
+//	 "aliases": {"sonnet": "claude-sonnet-4-5"}}
+//
+// Listed models and aliases replace existing entries; others are kept.
+func (r *PricingRegistry) Load(reader io.Reader) error {
+	var file pricingFile
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return fmt.Errorf("failed to parse pricing: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for model, pricing := range file.Models {
+		r.models[model] = pricing
+	}
+	for alias, model := range file.Aliases {
+		r.aliases[alias] = model
+	}
+	return nil
+}
+
+// LoadFile applies pricing from the JSON file at path, see Load
+func (r *PricingRegistry) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open pricing file: %w", err)
+	}
+	defer f.Close()
+	if err := r.Load(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Set registers pricing for model
+func (r *PricingRegistry) Set(model string, pricing ModelPricing) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.models[model] = pricing
+}
+
+// SetAlias makes alias resolve to model
+func (r *PricingRegistry) SetAlias(alias, model string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.aliases[alias] = model
+}
+
+// Models returns the registered model names, sorted
+func (r *PricingRegistry) Models() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	models := make([]string, 0, len(r.models))
+	for model := range r.models {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// Lookup returns the pricing for model. Besides exact names it resolves
+// aliases such as "sonnet", dated versions such as
+// "claude-sonnet-4-5-20250929" by their longest registered prefix, and
+// provider-qualified IDs such as "us.anthropic.claude-sonnet-4-5-v1:0".
+func (r *PricingRegistry) Lookup(model string) (ModelPricing, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Context window variants such as "claude-sonnet-4-5[1m]" share rates
+	if i := strings.IndexByte(model, '['); i > 0 {
+		model = model[:i]
+	}
+	if alias, ok := r.aliases[model]; ok {
+		model = alias
+	}
+	if pricing, ok := r.models[model]; ok {
+		return pricing, true
+	}
+	if i := strings.Index(model, "claude-"); i > 0 {
+		model = model[i:]
+	}
+
+	best := ""
+	for name := range r.models {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPricing{}, false
+	}
+	return r.models[best], true
+}
+
+// EstimateCost returns the cost in USD of usage on model
+func (r *PricingRegistry) EstimateCost(model string, usage Usage) (float64, error) {
+	pricing, ok := r.Lookup(model)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownModelPricing, model)
+	}
+	return pricing.Cost(usage), nil
+}
+
+// EstimatePromptCost estimates the cost of sending prompt to model and
+// receiving outputTokens in reply, using EstimateTokens for the prompt. The
+// CLI's own system prompt and tool definitions are not included.
+func (r *PricingRegistry) EstimatePromptCost(model, prompt string, outputTokens int) (float64, error) {
+	return r.EstimateCost(model, Usage{
+		InputTokens:  EstimateTokens(prompt),
+		OutputTokens: outputTokens,
+	})
+}
+
+// EstimateCost returns the cost in USD of usage on model using DefaultPricing
+func EstimateCost(model string, usage Usage) (float64, error) {
+	return DefaultPricing.EstimateCost(model, usage)
+}
+
+// Spend is the estimated cost of a stream so far. ResultMessage.TotalCostUSD
+// remains the authoritative cost once the result arrives.
+type Spend struct {
+	// Usage sums the usage of every assistant message seen
+	Usage Usage
+	// CostUSD is the estimated cost of Usage on the models that produced it
+	CostUSD float64
+	// ByModel breaks CostUSD down by model
+	ByModel map[string]float64
+	// Unpriced lists models without pricing whose usage is not in CostUSD
+	Unpriced []string
+}
+
+// spendTracker estimates the cost of assistant messages as they stream
+type spendTracker struct {
+	pricing *PricingRegistry
+
+	mu sync.Mutex
+	// messages holds the latest usage of each API message. The CLI emits
+	// one assistant message per content block, repeating the message ID.
+	messages map[string]modelUsageSample
+	order    []string
+}
+
+type modelUsageSample struct {
+	model string
+	usage Usage
+}
+
+func newSpendTracker(pricing *PricingRegistry) *spendTracker {
+	if pricing == nil {
+		pricing = DefaultPricing
+	}
+	return &spendTracker{pricing: pricing, messages: make(map[string]modelUsageSample)}
+}
+
+// observe records the usage reported by an assistant message
+func (t *spendTracker) observe(msg Message) {
+	m, ok := msg.(*AssistantMessage)
+	if !ok || len(m.Message) == 0 {
+		return
+	}
+	var payload struct {
+		ID    string `json:"id"`
+		Model string `json:"model"`
+		Usage *Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(m.Message, &payload); err != nil || payload.Usage == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := payload.ID
+	if id == "" {
+		id = fmt.Sprintf("#%d", len(t.order))
+	}
+	if _, seen := t.messages[id]; !seen {
+		t.order = append(t.order, id)
+	}
+	t.messages[id] = modelUsageSample{model: payload.Model, usage: *payload.Usage}
+}
+
+// spend returns the totals so far
+func (t *spendTracker) spend() Spend {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var spend Spend
+	unpriced := map[string]bool{}
+	for _, id := range t.order {
+		sample := t.messages[id]
+		spend.Usage = spend.Usage.Add(sample.usage)
+		cost, err := t.pricing.EstimateCost(sample.model, sample.usage)
+		if err != nil {
+			if !unpriced[sample.model] {
+				unpriced[sample.model] = true
+				spend.Unpriced = append(spend.Unpriced, sample.model)
+			}
+			continue
+		}
+		if spend.ByModel == nil {
+			spend.ByModel = make(map[string]float64)
+		}
+		spend.ByModel[sample.model] += cost
+		spend.CostUSD += cost
+	}
+	return spend
+}
@@ -0,0 +1,189 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestPricingRegistry_Lookup(t *testing.T) {
+	r := NewPricingRegistry()
+	sonnet := defaultPricing["claude-sonnet-4-5"]
+	sonnet4 := defaultPricing["claude-sonnet-4"]
+
+	tests := []struct {
+		model  string
+		want   ModelPricing
+		wantOK bool
+	}{
+		{model: "claude-sonnet-4-5", want: sonnet, wantOK: true},
+		{model: "sonnet", want: sonnet, wantOK: true},
+		{model: "claude-sonnet-4-5-20250929", want: sonnet, wantOK: true},
+		{model: "claude-sonnet-4-20250514", want: sonnet4, wantOK: true},
+		{model: "claude-sonnet-4-5[1m]", want: sonnet, wantOK: true},
+		{model: "us.anthropic.claude-sonnet-4-5-20250929-v1:0", want: sonnet, wantOK: true},
+		{model: "gpt-4", wantOK: false},
+		{model: "", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got, ok := r.Lookup(tt.model)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("Lookup(%q) = %+v, %v, want %+v, %v", tt.model, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPricingRegistry_EstimateCost(t *testing.T) {
+	r := NewPricingRegistry()
+	r.Set("test-model", ModelPricing{Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3, WebSearch: 0.01})
+
+	cost, err := r.EstimateCost("test-model", Usage{
+		InputTokens:              1_000_000,
+		OutputTokens:             100_000,
+		CacheCreationInputTokens: 200_000,
+		CacheReadInputTokens:     1_000_000,
+		ServerToolUse:            &ServerToolUse{WebSearchRequests: 5},
+	})
+	if err != nil {
+		t.Fatalf("EstimateCost() error = %v", err)
+	}
+	// 3 + 1.5 + 0.75 + 0.3 + 0.05
+	if !approxEqual(cost, 5.6) {
+		t.Errorf("EstimateCost() = %v, want 5.6", cost)
+	}
+
+	if _, err := r.EstimateCost("unknown", Usage{}); !errors.Is(err, ErrUnknownModelPricing) {
+		t.Errorf("EstimateCost(unknown) error = %v, want ErrUnknownModelPricing", err)
+	}
+}
+
+func TestPricingRegistry_EstimatePromptCost(t *testing.T) {
+	r := NewPricingRegistry()
+	r.Set("test-model", ModelPricing{Input: 1_000_000, Output: 2_000_000})
+
+	prompt := "Summarise the repository layout."
+	cost, err := r.EstimatePromptCost("test-model", prompt, 10)
+	if err != nil {
+		t.Fatalf("EstimatePromptCost() error = %v", err)
+	}
+	if want := float64(EstimateTokens(prompt) + 20); !approxEqual(cost, want) {
+		t.Errorf("EstimatePromptCost() = %v, want %v", cost, want)
+	}
+}
+
+func TestPricingRegistry_Load(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "pricing.json", `{
+		"models": {
+			"claude-sonnet-4-5": {"input": 2, "output": 10, "cache_write": 2.5, "cache_read": 0.2},
+			"internal-model": {"input": 1, "output": 1, "cache_write": 1, "cache_read": 1}
+		},
+		"aliases": {"internal": "internal-model"}
+	}`)
+
+	r, err := LoadPricingFile(path)
+	if err != nil {
+		t.Fatalf("LoadPricingFile() error = %v", err)
+	}
+	if got, _ := r.Lookup("sonnet"); got.Input != 2 || got.WebSearch != 0 {
+		t.Errorf("Lookup(sonnet) = %+v, want overridden rates", got)
+	}
+	if got, ok := r.Lookup("internal"); !ok || got.Output != 1 {
+		t.Errorf("Lookup(internal) = %+v, %v", got, ok)
+	}
+	if _, ok := r.Lookup("claude-3-haiku"); !ok {
+		t.Error("Lookup(claude-3-haiku) missing, want defaults kept")
+	}
+	if got, _ := NewPricingRegistry().Lookup("sonnet"); got.Input != 3 {
+		t.Errorf("new registry Input = %v, want defaults unaffected", got.Input)
+	}
+
+	if err := r.Load(strings.NewReader(`{"models": {"m": {"inptu": 1}}}`)); err == nil {
+		t.Error("Load() error = nil, want unknown field error")
+	}
+	if _, err := LoadPricingFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadPricingFile() error = nil, want error for missing file")
+	}
+}
+
+func TestMessageStream_Spend(t *testing.T) {
+	lines := []string{
+		`{"type":"system","subtype":"init","session_id":"s1","model":"claude-sonnet-4-5"}`,
+		// The CLI repeats a message's usage for each content block
+		`{"type":"assistant","message":{"id":"msg_1","model":"claude-sonnet-4-5","usage":{"input_tokens":1000,"output_tokens":100}},"session_id":"s1"}`,
+		`{"type":"assistant","message":{"id":"msg_1","model":"claude-sonnet-4-5","usage":{"input_tokens":1000,"output_tokens":200}},"session_id":"s1"}`,
+		`{"type":"assistant","message":{"id":"msg_2","model":"claude-haiku-4-5","usage":{"input_tokens":2000,"cache_read_input_tokens":1000}},"session_id":"s1"}`,
+		`{"type":"assistant","message":{"id":"msg_3","model":"mystery","usage":{"input_tokens":5}},"session_id":"s1"}`,
+		`{"type":"result","subtype":"success","session_id":"s1","total_cost_usd":0.01}`,
+	}
+	executor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(context.Context, string, []string, string, string, map[string]string) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(strings.Join(lines, "\n"))), nil
+		},
+	}
+	pricing := NewPricingRegistry()
+	client := NewClient(WithExecutor(executor), WithPricing(pricing))
+
+	stream, err := client.QueryStream(context.Background(), "hi", nil)
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	for range stream.Messages {
+	}
+
+	spend := stream.Spend()
+	wantUsage := Usage{InputTokens: 3005, OutputTokens: 200, CacheReadInputTokens: 1000}
+	if !reflect.DeepEqual(spend.Usage, wantUsage) {
+		t.Errorf("Usage = %+v, want %+v", spend.Usage, wantUsage)
+	}
+	sonnet := (1000*3.0 + 200*15.0) / 1e6
+	haiku := (2000*1.0 + 1000*0.1) / 1e6
+	if !approxEqual(spend.CostUSD, sonnet+haiku) {
+		t.Errorf("CostUSD = %v, want %v", spend.CostUSD, sonnet+haiku)
+	}
+	if !approxEqual(spend.ByModel["claude-sonnet-4-5"], sonnet) || !approxEqual(spend.ByModel["claude-haiku-4-5"], haiku) {
+		t.Errorf("ByModel = %v", spend.ByModel)
+	}
+	if !reflect.DeepEqual(spend.Unpriced, []string{"mystery"}) {
+		t.Errorf("Unpriced = %v, want [mystery]", spend.Unpriced)
+	}
+}
+
+func TestSession_SpendPerTurn(t *testing.T) {
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		for cli.read() != nil {
+			cli.send(`{"type":"assistant","message":{"id":"msg","model":"claude-haiku-4-5","usage":{"input_tokens":1000000}},"session_id":"s1"}`)
+			cli.send(testResult)
+		}
+	})
+	client := NewClientWithExecutor(executor)
+
+	session, err := client.StartSession(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	defer session.Close()
+
+	for i := 0; i < 2; i++ {
+		stream, err := session.Send(context.Background(), "hi")
+		if err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		drain(stream)
+		// Each turn reports only its own spend
+		if got := stream.Spend().CostUSD; !approxEqual(got, 1) {
+			t.Errorf("turn %d CostUSD = %v, want 1", i+1, got)
+		}
+	}
+}
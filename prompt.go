@@ -0,0 +1,277 @@
+package claude
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Size limits for prompt attachments, matching the API's limits
+const (
+	// MaxImageSize is the largest image accepted in a prompt
+	MaxImageSize = 5 << 20
+	// MaxDocumentSize is the largest document accepted in a prompt
+	MaxDocumentSize = 32 << 20
+)
+
+// PromptPartType identifies the kind of content in a PromptPart
+type PromptPartType string
+
+// PromptPartType constants
+const (
+	PromptPartText     PromptPartType = "text"
+	PromptPartImage    PromptPartType = "image"
+	PromptPartDocument PromptPartType = "document"
+)
+
+// supportedImageTypes are the image media types the API accepts
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// supportedDocumentTypes are the document media types the API accepts
+var supportedDocumentTypes = map[string]bool{
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// PromptPart is one piece of a Prompt: text, an image or a document
+type PromptPart struct {
+	Type PromptPartType
+	// Text is the content of a text part
+	Text string
+	// Data and MediaType hold an image or document. An empty MediaType is
+	// detected from Data.
+	Data      []byte
+	MediaType string
+	// Title optionally names a document
+	Title string
+}
+
+// Prompt is a user message made of text, images and documents. Prompts with
+// attachments are sent to the CLI as a stream-json user message.
+type Prompt struct {
+	Parts []PromptPart
+}
+
+// NewPrompt creates a prompt from parts
+func NewPrompt(parts ...PromptPart) *Prompt {
+	return &Prompt{Parts: parts}
+}
+
+// TextPart creates a text part
+func TextPart(text string) PromptPart {
+	return PromptPart{Type: PromptPartText, Text: text}
+}
+
+// ImagePart creates an image part; an empty mediaType is detected from data
+func ImagePart(data []byte, mediaType string) PromptPart {
+	return PromptPart{Type: PromptPartImage, Data: data, MediaType: mediaType}
+}
+
+// DocumentPart creates a document part, such as a PDF; an empty mediaType is
+// detected from data
+func DocumentPart(data []byte, mediaType string) PromptPart {
+	return PromptPart{Type: PromptPartDocument, Data: data, MediaType: mediaType}
+}
+
+// ImageFile reads an image part from path
+func ImageFile(path string) (PromptPart, error) {
+	data, err := readAttachment(path, MaxImageSize)
+	if err != nil {
+		return PromptPart{}, err
+	}
+	return ImagePart(data, ""), nil
+}
+
+// DocumentFile reads a document part from path, titled with the file name
+func DocumentFile(path string) (PromptPart, error) {
+	data, err := readAttachment(path, MaxDocumentSize)
+	if err != nil {
+		return PromptPart{}, err
+	}
+	part := DocumentPart(data, "")
+	part.Title = filepath.Base(path)
+	return part, nil
+}
+
+// Add appends parts to the prompt and returns it
+func (p *Prompt) Add(parts ...PromptPart) *Prompt {
+	p.Parts = append(p.Parts, parts...)
+	return p
+}
+
+// HasAttachments reports whether the prompt contains images or documents
+func (p *Prompt) HasAttachments() bool {
+	for _, part := range p.Parts {
+		if part.Type != PromptPartText {
+			return true
+		}
+	}
+	return false
+}
+
+// Text returns the concatenated text parts, separated by blank lines
+func (p *Prompt) Text() string {
+	var texts []string
+	for _, part := range p.Parts {
+		if part.Type == PromptPartText {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// Validate checks that the prompt has content and that every attachment has
+// a supported media type and is within the size limits
+func (p *Prompt) Validate() error {
+	_, err := p.contentBlocks()
+	return err
+}
+
+// promptBlock is an API content block in a stream-json user message
+type promptBlock struct {
+	Type   string             `json:"type"`
+	Text   string             `json:"text,omitempty"`
+	Source *promptBlockSource `json:"source,omitempty"`
+	Title  string             `json:"title,omitempty"`
+}
+
+// promptBlockSource holds the data of an image or document block
+type promptBlockSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// contentBlocks converts the prompt into API content blocks
+func (p *Prompt) contentBlocks() ([]promptBlock, error) {
+	if p == nil || len(p.Parts) == 0 {
+		return nil, &ConfigError{Field: "prompt", Message: "prompt is required"}
+	}
+
+	blocks := make([]promptBlock, 0, len(p.Parts))
+	for i, part := range p.Parts {
+		field := fmt.Sprintf("Prompt.Parts[%d]", i)
+		switch part.Type {
+		case PromptPartText:
+			if part.Text == "" {
+				return nil, &ConfigError{Field: field, Reason: "text part is empty"}
+			}
+			blocks = append(blocks, promptBlock{Type: "text", Text: part.Text})
+
+		case PromptPartImage:
+			mediaType, err := attachmentMediaType(field, part, MaxImageSize, supportedImageTypes)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, promptBlock{
+				Type:   "image",
+				Source: &promptBlockSource{Type: "base64", MediaType: mediaType, Data: base64.StdEncoding.EncodeToString(part.Data)},
+			})
+
+		case PromptPartDocument:
+			mediaType, err := attachmentMediaType(field, part, MaxDocumentSize, supportedDocumentTypes)
+			if err != nil {
+				return nil, err
+			}
+			// Plain text documents are sent as text rather than base64
+			source := &promptBlockSource{Type: "text", MediaType: mediaType, Data: string(part.Data)}
+			if mediaType != "text/plain" {
+				source = &promptBlockSource{Type: "base64", MediaType: mediaType, Data: base64.StdEncoding.EncodeToString(part.Data)}
+			}
+			blocks = append(blocks, promptBlock{Type: "document", Source: source, Title: part.Title})
+
+		default:
+			return nil, &ConfigError{Field: field, Value: string(part.Type), Reason: "type must be 'text', 'image', or 'document'"}
+		}
+	}
+	return blocks, nil
+}
+
+// attachmentMediaType checks an attachment's size and returns its media type,
+// detected from its content when not given
+func attachmentMediaType(field string, part PromptPart, maxSize int, supported map[string]bool) (string, error) {
+	if len(part.Data) == 0 {
+		return "", &ConfigError{Field: field, Reason: fmt.Sprintf("%s has no data", part.Type)}
+	}
+	if len(part.Data) > maxSize {
+		return "", &ConfigError{Field: field, Value: fmt.Sprintf("%d bytes", len(part.Data)), Reason: fmt.Sprintf("%s exceeds the %d byte limit", part.Type, maxSize)}
+	}
+
+	detected := baseMediaType(http.DetectContentType(part.Data))
+	mediaType := baseMediaType(part.MediaType)
+	switch {
+	case mediaType == "":
+		mediaType = detected
+	case supported[detected] && detected != mediaType:
+		return "", &ConfigError{Field: field, Value: part.MediaType, Reason: fmt.Sprintf("content looks like %s", detected)}
+	}
+
+	if !supported[mediaType] {
+		return "", &ConfigError{Field: field, Value: mediaType, Reason: fmt.Sprintf("unsupported %s media type", part.Type)}
+	}
+	return mediaType, nil
+}
+
+// baseMediaType strips parameters such as charset from a media type
+func baseMediaType(mediaType string) string {
+	if mediaType == "" {
+		return ""
+	}
+	parsed, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(mediaType))
+	}
+	return parsed
+}
+
+// readAttachment reads a file, refusing files larger than maxSize
+func readAttachment(path string, maxSize int) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment %s: %w", path, err)
+	}
+	if info.Size() > int64(maxSize) {
+		return nil, &ConfigError{Field: "attachment", Value: path, Reason: fmt.Sprintf("file exceeds the %d byte limit", maxSize)}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// promptInput is a prompt ready to send: plain text, or content blocks when
+// it has attachments
+type promptInput struct {
+	text   string
+	blocks []promptBlock
+}
+
+// newPromptInput validates prompt and converts it for sending
+func newPromptInput(prompt *Prompt) (promptInput, error) {
+	blocks, err := prompt.contentBlocks()
+	if err != nil {
+		return promptInput{}, err
+	}
+	if !prompt.HasAttachments() {
+		return promptInput{text: prompt.Text()}, nil
+	}
+	return promptInput{blocks: blocks}, nil
+}
+
+// content returns the user message content for stream-json input
+func (in promptInput) content() any {
+	if in.blocks != nil {
+		return in.blocks
+	}
+	return in.text
+}
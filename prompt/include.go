@@ -0,0 +1,345 @@
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// languages maps file extensions to code fence info strings
+var languages = map[string]string{
+	".c":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".cs":    "csharp",
+	".css":   "css",
+	".go":    "go",
+	".h":     "c",
+	".html":  "html",
+	".java":  "java",
+	".js":    "javascript",
+	".json":  "json",
+	".jsx":   "jsx",
+	".kt":    "kotlin",
+	".md":    "markdown",
+	".php":   "php",
+	".py":    "python",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".sh":    "bash",
+	".sql":   "sql",
+	".swift": "swift",
+	".toml":  "toml",
+	".ts":    "typescript",
+	".tsx":   "tsx",
+	".xml":   "xml",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+}
+
+// builtinFuncs returns the template functions, running commands under ctx
+func (t *Template) builtinFuncs(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"file":      t.file,
+		"lines":     t.lines,
+		"code":      t.code,
+		"glob":      t.glob,
+		"files":     t.files,
+		"gitDiff":   func(args ...string) (string, error) { return t.gitDiff(ctx, args...) },
+		"escape":    Escape,
+		"untrusted": Untrusted,
+	}
+}
+
+// root returns the absolute working directory
+func (t *Template) root() (string, error) {
+	return rootDir(t.dir)
+}
+
+// read returns the contents of name, failing early for files larger than
+// the maximum rendered size
+func (t *Template) read(name string) (string, error) {
+	return readFile(t.dir, name, t.maxSize)
+}
+
+// rootDir returns dir as an absolute path, defaulting to the current directory
+func rootDir(dir string) (string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve working directory: %w", err)
+	}
+	return abs, nil
+}
+
+// resolvePath returns the absolute path of name, which must be inside dir
+func resolvePath(dir, name string) (string, error) {
+	root, err := rootDir(dir)
+	if err != nil {
+		return "", err
+	}
+	p := name
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	p = filepath.Clean(p)
+	if !within(root, p) {
+		return "", fmt.Errorf("%s is outside the working directory", name)
+	}
+
+	// Compare real paths so that symlinks cannot point outside the root
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve working directory: %w", err)
+	}
+	realPath, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+	if !within(realRoot, realPath) {
+		return "", fmt.Errorf("%s is outside the working directory", name)
+	}
+	return p, nil
+}
+
+// within reports whether p is root or a path beneath it
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readFile returns the contents of name inside dir. A positive maxSize fails
+// with a *SizeError without reading past it.
+func readFile(dir, name string, maxSize int) (string, error) {
+	p, err := resolvePath(dir, name)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if maxSize > 0 {
+		r = io.LimitReader(f, int64(maxSize)+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if maxSize > 0 && len(data) > maxSize {
+		return "", &SizeError{Max: maxSize}
+	}
+	return string(data), nil
+}
+
+func (t *Template) file(name string) (string, error) {
+	return t.read(name)
+}
+
+func (t *Template) lines(name string, from, to int) (string, error) {
+	content, err := t.read(name)
+	if err != nil {
+		return "", err
+	}
+	return lineRange(name, content, from, to)
+}
+
+// lineRange returns lines from to to of content, 1-based and inclusive.
+// A to of 0 selects through the last line.
+func lineRange(name, content string, from, to int) (string, error) {
+	lines := splitLines(content)
+	if to == 0 || to > len(lines) {
+		to = len(lines)
+	}
+	if from < 1 || from > to {
+		return "", fmt.Errorf("invalid line range %d-%d for %s with %d lines", from, to, name, len(lines))
+	}
+	return strings.Join(lines[from-1:to], ""), nil
+}
+
+func (t *Template) code(name string, lineRangeArgs ...int) (string, error) {
+	var content string
+	var err error
+	switch len(lineRangeArgs) {
+	case 0:
+		content, err = t.read(name)
+	case 2:
+		content, err = t.lines(name, lineRangeArgs[0], lineRangeArgs[1])
+	default:
+		return "", fmt.Errorf("code takes a path and an optional start and end line, got %d line arguments", len(lineRangeArgs))
+	}
+	if err != nil {
+		return "", err
+	}
+	return fileBlock(name, content), nil
+}
+
+// fileBlock returns content as a code block labelled with its language and path
+func fileBlock(name, content string) string {
+	return CodeBlock(languages[strings.ToLower(filepath.Ext(name))]+" "+filepath.ToSlash(name), content)
+}
+
+// glob returns the files under the working directory matching pattern,
+// relative to it and sorted. "**" matches any number of directories.
+func (t *Template) glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+	root, err := t.root()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if matchGlob(strings.Split(pattern, "/"), strings.Split(rel, "/")) {
+			matches = append(matches, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob %q: %w", pattern, err)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// matchGlob reports whether the path segments match the pattern segments
+func matchGlob(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchGlob(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchGlob(pattern[1:], segments[1:])
+}
+
+func (t *Template) files(pattern string) (string, error) {
+	names, err := t.glob(pattern)
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no files match %q", pattern)
+	}
+	blocks := make([]string, 0, len(names))
+	for _, name := range names {
+		block, err := t.code(name)
+		if err != nil {
+			return "", err
+		}
+		blocks = append(blocks, block)
+	}
+	return strings.Join(blocks, "\n\n"), nil
+}
+
+// gitDiff runs git diff in the working directory. It returns an empty string
+// when there are no changes so templates can use it with "with".
+func (t *Template) gitDiff(ctx context.Context, args ...string) (string, error) {
+	for _, arg := range args {
+		if arg == "--output" || strings.HasPrefix(arg, "--output=") {
+			return "", fmt.Errorf("gitDiff does not accept %s", arg)
+		}
+	}
+	root, err := t.root()
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"diff", "--no-color", "--no-ext-diff"}, args...)...)
+	cmd.Dir = root
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("failed to run git diff: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("failed to run git diff: %w", err)
+	}
+	if t.maxSize > 0 && stdout.Len() > t.maxSize {
+		return "", &SizeError{Max: t.maxSize}
+	}
+	if stdout.Len() == 0 {
+		return "", nil
+	}
+	return CodeBlock("diff", stdout.String()), nil
+}
+
+// CodeBlock returns content as a fenced code block with the given info
+// string. The fence is longer than any backtick run in content.
+func CodeBlock(info, content string) string {
+	fence := strings.Repeat("`", max(3, longestRun(content, '`')+1))
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return fence + strings.TrimSpace(info) + "\n" + content + fence
+}
+
+// longestRun returns the length of the longest run of c in s
+func longestRun(s string, c byte) int {
+	longest, run := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "```", "``&#96;")
+
+// Escape neutralizes markup in untrusted text: angle brackets and ampersands
+// become entities and runs of backticks can no longer open a code fence
+func Escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// Untrusted escapes s and wraps it in <untrusted_input> tags, which the
+// text cannot close itself
+func Untrusted(s string) string {
+	return "<untrusted_input>\n" + Escape(s) + "\n</untrusted_input>"
+}
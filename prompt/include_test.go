@@ -0,0 +1,158 @@
+package prompt
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, dir, text string) (string, error) {
+	t.Helper()
+	tmpl, err := New("test", text, WithWorkingDir(dir))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return tmpl.Render(context.Background(), nil)
+}
+
+func TestIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.txt", "one\ntwo\nthree\nfour\n")
+	writeTestFile(t, dir, "pkg/b.go", "package pkg\n")
+	writeTestFile(t, dir, "pkg/sub/c.go", "package sub")
+	writeTestFile(t, dir, "README.md", "Use ```go fences```\n")
+
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr string
+	}{
+		{name: "file", text: `{{file "a.txt"}}`, want: "one\ntwo\nthree\nfour\n"},
+		{name: "lines", text: `{{lines "a.txt" 2 3}}`, want: "two\nthree\n"},
+		{name: "lines to end", text: `{{lines "a.txt" 3 0}}`, want: "three\nfour\n"},
+		{name: "lines past end", text: `{{lines "a.txt" 4 10}}`, want: "four\n"},
+		{name: "invalid range", text: `{{lines "a.txt" 3 2}}`, wantErr: "invalid line range"},
+		{name: "start past end", text: `{{lines "a.txt" 5 0}}`, wantErr: "invalid line range"},
+		{name: "code range", text: `{{code "a.txt" 1 2}}`, want: "```a.txt\none\ntwo\n```"},
+		{name: "code without trailing newline", text: `{{code "pkg/sub/c.go"}}`, want: "```go pkg/sub/c.go\npackage sub\n```"},
+		{name: "code lengthens fence", text: `{{code "README.md"}}`, want: "````markdown README.md\nUse ```go fences```\n````"},
+		{name: "code bad arguments", text: `{{code "a.txt" 1}}`, wantErr: "optional start and end line"},
+		{name: "glob", text: `{{range glob "pkg/*.go"}}{{.}};{{end}}`, want: "pkg/b.go;"},
+		{name: "glob recursive", text: `{{range glob "**/*.go"}}{{.}};{{end}}`, want: "pkg/b.go;pkg/sub/c.go;"},
+		{name: "files", text: `{{files "pkg/**/*.go"}}`, want: "```go pkg/b.go\npackage pkg\n```\n\n```go pkg/sub/c.go\npackage sub\n```"},
+		{name: "files without match", text: `{{files "*.rs"}}`, wantErr: "no files match"},
+		{name: "missing file", text: `{{file "missing.txt"}}`, wantErr: "missing.txt"},
+		{name: "parent directory", text: `{{file "../outside.txt"}}`, wantErr: "outside the working directory"},
+		{name: "absolute path outside", text: `{{file "/etc/hostname"}}`, wantErr: "outside the working directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := render(t, dir, tt.text)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Render() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncludes_SymlinkOutside(t *testing.T) {
+	outside := t.TempDir()
+	writeTestFile(t, outside, "secret.txt", "secret")
+	dir := t.TempDir()
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+
+	_, err := render(t, dir, `{{file "link.txt"}}`)
+	if err == nil || !strings.Contains(err.Error(), "outside the working directory") {
+		t.Errorf("Render() error = %v, want outside the working directory", err)
+	}
+}
+
+func TestGitDiff(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	writeTestFile(t, dir, "a.txt", "old\n")
+	git("add", "a.txt")
+	git("commit", "-q", "-m", "initial")
+
+	got, err := render(t, dir, `{{with gitDiff}}{{.}}{{else}}clean{{end}}`)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if got != "clean" {
+		t.Errorf("Render() = %q, want clean", got)
+	}
+
+	writeTestFile(t, dir, "a.txt", "new\n")
+	got, err = render(t, dir, `{{gitDiff "--" "a.txt"}}`)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	for _, want := range []string{"```diff\n", "-old\n", "+new\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Render() = %q, want it to contain %q", got, want)
+		}
+	}
+
+	if _, err := render(t, dir, `{{gitDiff "--output=/tmp/x"}}`); err == nil {
+		t.Error("Render() error = nil, want error for --output")
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "pkg/main.go", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "a/b/main.go", true},
+		{"a/**", "a/b/c", true},
+		{"a/**/c", "a/c", true},
+		{"a/**/c", "a/b/d", false},
+	}
+	for _, tt := range tests {
+		got := matchGlob(strings.Split(tt.pattern, "/"), strings.Split(tt.path, "/"))
+		if got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	got := Escape("a < b && c > d\n```sh\nrm -rf /\n```")
+	want := "a &lt; b &amp;&amp; c &gt; d\n``&#96;sh\nrm -rf /\n``&#96;"
+	if got != want {
+		t.Errorf("Escape() = %q, want %q", got, want)
+	}
+	if got := Untrusted("<x>"); got != "<untrusted_input>\n&lt;x&gt;\n</untrusted_input>" {
+		t.Errorf("Untrusted() = %q", got)
+	}
+}
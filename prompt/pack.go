@@ -0,0 +1,286 @@
+package prompt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	claude "github.com/upamune/claude-code-go"
+)
+
+// DefaultMinTruncatedTokens is the smallest truncated file worth including
+// unless Packer.MinTruncatedTokens is set
+const DefaultMinTruncatedTokens = 64
+
+// File is a candidate for inclusion in a packed prompt
+type File struct {
+	// Path is relative to the packer's working directory
+	Path string
+	// Content is used instead of reading Path when non-empty
+	Content string
+	// Priority ranks files; higher priorities are packed first and files
+	// of equal priority keep their order
+	Priority int
+}
+
+// PackStatus describes what happened to a file when packing
+type PackStatus string
+
+const (
+	// PackIncluded means the whole file was packed
+	PackIncluded PackStatus = "included"
+	// PackTruncated means only the file's leading lines were packed
+	PackTruncated PackStatus = "truncated"
+	// PackDropped means the file did not fit and was left out
+	PackDropped PackStatus = "dropped"
+)
+
+// ManifestEntry records how one file was packed
+type ManifestEntry struct {
+	Path     string     `json:"path"`
+	Priority int        `json:"priority"`
+	Status   PackStatus `json:"status"`
+	// Tokens is the estimate for the whole file as a code block, or zero
+	// when Partial is set
+	Tokens int `json:"tokens"`
+	// Partial reports that the file was too large to fit and only its
+	// leading part was read, so its whole-file estimate is unknown
+	Partial bool `json:"partial,omitempty"`
+	// IncludedTokens is the estimate for the part that was packed
+	IncludedTokens int `json:"included_tokens"`
+	Lines          int `json:"lines"`
+	IncludedLines  int `json:"included_lines"`
+}
+
+// Packed is the result of packing files into a token budget
+type Packed struct {
+	// Prompt is the preamble followed by the packed files as code blocks
+	Prompt string `json:"prompt"`
+	// Tokens is the estimated size of Prompt
+	Tokens int `json:"tokens"`
+	// Manifest lists every candidate in packing order
+	Manifest []ManifestEntry `json:"manifest"`
+}
+
+// Dropped returns the paths of files that did not fit at all
+func (p *Packed) Dropped() []string {
+	var paths []string
+	for _, entry := range p.Manifest {
+		if entry.Status == PackDropped {
+			paths = append(paths, entry.Path)
+		}
+	}
+	return paths
+}
+
+// Packer fits files into a prompt under a token budget
+type Packer struct {
+	// Budget is the maximum estimated tokens of the packed prompt
+	Budget int
+	// WorkingDir is the directory files are read from, usually
+	// Options.WorkingDir. Paths may not leave it.
+	WorkingDir string
+	// Preamble is placed before the files and counts against the budget
+	Preamble string
+	// MinTruncatedTokens is the smallest truncated file worth including;
+	// smaller remainders drop the file instead
+	MinTruncatedTokens int
+	// Estimate estimates tokens; it defaults to claude.EstimateTokens
+	Estimate func(string) int
+}
+
+// blockSeparator separates the preamble and file blocks
+const blockSeparator = "\n\n"
+
+// readBytesPerToken caps how much of a file Pack reads at Budget times this
+// many bytes when Packer.Estimate is nil. That is twice the characters per
+// token of claude.EstimateTokens, so a file cut off there could never fit
+// whole. Custom estimators give no such bound, so files are read in full.
+const readBytesPerToken = 8
+
+// Pack ranks files by priority and includes each one that fits in the
+// remaining budget. A file that does not fit is truncated to its leading
+// lines when at least MinTruncatedTokens remain, and dropped otherwise.
+func (p *Packer) Pack(files []File) (*Packed, error) {
+	if p.Budget <= 0 {
+		return nil, errors.New("token budget must be positive")
+	}
+	estimate := p.Estimate
+	readLimit := 0
+	if estimate == nil {
+		estimate = claude.EstimateTokens
+		readLimit = p.Budget * readBytesPerToken
+	}
+	minTruncated := p.MinTruncatedTokens
+	if minTruncated <= 0 {
+		minTruncated = DefaultMinTruncatedTokens
+	}
+
+	ranked := make([]File, len(files))
+	copy(ranked, files)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Priority > ranked[j].Priority
+	})
+
+	var parts []string
+	remaining := p.Budget
+	separator := estimate(blockSeparator)
+	if p.Preamble != "" {
+		remaining -= estimate(p.Preamble)
+		if remaining < 0 {
+			return nil, fmt.Errorf("preamble exceeds the token budget of %d", p.Budget)
+		}
+		parts = append(parts, p.Preamble)
+	}
+
+	packed := &Packed{Manifest: make([]ManifestEntry, 0, len(ranked))}
+	for _, file := range ranked {
+		content := file.Content
+		total, complete := 0, true
+		if content == "" {
+			var err error
+			content, total, complete, err = readLeading(p.WorkingDir, file.Path, readLimit)
+			if err != nil {
+				return nil, err
+			}
+		}
+		lines := splitLines(content)
+		if complete {
+			total = len(lines)
+		}
+		block := fileBlock(file.Path, content)
+		entry := ManifestEntry{
+			Path:     file.Path,
+			Priority: file.Priority,
+			Status:   PackDropped,
+			Lines:    total,
+			Partial:  !complete,
+		}
+		if complete {
+			entry.Tokens = estimate(block)
+		}
+
+		available := remaining
+		if len(parts) > 0 {
+			available -= separator
+		}
+		switch {
+		case complete && entry.Tokens <= available:
+			entry.Status = PackIncluded
+			entry.IncludedTokens = entry.Tokens
+			entry.IncludedLines = len(lines)
+		case available >= minTruncated:
+			if n, truncated, tokens := truncateToFit(file.Path, lines, total, available, estimate); n > 0 {
+				block = truncated
+				entry.Status = PackTruncated
+				entry.IncludedTokens = tokens
+				entry.IncludedLines = n
+			}
+		}
+
+		if entry.Status != PackDropped {
+			if len(parts) > 0 {
+				remaining -= separator
+			}
+			remaining -= entry.IncludedTokens
+			parts = append(parts, block)
+		}
+		packed.Manifest = append(packed.Manifest, entry)
+	}
+
+	packed.Prompt = strings.Join(parts, blockSeparator)
+	packed.Tokens = p.Budget - remaining
+	return packed, nil
+}
+
+// truncateToFit returns the largest number of leading lines whose block fits
+// in budget, with the block and its estimate. lines may be only the leading
+// part of a file of total lines.
+func truncateToFit(name string, lines []string, total, budget int, estimate func(string) int) (int, string, int) {
+	build := func(n int) string {
+		note := fmt.Sprintf("... (truncated: %d of %d lines)\n", n, total)
+		return fileBlock(name, strings.Join(lines[:n], "")+note)
+	}
+
+	// Find the largest n in [1, limit) that fits, where limit excludes the
+	// whole file
+	limit := len(lines)
+	if total > len(lines) {
+		limit++
+	}
+	n := sort.Search(limit, func(n int) bool {
+		return n > 0 && estimate(build(n)) > budget
+	}) - 1
+	if n < 1 {
+		return 0, "", 0
+	}
+	block := build(n)
+	return n, block, estimate(block)
+}
+
+// splitLines splits content after each newline
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// readLeading reads up to limit bytes of name inside dir, or all of it if
+// limit is not positive. A longer file is
+// cut back to its last complete line within the limit, and the rest is only
+// scanned to count its lines. It returns the content, the file's total line
+// count and whether the whole file was read.
+func readLeading(dir, name string, limit int) (string, int, bool, error) {
+	p, err := resolvePath(dir, name)
+	if err != nil {
+		return "", 0, false, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if limit > 0 {
+		r = io.LimitReader(f, int64(limit)+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if limit <= 0 || len(data) <= limit {
+		content := string(data)
+		return content, len(splitLines(content)), true, nil
+	}
+
+	lines := bytes.Count(data, []byte("\n"))
+	last := data[len(data)-1]
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			lines += bytes.Count(buf[:n], []byte("\n"))
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", 0, false, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+	}
+	if last != '\n' {
+		lines++
+	}
+
+	data = data[:limit]
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	return string(data), lines, false, nil
+}
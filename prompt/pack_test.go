@@ -0,0 +1,168 @@
+package prompt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// wordCount is a predictable estimator for tests
+func wordCount(s string) int {
+	return len(strings.Fields(s))
+}
+
+func TestPacker_Pack(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "main.go", "package main\n")
+
+	var long strings.Builder
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&long, "line %d\n", i)
+	}
+
+	packer := &Packer{
+		Budget:             40,
+		WorkingDir:         dir,
+		Preamble:           "Review these files.",
+		MinTruncatedTokens: 5,
+		Estimate:           wordCount,
+	}
+	packed, err := packer.Pack([]File{
+		{Path: "low.txt", Content: "low priority\n", Priority: 0},
+		{Path: "main.go", Priority: 10},
+		{Path: "long.txt", Content: long.String(), Priority: 5},
+		{Path: "last.txt", Content: "dropped\n", Priority: 0},
+	})
+	if err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+
+	gotStatus := map[string]PackStatus{}
+	var order []string
+	for _, entry := range packed.Manifest {
+		gotStatus[entry.Path] = entry.Status
+		order = append(order, entry.Path)
+	}
+	if want := []string{"main.go", "long.txt", "low.txt", "last.txt"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("manifest order = %v, want %v", order, want)
+	}
+	wantStatus := map[string]PackStatus{
+		"main.go":  PackIncluded,
+		"long.txt": PackTruncated,
+		"low.txt":  PackDropped,
+		"last.txt": PackDropped,
+	}
+	if !reflect.DeepEqual(gotStatus, wantStatus) {
+		t.Errorf("statuses = %v, want %v", gotStatus, wantStatus)
+	}
+	if got := packed.Dropped(); !reflect.DeepEqual(got, []string{"low.txt", "last.txt"}) {
+		t.Errorf("Dropped() = %v", got)
+	}
+
+	if packed.Tokens > packer.Budget {
+		t.Errorf("Tokens = %d, exceeds budget %d", packed.Tokens, packer.Budget)
+	}
+	if got := wordCount(packed.Prompt); got != packed.Tokens {
+		t.Errorf("Tokens = %d, want estimate of prompt %d", packed.Tokens, got)
+	}
+	if !strings.HasPrefix(packed.Prompt, "Review these files.\n\n```go main.go\npackage main\n```") {
+		t.Errorf("Prompt = %q", packed.Prompt)
+	}
+	longEntry := packed.Manifest[1]
+	if longEntry.IncludedLines == 0 || longEntry.IncludedLines >= longEntry.Lines {
+		t.Errorf("long.txt included %d of %d lines", longEntry.IncludedLines, longEntry.Lines)
+	}
+	if want := fmt.Sprintf("... (truncated: %d of 20 lines)", longEntry.IncludedLines); !strings.Contains(packed.Prompt, want) {
+		t.Errorf("Prompt = %q, want truncation note %q", packed.Prompt, want)
+	}
+}
+
+func TestPacker_PackEverythingFits(t *testing.T) {
+	packer := &Packer{Budget: 1000}
+	packed, err := packer.Pack([]File{
+		{Path: "a.go", Content: "package a\n"},
+		{Path: "b.go", Content: "package b\n"},
+	})
+	if err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+	want := "```go a.go\npackage a\n```\n\n```go b.go\npackage b\n```"
+	if packed.Prompt != want {
+		t.Errorf("Prompt = %q, want %q", packed.Prompt, want)
+	}
+	for _, entry := range packed.Manifest {
+		if entry.Status != PackIncluded || entry.IncludedTokens != entry.Tokens {
+			t.Errorf("entry %+v, want fully included", entry)
+		}
+	}
+}
+
+func TestPacker_PackErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		packer  Packer
+		files   []File
+		wantErr string
+	}{
+		{name: "no budget", packer: Packer{}, wantErr: "budget must be positive"},
+		{name: "preamble too large", packer: Packer{Budget: 1, Preamble: "far too many words", Estimate: wordCount}, wantErr: "preamble exceeds"},
+		{name: "outside working directory", packer: Packer{Budget: 10, WorkingDir: t.TempDir()}, files: []File{{Path: "../x"}}, wantErr: "outside the working directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.packer.Pack(tt.files)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Pack() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPacker_PackLargeFile(t *testing.T) {
+	dir := t.TempDir()
+	var large strings.Builder
+	for i := 1; i <= 10000; i++ {
+		fmt.Fprintf(&large, "line %d\n", i)
+	}
+	writeTestFile(t, dir, "large.txt", large.String())
+
+	// The default estimator lets Pack stop reading early
+	packer := &Packer{Budget: 50, WorkingDir: dir, MinTruncatedTokens: 5}
+	packed, err := packer.Pack([]File{{Path: "large.txt"}})
+	if err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+
+	entry := packed.Manifest[0]
+	if entry.Status != PackTruncated || entry.Lines != 10000 || entry.IncludedLines == 0 {
+		t.Errorf("entry = %+v, want truncated with all 10000 lines counted", entry)
+	}
+	if !entry.Partial || entry.Tokens != 0 {
+		t.Errorf("entry = %+v, want a partial read without a whole-file estimate", entry)
+	}
+	if packed.Tokens > packer.Budget {
+		t.Errorf("Tokens = %d, want at most %d", packed.Tokens, packer.Budget)
+	}
+	if !strings.Contains(packed.Prompt, fmt.Sprintf("(truncated: %d of 10000 lines)", entry.IncludedLines)) {
+		t.Errorf("Prompt = %q, want a truncation note", packed.Prompt)
+	}
+}
+
+func TestPacker_PackCustomEstimatorReadsWholeFile(t *testing.T) {
+	dir := t.TempDir()
+	// Long words make the file large in bytes but small in words
+	line := strings.Repeat("x", 30) + " " + strings.Repeat("y", 30) + " z\n"
+	writeTestFile(t, dir, "words.txt", strings.Repeat(line, 20))
+
+	packer := &Packer{Budget: 100, WorkingDir: dir, Estimate: wordCount}
+	packed, err := packer.Pack([]File{{Path: "words.txt"}})
+	if err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+
+	entry := packed.Manifest[0]
+	if entry.Status != PackIncluded || entry.Partial || entry.IncludedLines != 20 || entry.Tokens != entry.IncludedTokens {
+		t.Errorf("entry = %+v, want the whole file included", entry)
+	}
+}
@@ -0,0 +1,144 @@
+// Package prompt renders Claude prompts from text/template templates with
+// helpers for including files, git diffs and untrusted input.
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"text/template"
+
+	claude "github.com/upamune/claude-code-go"
+)
+
+// DefaultMaxSize is the largest rendered prompt allowed unless WithMaxSize is used
+const DefaultMaxSize = 512 << 10
+
+// SizeError is returned when a rendered prompt exceeds the maximum size
+type SizeError struct {
+	Max int
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("rendered prompt exceeds %d bytes", e.Max)
+}
+
+// Template is a prompt template. Templates are safe for concurrent rendering.
+type Template struct {
+	tmpl    *template.Template
+	dir     string
+	maxSize int
+	funcs   template.FuncMap // added with WithFuncs
+}
+
+// Option configures a Template
+type Option func(*Template)
+
+// WithWorkingDir sets the directory that file includes and git diffs are
+// resolved against. It defaults to the current directory.
+func WithWorkingDir(dir string) Option {
+	return func(t *Template) {
+		t.dir = dir
+	}
+}
+
+// WithOptions resolves file includes and git diffs against opts.WorkingDir,
+// the directory the CLI will run in
+func WithOptions(opts *claude.Options) Option {
+	return func(t *Template) {
+		if opts != nil {
+			t.dir = opts.WorkingDir
+		}
+	}
+}
+
+// WithMaxSize sets the largest rendered prompt in bytes
+func WithMaxSize(n int) Option {
+	return func(t *Template) {
+		t.maxSize = n
+	}
+}
+
+// WithFuncs adds template functions, overriding built-in ones of the same name
+func WithFuncs(funcs template.FuncMap) Option {
+	return func(t *Template) {
+		for name, fn := range funcs {
+			t.funcs[name] = fn
+		}
+	}
+}
+
+// New parses text as a prompt template. Besides the standard template
+// functions it provides:
+//
+//	file PATH              contents of a file
+//	lines PATH FROM TO     lines FROM to TO of a file (1-based, TO 0 means the end)
+//	code PATH [FROM TO]    a file, or a line range, as a fenced code block
+//	glob PATTERN           sorted file paths matching PATTERN, which may use **
+//	files PATTERN          every file matching PATTERN as fenced code blocks
+//	gitDiff [ARGS...]      output of git diff ARGS as a fenced diff block
+//	escape TEXT            TEXT with markup and code fences neutralized
+//	untrusted TEXT         escaped TEXT wrapped in <untrusted_input> tags
+//
+// Paths are relative to the working directory and may not leave it.
+func New(name, text string, opts ...Option) (*Template, error) {
+	t := &Template{maxSize: DefaultMaxSize, funcs: template.FuncMap{}}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	tmpl, err := template.New(name).Funcs(t.builtinFuncs(context.Background())).Funcs(t.funcs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prompt template: %w", err)
+	}
+	t.tmpl = tmpl
+	return t, nil
+}
+
+// Must panics if err is non-nil, for templates defined at package level
+func Must(t *Template, err error) *Template {
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Render executes the template with data. It fails with a *SizeError if the
+// result would exceed the maximum size. ctx bounds commands such as git diff.
+func (t *Template) Render(ctx context.Context, data any) (string, error) {
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare prompt template: %w", err)
+	}
+	// Rebind the built-in functions so commands run under ctx; functions
+	// added with WithFuncs keep priority
+	funcs := t.builtinFuncs(ctx)
+	for name := range t.funcs {
+		delete(funcs, name)
+	}
+	tmpl.Funcs(funcs)
+
+	out := &limitedBuffer{max: t.maxSize}
+	if err := tmpl.Execute(out, data); err != nil {
+		var sizeErr *SizeError
+		if errors.As(err, &sizeErr) {
+			return "", sizeErr
+		}
+		return "", fmt.Errorf("failed to render prompt: %w", err)
+	}
+	return out.String(), nil
+}
+
+// limitedBuffer is a buffer that refuses to grow beyond max bytes
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && b.Len()+len(p) > b.max {
+		return 0, &SizeError{Max: b.max}
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,192 @@
+package claude
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	testPDF = []byte("%PDF-1.4\n%test document\n")
+)
+
+func TestPrompt_ContentBlocks(t *testing.T) {
+	prompt := NewPrompt(TextPart("What is in these?")).Add(
+		ImagePart(testPNG, ""),
+		DocumentPart(testPDF, "application/pdf"),
+		PromptPart{Type: PromptPartDocument, Data: []byte("plain notes"), Title: "notes.txt"},
+	)
+
+	blocks, err := prompt.contentBlocks()
+	if err != nil {
+		t.Fatalf("contentBlocks() error = %v", err)
+	}
+	if len(blocks) != 4 {
+		t.Fatalf("got %d blocks, want 4", len(blocks))
+	}
+	if blocks[0].Type != "text" || blocks[0].Text != "What is in these?" {
+		t.Errorf("text block = %+v", blocks[0])
+	}
+	if src := blocks[1].Source; blocks[1].Type != "image" || src.Type != "base64" || src.MediaType != "image/png" || src.Data != base64.StdEncoding.EncodeToString(testPNG) {
+		t.Errorf("image block = %+v %+v", blocks[1], src)
+	}
+	if src := blocks[2].Source; blocks[2].Type != "document" || src.MediaType != "application/pdf" || src.Type != "base64" {
+		t.Errorf("pdf block = %+v %+v", blocks[2], src)
+	}
+	if src := blocks[3].Source; src.Type != "text" || src.MediaType != "text/plain" || src.Data != "plain notes" || blocks[3].Title != "notes.txt" {
+		t.Errorf("text document block = %+v %+v", blocks[3], src)
+	}
+
+	if !prompt.HasAttachments() {
+		t.Error("HasAttachments() = false, want true")
+	}
+	if got := NewPrompt(TextPart("a"), TextPart("b")).Text(); got != "a\n\nb" {
+		t.Errorf("Text() = %q", got)
+	}
+}
+
+func TestPrompt_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		prompt *Prompt
+		field  string
+	}{
+		{"empty prompt", NewPrompt(), "prompt"},
+		{"nil prompt", nil, "prompt"},
+		{"empty text", NewPrompt(TextPart("")), "Prompt.Parts[0]"},
+		{"empty image", NewPrompt(ImagePart(nil, "image/png")), "Prompt.Parts[0]"},
+		{"mismatched media type", NewPrompt(TextPart("x"), ImagePart(testPNG, "image/jpeg")), "Prompt.Parts[1]"},
+		{"unsupported image", NewPrompt(ImagePart(testPDF, "")), "Prompt.Parts[0]"},
+		{"unsupported document", NewPrompt(DocumentPart([]byte("PK\x03\x04zip"), "")), "Prompt.Parts[0]"},
+		{"oversized image", NewPrompt(ImagePart(append(append([]byte{}, testPNG...), make([]byte, MaxImageSize)...), "")), "Prompt.Parts[0]"},
+		{"unknown part type", NewPrompt(PromptPart{Type: "audio"}), "Prompt.Parts[0]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.prompt.Validate()
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				t.Fatalf("Validate() error = %v, want *ConfigError", err)
+			}
+			if configErr.Field != tt.field {
+				t.Errorf("ConfigError.Field = %q, want %q", configErr.Field, tt.field)
+			}
+		})
+	}
+
+	if err := NewPrompt(ImagePart(testPNG, "image/png; charset=binary")).Validate(); err != nil {
+		t.Errorf("Validate() with media type parameters error = %v", err)
+	}
+}
+
+func TestPrompt_Files(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "shot.png", string(testPNG))
+	writeTestFile(t, dir, "spec.pdf", string(testPDF))
+
+	image, err := ImageFile(filepath.Join(dir, "shot.png"))
+	if err != nil {
+		t.Fatalf("ImageFile() error = %v", err)
+	}
+	doc, err := DocumentFile(filepath.Join(dir, "spec.pdf"))
+	if err != nil {
+		t.Fatalf("DocumentFile() error = %v", err)
+	}
+	if doc.Title != "spec.pdf" {
+		t.Errorf("DocumentFile() title = %q, want spec.pdf", doc.Title)
+	}
+	if err := NewPrompt(image, doc).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	if _, err := ImageFile(filepath.Join(dir, "missing.png")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ImageFile() for missing file error = %v, want os.ErrNotExist", err)
+	}
+
+	big := filepath.Join(dir, "big.png")
+	if err := os.WriteFile(big, make([]byte, MaxImageSize+1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var configErr *ConfigError
+	if _, err := ImageFile(big); !errors.As(err, &configErr) {
+		t.Errorf("ImageFile() for oversized file error = %v, want *ConfigError", err)
+	}
+}
+
+func TestClient_QueryPrompt(t *testing.T) {
+	inputs := make(chan map[string]any, 1)
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		inputs <- cli.read()
+		cli.send(testResult)
+	})
+	executor.ExecuteFunc = func(_ context.Context, _ string, _ []string, stdin string, _ string, _ map[string]string) ([]byte, error) {
+		if stdin != "just text" {
+			t.Errorf("stdin = %q, want the text prompt", stdin)
+		}
+		return []byte(testResult), nil
+	}
+	client := NewClientWithExecutor(executor)
+
+	// Text-only prompts use the plain query path
+	if _, err := client.QueryPrompt(context.Background(), NewPrompt(TextPart("just text")), nil); err != nil {
+		t.Fatalf("QueryPrompt() text error = %v", err)
+	}
+
+	result, err := client.QueryPrompt(context.Background(), NewPrompt(TextPart("describe"), ImagePart(testPNG, "")), nil)
+	if err != nil {
+		t.Fatalf("QueryPrompt() error = %v", err)
+	}
+	if result.Result != "done" {
+		t.Errorf("result = %+v, want done", result)
+	}
+
+	input := <-inputs
+	content, _ := jsonPath(input, "message.content").([]any)
+	if len(content) != 2 {
+		t.Fatalf("message content = %v, want two blocks", jsonPath(input, "message.content"))
+	}
+	image, _ := content[1].(map[string]any)
+	if jsonPath(image, "type") != "image" || jsonPath(image, "source.media_type") != "image/png" {
+		t.Errorf("image block = %v", image)
+	}
+}
+
+func TestSession_SendPrompt(t *testing.T) {
+	inputs := make(chan map[string]any, 1)
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		inputs <- cli.read()
+		cli.send(testResult)
+		cli.read()
+	})
+	client := NewClientWithExecutor(executor)
+
+	session, err := client.StartSession(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	defer session.Close()
+
+	if _, err := session.SendPrompt(context.Background(), NewPrompt()); err == nil {
+		t.Error("SendPrompt() with empty prompt error = nil, want error")
+	}
+
+	stream, err := session.SendPrompt(context.Background(), NewPrompt(TextPart("read this"), DocumentPart(testPDF, "")))
+	if err != nil {
+		t.Fatalf("SendPrompt() error = %v", err)
+	}
+	if _, errs := drain(stream); len(errs) != 0 {
+		t.Fatalf("turn errors = %v", errs)
+	}
+
+	input := <-inputs
+	content, _ := jsonPath(input, "message.content").([]any)
+	if len(content) != 2 || !strings.Contains(jsonPath(content[1].(map[string]any), "source.media_type").(string), "pdf") {
+		t.Errorf("message content = %v", jsonPath(input, "message.content"))
+	}
+}
@@ -0,0 +1,511 @@
+package claude
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSessionClosed is returned by Session methods once the CLI process has exited
+var ErrSessionClosed = errors.New("session closed")
+
+// sessionCloseGrace is how long Close waits for the CLI to exit before killing it
+const sessionCloseGrace = 5 * time.Second
+
+// Session is a long-lived Claude Code CLI process in stream-json input mode.
+// Prompts are sent one turn at a time with Send, and a running turn can be
+// stopped with Interrupt without ending the process. Close ends the session.
+type Session struct {
+	control *controlChannel
+	parser  MessageParser
+	env     map[string]string
+	pricing *PricingRegistry
+	spend   *spendGuard
+	labels  map[string]string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// turnSem is held while a turn is running
+	turnSem chan struct{}
+
+	mu             sync.Mutex
+	turn           *sessionTurn
+	sessionID      string
+	model          string
+	permissionMode PermissionMode
+	closing        bool
+	exited         bool
+	err            error
+}
+
+// PermissionModeAck acknowledges Session.SetPermissionMode
+type PermissionModeAck struct {
+	// Mode is the permission mode now in effect
+	Mode PermissionMode `json:"mode"`
+	// Previous is the mode that was in effect before the change
+	Previous PermissionMode `json:"-"`
+}
+
+// ModelAck acknowledges Session.SetModel
+type ModelAck struct {
+	// Model is the model now in use; empty means the CLI default
+	Model string `json:"model"`
+	// Previous is the model that was in use before the change
+	Previous string `json:"-"`
+}
+
+// sessionTurn delivers the messages of one prompt
+type sessionTurn struct {
+	messages   chan MessageOrError
+	closed     chan struct{}
+	markClosed func()
+	finished   chan struct{}
+	spend      *spendTracker
+	// reservation holds the turn's estimated cost against quotas
+	reservation *spendReservation
+}
+
+// StartSession starts a Claude Code CLI process that accepts prompts over
+// stdin. The process runs until Close is called or ctx is done. opts.Resume
+// resumes an existing session, which stays locked until the session ends.
+func (c *clientImpl) StartSession(ctx context.Context, opts *Options) (*Session, error) {
+	opts, err := c.resolveOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	executable := c.executableFor(opts)
+
+	if opts.IdleTimeout > 0 || opts.TurnTimeout > 0 {
+		field := "IdleTimeout"
+		if opts.IdleTimeout == 0 {
+			field = "TurnTimeout"
+		}
+		return nil, &ConfigError{Field: field, Message: "IdleTimeout and TurnTimeout are not supported by sessions"}
+	}
+
+	interactive, ok := c.executor.(InteractiveCommandExecutor)
+	if !ok {
+		return nil, &ConfigError{
+			Field:  "executor",
+			Value:  fmt.Sprintf("%T", c.executor),
+			Reason: "sessions require an executor implementing InteractiveCommandExecutor",
+		}
+	}
+
+	reservation, err := c.spend.check(ctx, opts.Labels, opts.Model, "")
+	if err != nil {
+		return nil, err
+	}
+	// Starting the process costs nothing; each turn reserves its own cost
+	reservation.release()
+
+	// Keep the resumed session locked for the life of the process
+	release, err := c.locks.lockFor(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	stdin, stdout, err := interactive.ExecuteInteractive(sessionCtx, executable, c.interactiveArgs(opts), opts.WorkingDir, opts.Env)
+	if err != nil {
+		cancel()
+		release()
+		return nil, wrapExecError(err, opts.Env)
+	}
+
+	s := &Session{
+		control:        newControlChannel(stdin, opts.PermissionTimeout),
+		parser:         c.parser,
+		env:            opts.Env,
+		pricing:        c.pricing,
+		spend:          c.spend,
+		labels:         opts.Labels,
+		ctx:            sessionCtx,
+		cancel:         cancel,
+		done:           make(chan struct{}),
+		turnSem:        make(chan struct{}, 1),
+		sessionID:      opts.Resume,
+		model:          opts.Model,
+		permissionMode: opts.PermissionMode,
+	}
+	if s.permissionMode == "" {
+		s.permissionMode = PermissionDefault
+	}
+	if s.model == "" && len(opts.ModelChain) > 0 {
+		s.model = opts.ModelChain[0]
+	}
+	go s.read(stdout, release, resolveAdditionalDirectories(opts.WorkingDir, opts.AdditionalDirectories))
+	return s, nil
+}
+
+// Send starts a turn with prompt and returns a stream of its messages, ending
+// with the ResultMessage. If a turn is still running, Send waits for it to
+// finish. Closing the returned stream stops delivery but not the turn; use
+// Interrupt to stop Claude.
+func (s *Session) Send(ctx context.Context, prompt string) (*MessageStream, error) {
+	if prompt == "" {
+		return nil, &ConfigError{
+			Field:   "prompt",
+			Message: "prompt is required",
+		}
+	}
+	return s.send(ctx, prompt, prompt)
+}
+
+// SendPrompt is Send for a prompt with image or document attachments
+func (s *Session) SendPrompt(ctx context.Context, prompt *Prompt) (*MessageStream, error) {
+	input, err := newPromptInput(prompt)
+	if err != nil {
+		return nil, err
+	}
+	return s.send(ctx, input.content(), input.text)
+}
+
+// send starts a turn with the given user message content. text is the
+// prompt's text, used to estimate its cost against quotas.
+func (s *Session) send(ctx context.Context, content any, text string) (*MessageStream, error) {
+	select {
+	case s.turnSem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.done:
+		return nil, s.exitErr()
+	}
+
+	// Check quotas once the previous turn's cost has been recorded
+	reservation, err := s.spend.check(ctx, s.labels, s.Model(), text)
+	if err != nil {
+		<-s.turnSem
+		return nil, err
+	}
+
+	closed := make(chan struct{})
+	turn := &sessionTurn{
+		messages:    make(chan MessageOrError),
+		closed:      closed,
+		markClosed:  sync.OnceFunc(func() { close(closed) }),
+		finished:    make(chan struct{}),
+		spend:       newSpendTracker(s.pricing),
+		reservation: reservation,
+	}
+
+	s.mu.Lock()
+	if s.exited || s.closing {
+		s.mu.Unlock()
+		reservation.release()
+		<-s.turnSem
+		return nil, s.exitErr()
+	}
+	s.turn = turn
+	sessionID := s.sessionID
+	s.mu.Unlock()
+
+	if err := s.control.sendUserMessage(content, sessionID); err != nil {
+		s.mu.Lock()
+		s.turn = nil
+		s.mu.Unlock()
+		reservation.release()
+		<-s.turnSem
+		return nil, err
+	}
+
+	return &MessageStream{
+		Messages:   turn.messages,
+		ctx:        s.ctx,
+		closed:     turn.closed,
+		markClosed: turn.markClosed,
+		control:    s.control,
+		spend:      turn.spend,
+	}, nil
+}
+
+// Interrupt stops the running turn. It waits for the CLI to acknowledge the
+// interrupt and to finish the turn with its ResultMessage, after which the
+// session is ready for the next Send. The turn's stream must be drained or
+// closed for Interrupt to return.
+func (s *Session) Interrupt(ctx context.Context) error {
+	s.mu.Lock()
+	turn := s.turn
+	s.mu.Unlock()
+
+	if _, err := s.control.request(ctx, controlRequestBody{Subtype: controlSubtypeInterrupt}); err != nil {
+		return err
+	}
+	if turn == nil {
+		return nil
+	}
+
+	select {
+	case <-turn.finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetPermissionMode switches the permission mode for the following tool
+// uses, for example from PermissionPlan to PermissionAcceptEdits once a plan
+// has been approved. The mode is validated like Options.PermissionMode.
+func (s *Session) SetPermissionMode(ctx context.Context, mode PermissionMode) (*PermissionModeAck, error) {
+	if err := validatePermissionMode(mode); err != nil {
+		return nil, err
+	}
+
+	response, err := s.control.request(ctx, controlRequestBody{Subtype: controlSubtypeSetMode, Mode: mode})
+	if err != nil {
+		return nil, err
+	}
+	ack := &PermissionModeAck{}
+	if err := decodeControlAck(response, ack); err != nil {
+		return nil, err
+	}
+	if ack.Mode == "" {
+		ack.Mode = mode
+	}
+
+	s.mu.Lock()
+	ack.Previous = s.permissionMode
+	s.permissionMode = ack.Mode
+	s.mu.Unlock()
+	return ack, nil
+}
+
+// SetModel switches the model used for the following turns. An empty model
+// returns to the CLI's default model.
+func (s *Session) SetModel(ctx context.Context, model string) (*ModelAck, error) {
+	model = strings.TrimSpace(model)
+	body := controlRequestBody{Subtype: controlSubtypeSetModel}
+	if model != "" {
+		body.Model = &model
+	}
+
+	response, err := s.control.request(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	ack := &ModelAck{}
+	if err := decodeControlAck(response, ack); err != nil {
+		return nil, err
+	}
+	if ack.Model == "" {
+		ack.Model = model
+	}
+
+	s.mu.Lock()
+	ack.Previous = s.model
+	s.model = ack.Model
+	s.mu.Unlock()
+	return ack, nil
+}
+
+// PermissionMode returns the permission mode currently in effect
+func (s *Session) PermissionMode() PermissionMode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.permissionMode
+}
+
+// Model returns the model currently in use, as last set or reported by the CLI
+func (s *Session) Model() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.model
+}
+
+// SessionID returns the Claude session ID, once the CLI has reported it
+func (s *Session) SessionID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessionID
+}
+
+// Close ends the session. It closes the CLI's stdin, stops delivery to any
+// open turn stream and waits for the process to exit, killing it if it does
+// not exit promptly.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	if s.turn != nil {
+		s.turn.markClosed()
+	}
+	s.mu.Unlock()
+
+	_ = s.control.closeInput()
+
+	select {
+	case <-s.done:
+	case <-time.After(sessionCloseGrace):
+		s.cancel()
+		<-s.done
+	}
+	s.cancel()
+
+	if err := s.exitErr(); !errors.Is(err, ErrSessionClosed) && !errors.Is(err, ErrAborted) {
+		return err
+	}
+	return nil
+}
+
+// read consumes the CLI's output for the life of the process
+func (s *Session) read(stdout io.ReadCloser, release func(), additionalDirs []string) {
+	defer close(s.done)
+	defer release()
+
+	subagents := newSubagentTracker()
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		msg, consumed, err := s.control.handleControlLine(line)
+		if !consumed {
+			msg, err = s.parser.ParseMessage(line)
+		}
+		if err != nil {
+			// The process is still usable, so report the line and carry on
+			s.deliver(MessageOrError{Err: maskParseError(err, s.env)})
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+
+		item := MessageOrError{Message: msg}
+		switch m := msg.(type) {
+		case *InitMessage:
+			if m.AdditionalDirectories == nil {
+				m.AdditionalDirectories = additionalDirs
+			}
+			s.setSessionID(m.SessionID)
+			s.mu.Lock()
+			if m.Model != "" {
+				s.model = m.Model
+			}
+			if m.PermissionMode != "" {
+				s.permissionMode = PermissionMode(m.PermissionMode)
+			}
+			s.mu.Unlock()
+		case *PermissionRequestMessage:
+			if m.RequestID != "" {
+				s.control.track(m)
+			}
+		case *ResultMessage:
+			item.Err = resultError(m)
+			m.Model = s.Model()
+			if err := s.spend.record(s.ctx, s.labels, m.Model, m); err != nil {
+				item.Err = errors.Join(item.Err, err)
+			}
+			s.setSessionID(m.SessionID)
+		}
+		subagents.observe(msg)
+
+		s.deliver(item)
+		if _, ok := msg.(*ResultMessage); ok {
+			s.finishTurn(nil, false)
+		}
+	}
+
+	_ = s.control.closeInput()
+	closeErr := stdout.Close()
+
+	var err error
+	switch {
+	case s.ctx.Err() != nil:
+		err = &AbortError{Message: "session aborted", Err: s.ctx.Err()}
+	case scanner.Err() != nil:
+		err = fmt.Errorf("error reading stream: %w", scanner.Err())
+	case closeErr != nil:
+		err = wrapExecError(closeErr, s.env)
+	default:
+		err = ErrSessionClosed
+	}
+	s.control.fail(err)
+	s.finishTurn(err, true)
+}
+
+// deliver sends item to the running turn, if any and if it is still read
+func (s *Session) deliver(item MessageOrError) {
+	s.mu.Lock()
+	turn := s.turn
+	s.mu.Unlock()
+	if turn == nil {
+		return
+	}
+	if item.Message != nil {
+		turn.spend.observe(item.Message)
+	}
+
+	select {
+	case turn.messages <- item:
+	case <-turn.closed:
+	case <-s.ctx.Done():
+	}
+}
+
+// finishTurn ends the running turn. If the process has exited, err is
+// reported to the turn first and no further turns can start.
+func (s *Session) finishTurn(err error, exited bool) {
+	s.mu.Lock()
+	turn := s.turn
+	s.turn = nil
+	if exited {
+		s.exited = true
+		s.err = err
+	}
+	s.mu.Unlock()
+	if turn == nil {
+		return
+	}
+
+	if err != nil {
+		// The process exited before the turn produced a result
+		select {
+		case turn.messages <- MessageOrError{Err: err}:
+		case <-turn.closed:
+		}
+	}
+	close(turn.messages)
+	close(turn.finished)
+	turn.reservation.release()
+	<-s.turnSem
+}
+
+func (s *Session) setSessionID(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	s.mu.Lock()
+	s.sessionID = sessionID
+	s.mu.Unlock()
+}
+
+// decodeControlAck decodes a control response body into ack; an empty body
+// leaves ack unchanged
+func decodeControlAck(response json.RawMessage, ack any) error {
+	if len(response) == 0 || string(response) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(response, ack); err != nil {
+		return fmt.Errorf("failed to parse control response: %w", err)
+	}
+	return nil
+}
+
+// exitErr returns why the session can no longer be used
+func (s *Session) exitErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return ErrSessionClosed
+}
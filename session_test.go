@@ -0,0 +1,329 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drain collects a turn's messages and errors
+func drain(stream *MessageStream) ([]Message, []error) {
+	var messages []Message
+	var errs []error
+	for msgOrErr := range stream.Messages {
+		if msgOrErr.Message != nil {
+			messages = append(messages, msgOrErr.Message)
+		}
+		if msgOrErr.Err != nil {
+			errs = append(errs, msgOrErr.Err)
+		}
+	}
+	return messages, errs
+}
+
+func TestSession_Turns(t *testing.T) {
+	var args []string
+	prompts := make(chan any, 2)
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		args = cli.args
+		for turn := 1; ; turn++ {
+			input := cli.read()
+			if input == nil {
+				return
+			}
+			prompts <- jsonPath(input, "message.content")
+			if turn == 1 {
+				cli.send(`{"type":"system","subtype":"init","session_id":"s1","cwd":"/repo","tools":[],"mcp_servers":[],"model":"m","permissionMode":"default","apiKeySource":"none"}`)
+			}
+			cli.send(`{"type":"assistant","message":{"content":[]},"session_id":"s1"}`)
+			cli.send(testResult)
+		}
+	})
+	client := NewClientWithExecutor(executor)
+
+	session, err := client.StartSession(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+
+	for i, prompt := range []string{"one", "two"} {
+		stream, err := session.Send(context.Background(), prompt)
+		if err != nil {
+			t.Fatalf("Send(%q) error = %v", prompt, err)
+		}
+		messages, errs := drain(stream)
+		if len(errs) != 0 {
+			t.Fatalf("turn %d errors = %v", i+1, errs)
+		}
+		if _, ok := messages[len(messages)-1].(*ResultMessage); !ok {
+			t.Errorf("turn %d ended with %T, want *ResultMessage", i+1, messages[len(messages)-1])
+		}
+		if got := <-prompts; got != prompt {
+			t.Errorf("CLI received %v, want %q", got, prompt)
+		}
+	}
+
+	if got := session.SessionID(); got != "s1" {
+		t.Errorf("SessionID() = %q, want s1", got)
+	}
+	if err := session.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := session.Send(context.Background(), "three"); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Send() after Close error = %v, want ErrSessionClosed", err)
+	}
+
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "--input-format stream-json") || strings.Contains(joined, "--print") {
+		t.Errorf("args = %v, want stream-json input without --print", args)
+	}
+}
+
+func TestSession_Interrupt(t *testing.T) {
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		for {
+			input := cli.read()
+			if input == nil {
+				return
+			}
+			switch jsonPath(input, "type") {
+			case "user":
+				cli.send(`{"type":"assistant","message":{"content":[]},"session_id":"s1"}`)
+			case "control_request":
+				if jsonPath(input, "request.subtype") != "interrupt" {
+					t.Errorf("control request = %v, want interrupt", input)
+				}
+				requestID, _ := jsonPath(input, "request_id").(string)
+				cli.send(`{"type":"control_response","response":{"subtype":"success","request_id":"` + requestID + `"}}`)
+				cli.send(`{"type":"result","subtype":"error_during_execution","is_error":true,"num_turns":1,"session_id":"s1","total_cost_usd":0,"usage":{"input_tokens":1,"output_tokens":0}}`)
+			}
+		}
+	})
+	client := NewClientWithExecutor(executor)
+
+	session, err := client.StartSession(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	defer session.Close()
+
+	stream, err := session.Send(context.Background(), "long task")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	first := <-stream.Messages
+	if _, ok := first.Message.(*AssistantMessage); !ok {
+		t.Fatalf("first message = %+v, want assistant", first)
+	}
+
+	interrupted := make(chan error, 1)
+	go func() {
+		interrupted <- session.Interrupt(context.Background())
+	}()
+
+	messages, _ := drain(stream)
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages after interrupt, want the result", len(messages))
+	}
+	select {
+	case err := <-interrupted:
+		if err != nil {
+			t.Fatalf("Interrupt() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Interrupt() did not return after the turn finished")
+	}
+
+	// The process is still usable
+	stream, err = session.Send(context.Background(), "next")
+	if err != nil {
+		t.Fatalf("Send() after Interrupt error = %v", err)
+	}
+	stream.Close()
+}
+
+func TestSession_InterruptRejected(t *testing.T) {
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		for input := cli.read(); input != nil; input = cli.read() {
+			requestID, _ := jsonPath(input, "request_id").(string)
+			cli.send(`{"type":"control_response","response":{"subtype":"error","request_id":"` + requestID + `","error":"nothing to interrupt"}}`)
+		}
+	})
+	client := NewClientWithExecutor(executor)
+
+	session, err := client.StartSession(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	defer session.Close()
+
+	err = session.Interrupt(context.Background())
+	var controlErr *ControlError
+	if !errors.As(err, &controlErr) || controlErr.Request != "interrupt" || controlErr.Message != "nothing to interrupt" {
+		t.Errorf("Interrupt() error = %v, want ControlError", err)
+	}
+}
+
+func TestSession_ProcessExit(t *testing.T) {
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		cli.read()
+		cli.send(`{"type":"assistant","message":{"content":[]},"session_id":"s1"}`)
+		// Exit mid-turn without a result
+	})
+	client := NewClientWithExecutor(executor)
+
+	session, err := client.StartSession(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+
+	stream, err := session.Send(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	_, errs := drain(stream)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrSessionClosed) {
+		t.Errorf("turn errors = %v, want ErrSessionClosed", errs)
+	}
+	if err := session.Interrupt(context.Background()); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Interrupt() error = %v, want ErrSessionClosed", err)
+	}
+	if err := session.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestSession_NonInteractiveExecutor(t *testing.T) {
+	client := NewClientWithExecutor(struct{ CommandExecutor }{&MockCommandExecutor{}})
+
+	_, err := client.StartSession(context.Background(), nil)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Field != "executor" {
+		t.Errorf("StartSession() error = %v, want ConfigError for executor", err)
+	}
+}
+
+func TestSession_EnvMaskedInParseError(t *testing.T) {
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		cli.read()
+		cli.send("warning: key sk-ant-secret rejected")
+		cli.send(testResult)
+		cli.read()
+	})
+	client := NewClientWithExecutor(executor)
+
+	session, err := client.StartSession(context.Background(), &Options{Env: map[string]string{"ANTHROPIC_API_KEY": "sk-ant-secret"}})
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	defer session.Close()
+
+	stream, err := session.Send(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	_, errs := drain(stream)
+	if len(errs) != 1 {
+		t.Fatalf("turn errors = %v, want the parse error", errs)
+	}
+	if strings.Contains(errs[0].Error(), "sk-ant-secret") {
+		t.Errorf("turn error = %q, secret not masked", errs[0].Error())
+	}
+}
+
+func TestSession_RejectsTimeouts(t *testing.T) {
+	client := NewClientWithExecutor(newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		t.Error("CLI started despite unsupported options")
+	}))
+
+	_, err := client.StartSession(context.Background(), &Options{TurnTimeout: time.Minute})
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Field != "TurnTimeout" {
+		t.Errorf("StartSession() error = %v, want ConfigError for TurnTimeout", err)
+	}
+}
+
+func TestSession_SetPermissionModeAndModel(t *testing.T) {
+	requests := make(chan map[string]any, 3)
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		for input := cli.read(); input != nil; input = cli.read() {
+			requests <- input
+			requestID, _ := jsonPath(input, "request_id").(string)
+			switch jsonPath(input, "request.subtype") {
+			case "set_permission_mode":
+				cli.send(`{"type":"control_response","response":{"subtype":"success","request_id":"` + requestID + `","response":{"mode":"acceptEdits"}}}`)
+			default:
+				cli.send(`{"type":"control_response","response":{"subtype":"success","request_id":"` + requestID + `"}}`)
+			}
+		}
+	})
+	client := NewClientWithExecutor(executor)
+
+	session, err := client.StartSession(context.Background(), &Options{PermissionMode: PermissionPlan, Model: "claude-opus-4-1"})
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	defer session.Close()
+
+	modeAck, err := session.SetPermissionMode(context.Background(), PermissionAcceptEdits)
+	if err != nil {
+		t.Fatalf("SetPermissionMode() error = %v", err)
+	}
+	if modeAck.Mode != PermissionAcceptEdits || modeAck.Previous != PermissionPlan {
+		t.Errorf("SetPermissionMode() = %+v, want acceptEdits after plan", modeAck)
+	}
+	if got := session.PermissionMode(); got != PermissionAcceptEdits {
+		t.Errorf("PermissionMode() = %q, want acceptEdits", got)
+	}
+	if req := <-requests; jsonPath(req, "request.mode") != "acceptEdits" {
+		t.Errorf("set_permission_mode request = %v", req)
+	}
+
+	modelAck, err := session.SetModel(context.Background(), "claude-haiku-4-5")
+	if err != nil {
+		t.Fatalf("SetModel() error = %v", err)
+	}
+	if modelAck.Model != "claude-haiku-4-5" || modelAck.Previous != "claude-opus-4-1" {
+		t.Errorf("SetModel() = %+v", modelAck)
+	}
+	if req := <-requests; jsonPath(req, "request.subtype") != "set_model" || jsonPath(req, "request.model") != "claude-haiku-4-5" {
+		t.Errorf("set_model request = %v", req)
+	}
+
+	// An empty model resets to the default and omits the field
+	if _, err := session.SetModel(context.Background(), ""); err != nil {
+		t.Fatalf("SetModel(\"\") error = %v", err)
+	}
+	if req := <-requests; jsonPath(req, "request.model") != nil {
+		t.Errorf("set_model reset request = %v, want no model", req)
+	}
+	if got := session.Model(); got != "" {
+		t.Errorf("Model() = %q, want default", got)
+	}
+}
+
+func TestSession_SetPermissionMode_Invalid(t *testing.T) {
+	executor := newFakeCLIExecutor(t, func(cli *fakeCLI) {
+		for input := cli.read(); input != nil; input = cli.read() {
+			t.Errorf("unexpected request %v", input)
+		}
+	})
+	client := NewClientWithExecutor(executor)
+
+	session, err := client.StartSession(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	defer session.Close()
+
+	_, err = session.SetPermissionMode(context.Background(), "ask")
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Field != "PermissionMode" {
+		t.Errorf("SetPermissionMode() error = %v, want ConfigError for PermissionMode", err)
+	}
+	if got := session.PermissionMode(); got != PermissionDefault {
+		t.Errorf("PermissionMode() = %q, want default", got)
+	}
+}
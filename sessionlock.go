@@ -0,0 +1,192 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionLockPolicy controls what happens when a session is already in use
+type SessionLockPolicy int
+
+// SessionLockPolicy values
+const (
+	// SessionLockWait waits until the session is free, or the context is done
+	SessionLockWait SessionLockPolicy = iota
+	// SessionLockReject fails immediately with a *SessionBusyError
+	SessionLockReject
+)
+
+// fileLockPollInterval is how often a waiting caller retries a held file lock
+const fileLockPollInterval = 50 * time.Millisecond
+
+// errFileLockUnsupported is returned by tryLockFile on platforms without flock
+var errFileLockUnsupported = errors.New("cross-process locks are not supported on this platform")
+
+// sessionLockDirName is the directory under the Claude config dir holding lock files
+const sessionLockDirName = "session-locks"
+
+// sessionLocker serialises use of a session ID so that two CLI processes never
+// append to the same transcript at once. Locks are always taken in-process and
+// optionally also with a lock file shared by other processes.
+type sessionLocker struct {
+	policy SessionLockPolicy
+
+	// crossProcess enables file locks; lockDir overrides their location,
+	// which defaults to the Claude config dir
+	crossProcess bool
+	lockDir      string
+
+	mu    sync.Mutex
+	locks map[string]*sessionLockEntry
+}
+
+// sessionLockEntry is a reference-counted, context-aware mutex for one session
+type sessionLockEntry struct {
+	sem  chan struct{}
+	refs int
+}
+
+func newSessionLocker() *sessionLocker {
+	return &sessionLocker{locks: make(map[string]*sessionLockEntry)}
+}
+
+// lockFor acquires the lock for the session opts will resume, if any. The
+// returned release function must be called once the CLI process has exited.
+func (l *sessionLocker) lockFor(ctx context.Context, opts *Options) (func(), error) {
+	// Forking leaves the resumed transcript untouched, so it needs no lock
+	if opts.Resume == "" || opts.ForkSession {
+		return func() {}, nil
+	}
+	return l.acquire(ctx, opts.Resume, opts.Env)
+}
+
+// acquire locks sessionID according to the locker's policy
+func (l *sessionLocker) acquire(ctx context.Context, sessionID string, env map[string]string) (func(), error) {
+	entry := l.ref(sessionID)
+
+	if l.policy == SessionLockReject {
+		select {
+		case entry.sem <- struct{}{}:
+		default:
+			l.unref(sessionID)
+			return nil, &SessionBusyError{SessionID: sessionID}
+		}
+	} else {
+		select {
+		case entry.sem <- struct{}{}:
+		case <-ctx.Done():
+			l.unref(sessionID)
+			return nil, ctx.Err()
+		}
+	}
+
+	releaseMemory := func() {
+		<-entry.sem
+		l.unref(sessionID)
+	}
+
+	if !l.crossProcess {
+		return releaseMemory, nil
+	}
+
+	unlockFile, err := l.acquireFile(ctx, sessionID, env)
+	if err != nil {
+		releaseMemory()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		releaseMemory()
+	}, nil
+}
+
+// acquireFile takes the cross-process lock file for sessionID
+func (l *sessionLocker) acquireFile(ctx context.Context, sessionID string, env map[string]string) (func(), error) {
+	dir := l.lockDir
+	if dir == "" {
+		dir = filepath.Join(claudeConfigDir(env), sessionLockDirName)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, sanitizeLockName(sessionID)+".lock")
+
+	for {
+		unlock, err := tryLockFile(path)
+		if errors.Is(err, errFileLockUnsupported) {
+			// Fall back to the in-process lock already held
+			return func() {}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if unlock != nil {
+			return unlock, nil
+		}
+		if l.policy == SessionLockReject {
+			return nil, &SessionBusyError{SessionID: sessionID, CrossProcess: true}
+		}
+
+		select {
+		case <-time.After(fileLockPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *sessionLocker) ref(sessionID string) *sessionLockEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.locks[sessionID]
+	if !ok {
+		entry = &sessionLockEntry{sem: make(chan struct{}, 1)}
+		l.locks[sessionID] = entry
+	}
+	entry.refs++
+	return entry
+}
+
+func (l *sessionLocker) unref(sessionID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.locks[sessionID]
+	entry.refs--
+	if entry.refs == 0 {
+		delete(l.locks, sessionID)
+	}
+}
+
+// claudeConfigDir returns the Claude config directory the CLI will use,
+// honouring CLAUDE_CONFIG_DIR from env or the process environment
+func claudeConfigDir(env map[string]string) string {
+	if dir := env["CLAUDE_CONFIG_DIR"]; dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("CLAUDE_CONFIG_DIR"); dir != "" {
+		return dir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".claude")
+	}
+	return ".claude"
+}
+
+// sanitizeLockName makes a session ID safe to use as a file name
+func sanitizeLockName(sessionID string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, sessionID)
+}
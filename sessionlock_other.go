@@ -0,0 +1,8 @@
+//go:build !unix
+
+package claude
+
+// tryLockFile is unavailable on platforms without flock
+func tryLockFile(_ string) (func(), error) {
+	return nil, errFileLockUnsupported
+}
@@ -0,0 +1,201 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionLocker_Wait(t *testing.T) {
+	ctx := context.Background()
+	locker := newSessionLocker()
+
+	release, err := locker.acquire(ctx, "s1", nil)
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		second, err := locker.acquire(ctx, "s1", nil)
+		if err != nil {
+			t.Errorf("second acquire() error = %v", err)
+		}
+		acquired <- second
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second acquire() succeeded while the session was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Other sessions are independent
+	other, err := locker.acquire(ctx, "s2", nil)
+	if err != nil {
+		t.Fatalf("acquire() of other session error = %v", err)
+	}
+	other()
+
+	release()
+	select {
+	case second := <-acquired:
+		second()
+	case <-time.After(time.Second):
+		t.Fatal("second acquire() did not proceed after release")
+	}
+
+	if len(locker.locks) != 0 {
+		t.Errorf("locker retains %d entries after release", len(locker.locks))
+	}
+}
+
+func TestSessionLocker_WaitContextCancelled(t *testing.T) {
+	locker := newSessionLocker()
+	release, err := locker.acquire(context.Background(), "s1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := locker.acquire(ctx, "s1", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestSessionLocker_Reject(t *testing.T) {
+	ctx := context.Background()
+	locker := newSessionLocker()
+	locker.policy = SessionLockReject
+
+	release, err := locker.acquire(ctx, "s1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = locker.acquire(ctx, "s1", nil)
+	var busy *SessionBusyError
+	if !errors.As(err, &busy) || busy.SessionID != "s1" || busy.CrossProcess {
+		t.Fatalf("acquire() error = %v, want in-process *SessionBusyError", err)
+	}
+
+	release()
+	again, err := locker.acquire(ctx, "s1", nil)
+	if err != nil {
+		t.Fatalf("acquire() after release error = %v", err)
+	}
+	again()
+}
+
+func TestSessionLocker_LockFor(t *testing.T) {
+	ctx := context.Background()
+	locker := newSessionLocker()
+	locker.policy = SessionLockReject
+
+	for _, opts := range []*Options{{}, {Continue: true}, {Resume: "s1", ForkSession: true}} {
+		release, err := locker.lockFor(ctx, opts)
+		if err != nil {
+			t.Fatalf("lockFor(%+v) error = %v", opts, err)
+		}
+		if len(locker.locks) != 0 {
+			t.Errorf("lockFor(%+v) took a lock", opts)
+		}
+		release()
+	}
+}
+
+func TestSessionLocker_CrossProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("flock is not available on this platform")
+	}
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Two lockers stand in for two processes sharing the lock directory
+	first := newSessionLocker()
+	first.crossProcess = true
+	first.lockDir = dir
+	second := newSessionLocker()
+	second.crossProcess = true
+	second.lockDir = dir
+	second.policy = SessionLockReject
+
+	release, err := first.acquire(ctx, "session/../1", nil)
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	_, err = second.acquire(ctx, "session/../1", nil)
+	var busy *SessionBusyError
+	if !errors.As(err, &busy) || !busy.CrossProcess {
+		t.Fatalf("acquire() error = %v, want cross-process *SessionBusyError", err)
+	}
+
+	release()
+	again, err := second.acquire(ctx, "session/../1", nil)
+	if err != nil {
+		t.Fatalf("acquire() after release error = %v", err)
+	}
+	again()
+}
+
+func TestClaudeConfigDir(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", "/from/process")
+
+	if got := claudeConfigDir(map[string]string{"CLAUDE_CONFIG_DIR": "/from/options"}); got != "/from/options" {
+		t.Errorf("claudeConfigDir() = %q, want Options.Env value", got)
+	}
+	if got := claudeConfigDir(nil); got != "/from/process" {
+		t.Errorf("claudeConfigDir() = %q, want process environment value", got)
+	}
+}
+
+func TestClient_SessionLocking(t *testing.T) {
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	mockExecutor := &MockCommandExecutor{
+		ExecuteStreamFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) (io.ReadCloser, error) {
+			close(started)
+			return &mockReadCloser{
+				Reader: strings.NewReader(`{"type": "result", "session_id": "s1", "usage": {"input_tokens": 1, "output_tokens": 1}}`),
+				closeFunc: func() error {
+					<-finish
+					return nil
+				},
+			}, nil
+		},
+		ExecuteFunc: func(_ context.Context, _ string, _ []string, _ string, _ string, _ map[string]string) ([]byte, error) {
+			return []byte(`{"type": "result", "session_id": "s1", "usage": {"input_tokens": 1, "output_tokens": 1}}`), nil
+		},
+	}
+
+	client := NewClient(WithExecutor(mockExecutor), WithSessionLockPolicy(SessionLockReject))
+	stream, err := client.QueryStream(ctx, "first", &Options{Resume: "s1"})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	<-started
+
+	_, err = client.Query(ctx, "second", &Options{Resume: "s1"})
+	var busy *SessionBusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("Query() error = %v, want *SessionBusyError", err)
+	}
+
+	// The lock is released once the stream's process has exited
+	close(finish)
+	for range stream.Messages {
+	}
+	if _, err := client.Query(ctx, "third", &Options{Resume: "s1"}); err != nil {
+		t.Errorf("Query() after stream finished error = %v", err)
+	}
+}
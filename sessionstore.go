@@ -0,0 +1,320 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned by SessionStore.Get when no live record exists for a key
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionRecord maps an application key, such as a chat thread ID, to a Claude session
+type SessionRecord struct {
+	Key       string            `json:"key"`
+	SessionID string            `json:"session_id"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	// ExpiresAt is the time after which the record is ignored; zero means never
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// expired reports whether the record has expired at now
+func (r *SessionRecord) expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// SessionStore persists the mapping from application keys to Claude session IDs
+type SessionStore interface {
+	// Get returns the record for key, or ErrSessionNotFound if it is missing or expired
+	Get(ctx context.Context, key string) (*SessionRecord, error)
+	// Put stores record under record.Key. A positive ttl sets ExpiresAt;
+	// zero keeps the record until it is deleted.
+	Put(ctx context.Context, record *SessionRecord, ttl time.Duration) error
+	// Delete removes the record for key; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns all live records sorted by key
+	List(ctx context.Context) ([]*SessionRecord, error)
+}
+
+// Compile-time check that implementations satisfy the interface
+var (
+	_ SessionStore = (*MemorySessionStore)(nil)
+	_ SessionStore = (*FileSessionStore)(nil)
+)
+
+// MemorySessionStore is a SessionStore held in process memory
+type MemorySessionStore struct {
+	mu      sync.Mutex
+	records map[string]*SessionRecord
+	now     func() time.Time
+}
+
+// NewMemorySessionStore creates an empty in-memory SessionStore
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		records: make(map[string]*SessionRecord),
+		now:     time.Now,
+	}
+}
+
+// Get returns the record for key
+func (s *MemorySessionStore) Get(_ context.Context, key string) (*SessionRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if record.expired(s.now()) {
+		delete(s.records, key)
+		return nil, ErrSessionNotFound
+	}
+	return cloneSessionRecord(record), nil
+}
+
+// Put stores record with the given ttl
+func (s *MemorySessionStore) Put(_ context.Context, record *SessionRecord, ttl time.Duration) error {
+	if err := validateSessionRecord(record); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[record.Key] = stampSessionRecord(s.records[record.Key], record, ttl, s.now())
+	return nil
+}
+
+// Delete removes the record for key
+func (s *MemorySessionStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// List returns all live records
+func (s *MemorySessionStore) List(_ context.Context) ([]*SessionRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return liveSessionRecords(s.records, s.now()), nil
+}
+
+// FileSessionStore is a SessionStore persisted as a JSON file. The file is
+// re-read on every operation and replaced atomically, and updates hold an
+// flock on a ".lock" file next to it, so several processes can share the
+// file. On platforms without flock, updates are only serialized within the
+// process.
+type FileSessionStore struct {
+	path string
+	mu   sync.Mutex
+	now  func() time.Time
+}
+
+// NewFileSessionStore creates a SessionStore backed by the JSON file at path.
+// The file is created on the first Put.
+func NewFileSessionStore(path string) *FileSessionStore {
+	return &FileSessionStore{path: path, now: time.Now}
+}
+
+// Get returns the record for key
+func (s *FileSessionStore) Get(_ context.Context, key string) (*SessionRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	record, ok := records[key]
+	if !ok || record.expired(s.now()) {
+		return nil, ErrSessionNotFound
+	}
+	return record, nil
+}
+
+// Put stores record with the given ttl
+func (s *FileSessionStore) Put(ctx context.Context, record *SessionRecord, ttl time.Duration) error {
+	if err := validateSessionRecord(record); err != nil {
+		return err
+	}
+
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	records[record.Key] = stampSessionRecord(records[record.Key], record, ttl, now)
+	return s.save(records, now)
+}
+
+// Delete removes the record for key
+func (s *FileSessionStore) Delete(ctx context.Context, key string) error {
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := records[key]; !ok {
+		return nil
+	}
+	delete(records, key)
+	return s.save(records, s.now())
+}
+
+// List returns all live records
+func (s *FileSessionStore) List(_ context.Context) ([]*SessionRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return liveSessionRecords(records, s.now()), nil
+}
+
+// lock serializes updates to the file, across processes where flock is
+// available
+func (s *FileSessionStore) lock(ctx context.Context) (func(), error) {
+	s.mu.Lock()
+	path := s.path + ".lock"
+	for {
+		unlockFile, err := tryLockFile(path)
+		if errors.Is(err, errFileLockUnsupported) {
+			return s.mu.Unlock, nil
+		}
+		if err != nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("failed to lock session store %s: %w", s.path, err)
+		}
+		if unlockFile != nil {
+			return func() {
+				unlockFile()
+				s.mu.Unlock()
+			}, nil
+		}
+
+		select {
+		case <-time.After(fileLockPollInterval):
+		case <-ctx.Done():
+			s.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// load reads all records from the file; a missing file holds no records
+func (s *FileSessionStore) load() (map[string]*SessionRecord, error) {
+	records := make(map[string]*SessionRecord)
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return records, nil
+		}
+		return nil, fmt.Errorf("failed to read session store %s: %w", s.path, err)
+	}
+	if len(data) == 0 {
+		return records, nil
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse session store %s: %w", s.path, err)
+	}
+	return records, nil
+}
+
+// save writes the live records back to the file, dropping expired ones
+func (s *FileSessionStore) save(records map[string]*SessionRecord, now time.Time) error {
+	for key, record := range records {
+		if record.expired(now) {
+			delete(records, key)
+		}
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session store: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write session store %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// validateSessionRecord checks that a record can be stored
+func validateSessionRecord(record *SessionRecord) error {
+	if record == nil {
+		return &ConfigError{Field: "SessionRecord", Value: "nil", Reason: "record cannot be nil"}
+	}
+	if record.Key == "" {
+		return &ConfigError{Field: "SessionRecord.Key", Value: record.Key, Reason: "key is required"}
+	}
+	if record.SessionID == "" {
+		return &ConfigError{Field: "SessionRecord.SessionID", Value: record.SessionID, Reason: "session ID is required"}
+	}
+	return nil
+}
+
+// stampSessionRecord returns a copy of record with timestamps set. CreatedAt
+// is kept from the previous record for the same key when there is one.
+func stampSessionRecord(previous, record *SessionRecord, ttl time.Duration, now time.Time) *SessionRecord {
+	stored := cloneSessionRecord(record)
+	switch {
+	case previous != nil && !previous.expired(now):
+		stored.CreatedAt = previous.CreatedAt
+	case stored.CreatedAt.IsZero():
+		stored.CreatedAt = now
+	}
+	stored.UpdatedAt = now
+	stored.ExpiresAt = time.Time{}
+	if ttl > 0 {
+		stored.ExpiresAt = now.Add(ttl)
+	}
+	return stored
+}
+
+// liveSessionRecords returns copies of the unexpired records sorted by key
+func liveSessionRecords(records map[string]*SessionRecord, now time.Time) []*SessionRecord {
+	live := make([]*SessionRecord, 0, len(records))
+	for _, record := range records {
+		if !record.expired(now) {
+			live = append(live, cloneSessionRecord(record))
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].Key < live[j].Key })
+	return live
+}
+
+// cloneSessionRecord returns a deep copy of record
+func cloneSessionRecord(record *SessionRecord) *SessionRecord {
+	clone := *record
+	if record.Metadata != nil {
+		clone.Metadata = make(map[string]string, len(record.Metadata))
+		for k, v := range record.Metadata {
+			clone.Metadata[k] = v
+		}
+	}
+	return &clone
+}
@@ -0,0 +1,185 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a controllable time source for TTL tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestSessionStores(t *testing.T) {
+	stores := map[string]func(t *testing.T, clock *fakeClock) SessionStore{
+		"memory": func(_ *testing.T, clock *fakeClock) SessionStore {
+			store := NewMemorySessionStore()
+			store.now = clock.Now
+			return store
+		},
+		"file": func(t *testing.T, clock *fakeClock) SessionStore {
+			store := NewFileSessionStore(filepath.Join(t.TempDir(), "sessions.json"))
+			store.now = clock.Now
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+			store := newStore(t, clock)
+
+			if _, err := store.Get(ctx, "thread-1"); !errors.Is(err, ErrSessionNotFound) {
+				t.Fatalf("Get() on empty store error = %v, want ErrSessionNotFound", err)
+			}
+
+			err := store.Put(ctx, &SessionRecord{Key: "thread-1", SessionID: "s1", Metadata: map[string]string{"channel": "general"}}, time.Hour)
+			if err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if err := store.Put(ctx, &SessionRecord{Key: "thread-2", SessionID: "s2"}, 0); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			got, err := store.Get(ctx, "thread-1")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if got.SessionID != "s1" || got.Metadata["channel"] != "general" {
+				t.Errorf("Get() = %+v, want session s1 with metadata", got)
+			}
+			if !got.CreatedAt.Equal(clock.now) || !got.ExpiresAt.Equal(clock.now.Add(time.Hour)) {
+				t.Errorf("Get() timestamps = created %v expires %v", got.CreatedAt, got.ExpiresAt)
+			}
+
+			// Updating keeps CreatedAt and refreshes UpdatedAt
+			created := got.CreatedAt
+			clock.now = clock.now.Add(30 * time.Minute)
+			if err := store.Put(ctx, &SessionRecord{Key: "thread-1", SessionID: "s1b"}, time.Hour); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			got, _ = store.Get(ctx, "thread-1")
+			if got.SessionID != "s1b" || !got.CreatedAt.Equal(created) || !got.UpdatedAt.Equal(clock.now) {
+				t.Errorf("updated record = %+v", got)
+			}
+
+			// Returned records are copies
+			got.Metadata = map[string]string{"mutated": "yes"}
+			again, _ := store.Get(ctx, "thread-1")
+			if again.Metadata["mutated"] != "" {
+				t.Error("Get() returned a record aliasing store state")
+			}
+
+			records, err := store.List(ctx)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(records) != 2 || records[0].Key != "thread-1" || records[1].Key != "thread-2" {
+				t.Errorf("List() = %+v, want thread-1 and thread-2", records)
+			}
+
+			// Expiry hides the record
+			clock.now = clock.now.Add(2 * time.Hour)
+			if _, err := store.Get(ctx, "thread-1"); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("Get() after expiry error = %v, want ErrSessionNotFound", err)
+			}
+			records, _ = store.List(ctx)
+			if len(records) != 1 || records[0].Key != "thread-2" {
+				t.Errorf("List() after expiry = %+v, want only thread-2", records)
+			}
+
+			if err := store.Delete(ctx, "thread-2"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if err := store.Delete(ctx, "missing"); err != nil {
+				t.Errorf("Delete() of missing key error = %v", err)
+			}
+			if _, err := store.Get(ctx, "thread-2"); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("Get() after delete error = %v, want ErrSessionNotFound", err)
+			}
+
+			for _, invalid := range []*SessionRecord{nil, {SessionID: "s"}, {Key: "k"}} {
+				if _, ok := store.Put(ctx, invalid, 0).(*ConfigError); !ok {
+					t.Errorf("Put(%+v) should return *ConfigError", invalid)
+				}
+			}
+		})
+	}
+}
+
+func TestFileSessionStore_SharedFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	writer := NewFileSessionStore(path)
+	reader := NewFileSessionStore(path)
+
+	if err := writer.Put(ctx, &SessionRecord{Key: "k", SessionID: "s"}, 0); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	got, err := reader.Get(ctx, "k")
+	if err != nil || got.SessionID != "s" {
+		t.Fatalf("Get() from second store = %+v, %v", got, err)
+	}
+
+	if err := os.WriteFile(path, []byte("{invalid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Get(ctx, "k"); err == nil || errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get() on corrupt file error = %v, want parse error", err)
+	}
+}
+
+func TestFileSessionStore_ConcurrentStores(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	// Separate stores share nothing in memory, like separate processes
+	const n = 20
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record := &SessionRecord{Key: fmt.Sprintf("k%02d", i), SessionID: "s"}
+			if err := NewFileSessionStore(path).Put(ctx, record, 0); err != nil {
+				t.Errorf("Put() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	records, err := NewFileSessionStore(path).List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(records) != n {
+		t.Errorf("List() returned %d records, want %d", len(records), n)
+	}
+}
+
+func TestFileSessionStore_OmitsZeroExpiry(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	if err := NewFileSessionStore(path).Put(ctx, &SessionRecord{Key: "k", SessionID: "s"}, 0); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "expires_at") {
+		t.Errorf("store file = %s, want no expires_at for a record without ttl", data)
+	}
+}
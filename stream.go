@@ -3,8 +3,11 @@ package claude
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
 )
 
 // MessageStream represents a stream of messages from Claude
@@ -12,21 +15,79 @@ type MessageStream struct {
 	Messages <-chan MessageOrError
 	ctx      context.Context
 	cancel   context.CancelFunc
+
+	// closed is closed by Close so that pending sends can be abandoned
+	closed     <-chan struct{}
+	markClosed func()
+
+	// control answers permission requests in bidirectional mode
+	control *controlChannel
+
+	// spend estimates the cost of the messages delivered so far
+	spend *spendTracker
+
+	// current is the stream this one forwards, such as the running
+	// attempt of a model chain; Respond is passed on to it
+	current atomic.Pointer[MessageStream]
+
+	// fan broadcasts Messages to subscribers once Subscribe is used
+	fanMu sync.Mutex
+	fan   *fanout
 }
 
-// MessageOrError wraps a Message or an error
+// MessageOrError wraps a Message or an error. A ResultMessage reporting a
+// failed run carries both the message and its typed error.
 type MessageOrError struct {
 	Message Message
 	Err     error
 }
 
-// Close cancels the stream
+// Close cancels the stream. If the stream has subscribers, the CLI is kept
+// running until every required subscriber has finished.
 func (s *MessageStream) Close() {
+	s.fanMu.Lock()
+	fan := s.fan
+	s.fanMu.Unlock()
+	if fan != nil {
+		fan.ownerClose()
+		return
+	}
+	s.closeNow()
+}
+
+// closeNow cancels the stream immediately
+func (s *MessageStream) closeNow() {
+	if s.markClosed != nil {
+		s.markClosed()
+	}
 	if s.cancel != nil {
 		s.cancel()
 	}
 }
 
+// Respond answers the PermissionRequestMessage with the given request ID.
+// It requires Options.PermissionPromptToolName to be PermissionPromptToolStdio.
+// Requests not answered within Options.PermissionTimeout are denied.
+func (s *MessageStream) Respond(requestID string, decision PermissionDecision) error {
+	if current := s.current.Load(); current != nil {
+		return current.Respond(requestID, decision)
+	}
+	if s.control == nil {
+		return errNotInteractive
+	}
+	return s.control.respond(requestID, decision)
+}
+
+// Spend returns the estimated cost of the messages received so far, priced
+// with the client's PricingRegistry. It is safe to call while the stream is
+// being read.
+func (s *MessageStream) Spend() Spend {
+	if s.spend == nil {
+		return Spend{}
+	}
+	return s.spend.spend()
+}
+
 // QueryStream executes a Claude Code query and returns a channel of messages
 func (c *clientImpl) QueryStream(ctx context.Context, prompt string, opts *Options) (*MessageStream, error) {
 	if prompt == "" {
@@ -35,82 +96,257 @@ func (c *clientImpl) QueryStream(ctx context.Context, prompt string, opts *Optio
 			Message: "prompt is required",
 		}
 	}
+	return c.queryStream(ctx, promptInput{text: prompt}, opts)
+}
 
-	if opts == nil {
-		opts = &Options{}
+// QueryStreamPrompt executes a query with a multi-part prompt and returns a channel of messages
+func (c *clientImpl) QueryStreamPrompt(ctx context.Context, prompt *Prompt, opts *Options) (*MessageStream, error) {
+	input, err := newPromptInput(prompt)
+	if err != nil {
+		return nil, err
 	}
+	return c.queryStream(ctx, input, opts)
+}
 
-	// Validate options
-	if err := c.builder.Validate(opts); err != nil {
+// queryStream starts a streaming query for input
+func (c *clientImpl) queryStream(ctx context.Context, input promptInput, opts *Options) (*MessageStream, error) {
+	opts, err := c.resolveOptions(opts)
+	if err != nil {
 		return nil, err
 	}
+	if len(opts.ModelChain) > 0 {
+		return c.streamChain(ctx, input, opts)
+	}
+	return c.streamResolved(ctx, input, opts, newSpendTracker(c.pricing))
+}
 
-	// Set defaults
-	executable := opts.PathToClaudeCodeExecutable
-	if executable == "" {
-		executable = "claude"
+// streamResolved starts a streaming query with resolved options, estimating
+// its cost in spend
+func (c *clientImpl) streamResolved(ctx context.Context, input promptInput, opts *Options, spend *spendTracker) (*MessageStream, error) {
+	reservation, err := c.spend.check(ctx, opts.Labels, opts.Model, input.text)
+	if err != nil {
+		return nil, err
 	}
+	executable := c.executableFor(opts)
 
-	// Build arguments
-	args := append([]string{"--print", "--output-format", "stream-json", "--verbose"}, c.builder.BuildArgs(opts)...)
+	// Serialise use of the resumed session until the stream ends
+	release, err := c.locks.lockFor(ctx, opts)
+	if err != nil {
+		reservation.release()
+		return nil, err
+	}
 
 	// Create context for cancellation
 	streamCtx, cancel := context.WithCancel(ctx)
 
 	// Execute command with streaming
-	stream, err := c.executor.ExecuteStream(streamCtx, executable, args, prompt, opts.WorkingDir)
+	stream, control, err := c.startStream(streamCtx, executable, input, opts)
 	if err != nil {
 		cancel()
-		if processErr, ok := err.(*ProcessError); ok {
-			return nil, processErr
-		}
-		return nil, fmt.Errorf("failed to execute command: %w", err)
+		release()
+		reservation.release()
+		return nil, err
 	}
 
 	// Create message channel
 	messages := make(chan MessageOrError)
+	additionalDirs := resolveAdditionalDirectories(opts.WorkingDir, opts.AdditionalDirectories)
+	subagents := newSubagentTracker()
+
+	closed := make(chan struct{})
+	markClosed := sync.OnceFunc(func() { close(closed) })
+
+	// The watchdog kills the process; closing its output unblocks the reader
+	closeStream := sync.OnceValue(stream.Close)
+	watchdog := newStreamWatchdog(opts.IdleTimeout, opts.TurnTimeout, func() {
+		cancel()
+		closeStream()
+	})
 
 	// Start goroutine to read messages
 	go func() {
 		defer close(messages)
-		defer stream.Close()
-		defer cancel()
-
-		scanner := bufio.NewScanner(stream)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line == "" {
-				continue
+		defer release()
+		defer reservation.release()
+		defer watchdog.stop()
+		if control != nil {
+			defer control.closeInput()
+		}
+
+		// send delivers item unless the stream is cancelled first
+		send := func(item MessageOrError) bool {
+			watchdog.setSending(true)
+			defer watchdog.setSending(false)
+			select {
+			case messages <- item:
+				return true
+			case <-streamCtx.Done():
+				return false
 			}
+		}
 
-			msg, err := c.parser.ParseMessage(line)
-			if err != nil {
-				select {
-				case messages <- MessageOrError{Err: err}:
-				case <-streamCtx.Done():
-					return
+		sawResult := false
+		readErr := func() error {
+			scanner := bufio.NewScanner(stream)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if line == "" {
+					continue
+				}
+				msg, consumed, err := control.handleControlLine(line)
+				if !consumed {
+					msg, err = c.parser.ParseMessage(line)
+				}
+				if err != nil {
+					return maskParseError(err, opts.Env)
+				}
+				if msg == nil {
+					continue
+				}
+
+				// Report the directories passed to the CLI on the init message
+				if initMsg, ok := msg.(*InitMessage); ok && initMsg.AdditionalDirectories == nil {
+					initMsg.AdditionalDirectories = additionalDirs
+				}
+				subagents.observe(msg)
+				spend.observe(msg)
+				watchdog.observe(msg)
+
+				item := MessageOrError{Message: msg}
+				switch m := msg.(type) {
+				case *PermissionRequestMessage:
+					if control != nil && m.RequestID != "" {
+						control.track(m)
+					}
+				case *ResultMessage:
+					item.Err = resultError(m)
+					m.Model = opts.Model
+					if err := c.spend.record(streamCtx, opts.Labels, opts.Model, m); err != nil {
+						item.Err = errors.Join(item.Err, err)
+					}
+					sawResult = true
+					if control != nil {
+						// The turn is over; let the CLI exit
+						_ = control.closeInput()
+					}
+				}
+				if !send(item) {
+					return nil
 				}
-				return
 			}
+			if err := scanner.Err(); err != nil && err != io.EOF && streamCtx.Err() == nil {
+				return fmt.Errorf("error reading stream: %w", err)
+			}
+			return nil
+		}()
 
+		if readErr != nil {
+			send(MessageOrError{Err: readErr})
+			cancel()
+			closeStream()
+			return
+		}
+
+		closeErr := closeStream()
+		if timeoutErr := watchdog.err(); timeoutErr != nil {
 			select {
-			case messages <- MessageOrError{Message: msg}:
-			case <-streamCtx.Done():
-				return
+			case messages <- MessageOrError{Err: timeoutErr}:
+			case <-closed:
+			}
+			cancel()
+			return
+		}
+		if streamCtx.Err() != nil {
+			// Report the cancellation unless the caller has closed the stream
+			// and is no longer reading
+			select {
+			case messages <- MessageOrError{Err: &AbortError{Message: "stream aborted", Err: streamCtx.Err()}}:
+			case <-closed:
 			}
+			cancel()
+			return
 		}
+		cancel()
 
-		if err := scanner.Err(); err != nil && err != io.EOF {
+		// A process that exits without a result, such as one that failed
+		// authentication, is only visible through its exit status
+		if closeErr != nil && !sawResult {
 			select {
-			case messages <- MessageOrError{Err: fmt.Errorf("error reading stream: %w", err)}:
-			case <-streamCtx.Done():
+			case messages <- MessageOrError{Err: wrapExecError(closeErr, opts.Env)}:
+			case <-closed:
 			}
 		}
 	}()
 
 	return &MessageStream{
-		Messages: messages,
-		ctx:      streamCtx,
-		cancel:   cancel,
+		Messages:   messages,
+		ctx:        streamCtx,
+		cancel:     cancel,
+		closed:     closed,
+		markClosed: markClosed,
+		control:    control,
+		spend:      spend,
 	}, nil
 }
+
+// startStream starts the CLI for a streaming query. When permission requests
+// are routed over stdio or the prompt has attachments, the CLI runs in
+// stream-json input mode and the prompt is written to its stdin; the returned
+// control channel answers permission requests.
+func (c *clientImpl) startStream(ctx context.Context, executable string, input promptInput, opts *Options) (io.ReadCloser, *controlChannel, error) {
+	if input.blocks == nil && opts.PermissionPromptToolName != PermissionPromptToolStdio {
+		args := append([]string{"--print", "--output-format", "stream-json", "--verbose"}, c.builder.BuildArgs(opts)...)
+		stream, err := c.executor.ExecuteStream(ctx, executable, args, input.text, opts.WorkingDir, opts.Env)
+		if err != nil {
+			return nil, nil, wrapExecError(err, opts.Env)
+		}
+		return stream, nil, nil
+	}
+
+	interactive, ok := c.executor.(InteractiveCommandExecutor)
+	if !ok {
+		if input.blocks != nil {
+			return nil, nil, &ConfigError{
+				Field:  "prompt",
+				Reason: "attachments require an executor implementing InteractiveCommandExecutor",
+			}
+		}
+		return nil, nil, &ConfigError{
+			Field:  "PermissionPromptToolName",
+			Value:  opts.PermissionPromptToolName,
+			Reason: "the client's executor does not implement InteractiveCommandExecutor",
+		}
+	}
+
+	stdin, stream, err := interactive.ExecuteInteractive(ctx, executable, c.interactiveArgs(opts), opts.WorkingDir, opts.Env)
+	if err != nil {
+		return nil, nil, wrapExecError(err, opts.Env)
+	}
+
+	control := newControlChannel(stdin, opts.PermissionTimeout)
+	if err := control.sendUserMessage(input.content(), ""); err != nil {
+		control.closeInput()
+		stream.Close()
+		return nil, nil, err
+	}
+	return stream, control, nil
+}
+
+// interactiveArgs returns the CLI arguments for stream-json input mode
+func (c *clientImpl) interactiveArgs(opts *Options) []string {
+	return append([]string{"--output-format", "stream-json", "--input-format", "stream-json", "--verbose"}, c.builder.BuildArgs(opts)...)
+}
+
+// collectResult drains stream and returns its ResultMessage with the result's
+// typed error, or the first error that ended the stream
+func collectResult(stream *MessageStream) (*ResultMessage, error) {
+	for msgOrErr := range stream.Messages {
+		if result, ok := msgOrErr.Message.(*ResultMessage); ok {
+			return result, msgOrErr.Err
+		}
+		if msgOrErr.Err != nil {
+			return nil, msgOrErr.Err
+		}
+	}
+	return nil, errors.New("stream ended without a result")
+}
@@ -0,0 +1,63 @@
+package claude
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// charsPerToken is the average number of characters in a word-like token
+const charsPerToken = 4
+
+// EstimateTokens estimates the number of tokens Claude's tokenizer produces
+// for text, without network access. Words count one token per four
+// characters, each punctuation mark and line break counts one token, and
+// characters outside Latin scripts such as CJK count one token each. The
+// estimate is meant for budgeting and is usually within 20% for English
+// prose and source code.
+func EstimateTokens(text string) int {
+	tokens := 0
+	word := 0   // length of the current word run
+	spaces := 0 // length of the current run of spaces and tabs
+	flush := func() {
+		if word > 0 {
+			tokens += (word + charsPerToken - 1) / charsPerToken
+			word = 0
+		}
+		// A single space joins the next word's token; indentation does not
+		if spaces > 1 {
+			tokens += (spaces + charsPerToken - 1) / charsPerToken
+		}
+		spaces = 0
+	}
+
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		text = text[size:]
+		switch {
+		case r == ' ' || r == '\t':
+			if word > 0 {
+				flush()
+			}
+			spaces++
+		case r == '\n' || r == '\r':
+			flush()
+			tokens++
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'):
+			if spaces > 0 {
+				flush()
+			}
+			word++
+		case unicode.IsLetter(r) && (unicode.Is(unicode.Latin, r) || unicode.Is(unicode.Greek, r) || unicode.Is(unicode.Cyrillic, r)):
+			if spaces > 0 {
+				flush()
+			}
+			// Accented letters take more bytes and split words more often
+			word += 2
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
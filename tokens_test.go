@@ -0,0 +1,39 @@
+package claude
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "empty", text: "", want: 0},
+		{name: "short word", text: "hi", want: 1},
+		{name: "long word", text: "tokenization", want: 3},
+		{name: "sentence", text: "The quick brown fox.", want: 7},
+		{name: "newlines", text: "a\n\nb", want: 4},
+		{name: "indentation", text: "        return", want: 4},
+		{name: "code", text: "func main() {}", want: 6},
+		{name: "cjk", text: "日本語", want: 3},
+		{name: "accented", text: "café", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateTokens(tt.text); got != tt.want {
+				t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateTokens_Proportional(t *testing.T) {
+	text := "Read the file and summarise the changes.\n"
+	one := EstimateTokens(text)
+	if got := EstimateTokens(strings.Repeat(text, 10)); got != 10*one {
+		t.Errorf("EstimateTokens(10x) = %d, want %d", got, 10*one)
+	}
+}
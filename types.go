@@ -1,6 +1,11 @@
 package claude
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+)
 
 // PermissionMode represents how the SDK handles permissions for tool use
 type PermissionMode string
@@ -17,40 +22,109 @@ const (
 	PermissionPlan PermissionMode = "plan"
 )
 
-// Options configures the behavior of Claude Code SDK
+// SettingSource identifies a settings file location the CLI may load
+type SettingSource string
+
+// SettingSource constants select which settings files the CLI loads
+const (
+	// SettingSourceUser loads ~/.claude/settings.json
+	SettingSourceUser SettingSource = "user"
+	// SettingSourceProject loads .claude/settings.json in the project
+	SettingSourceProject SettingSource = "project"
+	// SettingSourceLocal loads .claude/settings.local.json in the project
+	SettingSourceLocal SettingSource = "local"
+)
+
+// PermissionPromptToolStdio, set as Options.PermissionPromptToolName, routes
+// permission requests to the caller over the stream instead of an MCP tool
+const PermissionPromptToolStdio = "stdio"
+
+// DefaultPermissionTimeout is how long a permission request waits for a response
+const DefaultPermissionTimeout = 5 * time.Minute
+
+// Options configures the behavior of Claude Code SDK.
+// Options marshals to stable JSON; MCPServers are encoded in .mcp.json form.
 type Options struct {
 	// Tools configuration
-	AllowedTools    []string
-	DisallowedTools []string
+	AllowedTools    []string `json:"allowed_tools,omitempty"`
+	DisallowedTools []string `json:"disallowed_tools,omitempty"`
 
 	// System prompt configuration
-	CustomSystemPrompt string
-	AppendSystemPrompt string
+	CustomSystemPrompt string `json:"custom_system_prompt,omitempty"`
+	AppendSystemPrompt string `json:"append_system_prompt,omitempty"`
 
 	// Working directory for the Claude Code CLI
-	WorkingDir string
+	WorkingDir string `json:"working_dir,omitempty"`
+
+	// Additional directories Claude may access besides WorkingDir.
+	// Relative paths are resolved against WorkingDir.
+	AdditionalDirectories []string `json:"additional_directories,omitempty"`
+
+	// Environment variables for the CLI process, merged over the inherited
+	// environment. Values of secret-looking keys are masked in errors.
+	Env map[string]string `json:"env,omitempty"`
 
 	// Token and turn limits
-	MaxThinkingTokens *int
-	MaxTurns          *int
+	MaxThinkingTokens *int `json:"max_thinking_tokens,omitempty"`
+	MaxTurns          *int `json:"max_turns,omitempty"`
 
 	// MCP server configuration
-	MCPServers map[string]MCPServerConfig
+	MCPServers map[string]MCPServerConfig `json:"-"`
 
 	// Path to the Claude Code CLI executable
-	PathToClaudeCodeExecutable string // Default: "claude"
+	PathToClaudeCodeExecutable string `json:"path_to_claude_code_executable,omitempty"` // Default: "claude"
 
 	// Permission handling
-	PermissionMode           PermissionMode
-	PermissionPromptToolName string
+	PermissionMode           PermissionMode `json:"permission_mode,omitempty"`
+	PermissionPromptToolName string         `json:"permission_prompt_tool_name,omitempty"`
+	// PermissionTimeout bounds how long a permission request waits for
+	// MessageStream.Respond before it is denied; zero uses DefaultPermissionTimeout
+	PermissionTimeout time.Duration `json:"permission_timeout,omitempty"`
+
+	// Stream watchdog. IdleTimeout stops a query when no message arrives
+	// for that long; TurnTimeout stops it when one turn, from the prompt or
+	// the latest tool results to the next, takes longer. Time spent waiting
+	// for a permission request to be answered counts toward neither. Either
+	// kills the CLI and reports a *TimeoutError. Zero disables the limit.
+	// Sessions do not support them, so StartSession rejects Options that
+	// set them.
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
+	TurnTimeout time.Duration `json:"turn_timeout,omitempty"`
 
 	// Session continuation
-	Continue bool
-	Resume   string
+	Continue bool   `json:"continue,omitempty"`
+	Resume   string `json:"resume,omitempty"`
+	// ForkSession starts a new session ID when resuming instead of appending
+	// to the resumed session
+	ForkSession bool `json:"fork_session,omitempty"`
 
 	// Model configuration
-	Model         string
-	FallbackModel string
+	Model         string `json:"model,omitempty"`
+	FallbackModel string `json:"fallback_model,omitempty"`
+	// ModelChain lists models to try in order. When a query fails because
+	// the model is overloaded, rate limited or invalid, the client retries
+	// it on the next model, resuming the failed run's session if it has
+	// one. Model must be empty or equal to the first entry.
+	ModelChain []string `json:"model_chain,omitempty"`
+
+	// Custom subagents keyed by name
+	Agents map[string]AgentDefinition `json:"agents,omitempty"`
+
+	// Settings is inline JSON or a path to a settings file for --settings
+	Settings string `json:"settings,omitempty"`
+
+	// SettingSources selects which settings files load. Nil uses the CLI
+	// default; an empty non-nil slice loads none. It is never omitted from
+	// JSON so that the two cases round-trip.
+	SettingSources []SettingSource `json:"setting_sources"`
+
+	// ExtraArgs passes arbitrary CLI flags. Keys are flag names with or
+	// without the leading "--"; a nil value emits a boolean flag.
+	ExtraArgs map[string]*string `json:"extra_args,omitempty"`
+
+	// Labels tag the query's cost in the client's Ledger and select the
+	// quotas it is subject to, e.g. {"team": "search", "job": "nightly"}
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // MCPServerConfig is an interface for MCP server configurations
@@ -114,6 +188,10 @@ type UserMessage struct {
 	Message         json.RawMessage `json:"message"`
 	ParentToolUseID *string         `json:"parent_tool_use_id"`
 	SessionID       string          `json:"session_id"`
+
+	// Subagent names the subagent that produced this message, if any.
+	// It is set by the client while streaming.
+	Subagent string `json:"-"`
 }
 
 func (UserMessage) messageType() string { return "user" }
@@ -124,14 +202,27 @@ type AssistantMessage struct {
 	Message         json.RawMessage `json:"message"`
 	ParentToolUseID *string         `json:"parent_tool_use_id"`
 	SessionID       string          `json:"session_id"`
+
+	// Subagent names the subagent that produced this message, if any.
+	// It is set by the client while streaming.
+	Subagent string `json:"-"`
 }
 
 func (AssistantMessage) messageType() string { return "assistant" }
 
+// ResultMessage subtypes reported by the CLI
+const (
+	ResultSubtypeSuccess              = "success"
+	ResultSubtypeErrorMaxTurns        = "error_max_turns"
+	ResultSubtypeErrorDuringExecution = "error_during_execution"
+	ResultSubtypeErrorMaxBudget       = "error_max_budget_usd"
+)
+
 // ResultMessage represents the final result of a Claude Code session
 type ResultMessage struct {
 	Type          string  `json:"type"`
 	Subtype       string  `json:"subtype"`
+	UUID          string  `json:"uuid,omitempty"`
 	DurationMS    int64   `json:"duration_ms"`
 	DurationAPIMS int64   `json:"duration_api_ms"`
 	IsError       bool    `json:"is_error"`
@@ -140,40 +231,330 @@ type ResultMessage struct {
 	SessionID     string  `json:"session_id"`
 	TotalCostUSD  float64 `json:"total_cost_usd"`
 	Usage         Usage   `json:"usage"`
+
+	// ModelUsage breaks usage and cost down by model name
+	ModelUsage map[string]ModelUsage `json:"modelUsage,omitempty"`
+	// PermissionDenials lists the tool uses that were refused during the run
+	PermissionDenials []PermissionDenial `json:"permission_denials,omitempty"`
+	// StructuredOutput holds the validated output when a JSON schema was requested
+	StructuredOutput json.RawMessage `json:"structured_output,omitempty"`
+
+	// Extra holds fields reported by the CLI that are not modelled above
+	Extra map[string]json.RawMessage `json:"-"`
+
+	// Model is the model the client requested for the run that produced
+	// this result; empty means the CLI default. It is set by the client.
+	Model string `json:"-"`
+	// ModelAttempts lists the earlier models of Options.ModelChain that
+	// failed before Model answered. It is set by the client.
+	ModelAttempts []ModelAttempt `json:"-"`
+}
+
+// resultMessageFields are the JSON keys decoded into ResultMessage fields
+var resultMessageFields = jsonFieldNames(reflect.TypeOf(resultMessageAlias{}))
+
+// resultMessageAlias has the fields of ResultMessage without its JSON methods
+type resultMessageAlias ResultMessage
+
+// UnmarshalJSON decodes the message, keeping unknown fields in Extra
+func (m *ResultMessage) UnmarshalJSON(data []byte) error {
+	var alias resultMessageAlias
+	extra, err := unmarshalWithExtra(data, &alias, resultMessageFields)
+	if err != nil {
+		return err
+	}
+	*m = ResultMessage(alias)
+	m.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the message, including the fields held in Extra
+func (m ResultMessage) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(resultMessageAlias(m), m.Extra)
+}
+
+// CostByModel returns the cost in USD of each model used in the run
+func (m *ResultMessage) CostByModel() map[string]float64 {
+	costs := make(map[string]float64, len(m.ModelUsage))
+	for model, usage := range m.ModelUsage {
+		costs[model] = usage.CostUSD
+	}
+	return costs
+}
+
+// DeniedTools returns the names of the tools that were denied, without
+// duplicates, in the order they were first denied
+func (m *ResultMessage) DeniedTools() []string {
+	var tools []string
+	seen := make(map[string]bool, len(m.PermissionDenials))
+	for _, denial := range m.PermissionDenials {
+		if !seen[denial.ToolName] {
+			seen[denial.ToolName] = true
+			tools = append(tools, denial.ToolName)
+		}
+	}
+	return tools
+}
+
+// DecodeStructuredOutput unmarshals the structured output into v
+func (m *ResultMessage) DecodeStructuredOutput(v any) error {
+	if len(m.StructuredOutput) == 0 {
+		return errors.New("result has no structured output")
+	}
+	return json.Unmarshal(m.StructuredOutput, v)
+}
+
+// ModelUsage is the usage and cost attributed to one model in a run
+type ModelUsage struct {
+	InputTokens              int     `json:"inputTokens"`
+	OutputTokens             int     `json:"outputTokens"`
+	CacheReadInputTokens     int     `json:"cacheReadInputTokens"`
+	CacheCreationInputTokens int     `json:"cacheCreationInputTokens"`
+	WebSearchRequests        int     `json:"webSearchRequests"`
+	CostUSD                  float64 `json:"costUSD"`
+	ContextWindow            int     `json:"contextWindow,omitempty"`
+}
+
+// PermissionDenial describes a tool use that was refused
+type PermissionDenial struct {
+	ToolName  string          `json:"tool_name"`
+	ToolUseID string          `json:"tool_use_id"`
+	ToolInput json.RawMessage `json:"tool_input,omitempty"`
 }
 
 func (ResultMessage) messageType() string { return "result" }
 
-// SystemMessage represents system information from Claude Code CLI
+// SystemMessage subtypes reported by the CLI
+const (
+	SystemSubtypeInit            = "init"
+	SystemSubtypeCompactBoundary = "compact_boundary"
+	SystemSubtypeHookResponse    = "hook_response"
+	SystemSubtypeStatus          = "status"
+)
+
+// SystemMessage is a system event whose subtype has no dedicated type. Known
+// subtypes are parsed into InitMessage, CompactBoundaryMessage,
+// HookResponseMessage and StatusMessage instead.
 type SystemMessage struct {
-	Type           string            `json:"type"`
-	Subtype        string            `json:"subtype"`
-	APIKeySource   string            `json:"apiKeySource"`
-	CWD            string            `json:"cwd"`
-	SessionID      string            `json:"session_id"`
-	Tools          []string          `json:"tools"`
-	MCPServers     []MCPServerStatus `json:"mcp_servers"`
-	Model          string            `json:"model"`
-	PermissionMode string            `json:"permissionMode"`
+	Type      string `json:"type"`
+	Subtype   string `json:"subtype"`
+	UUID      string `json:"uuid,omitempty"`
+	SessionID string `json:"session_id"`
+
+	// Extra holds the subtype-specific payload
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 func (SystemMessage) messageType() string { return "system" }
 
-// PermissionRequestMessage represents a permission request for tool use
+// systemMessageFields are the JSON keys decoded into SystemMessage fields
+var systemMessageFields = jsonFieldNames(reflect.TypeOf(systemMessageAlias{}))
+
+// systemMessageAlias has the fields of SystemMessage without its JSON methods
+type systemMessageAlias SystemMessage
+
+// UnmarshalJSON decodes the message, keeping the payload in Extra
+func (m *SystemMessage) UnmarshalJSON(data []byte) error {
+	var alias systemMessageAlias
+	extra, err := unmarshalWithExtra(data, &alias, systemMessageFields)
+	if err != nil {
+		return err
+	}
+	*m = SystemMessage(alias)
+	m.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the message, including the payload held in Extra
+func (m SystemMessage) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(systemMessageAlias(m), m.Extra)
+}
+
+// InitMessage is the system message sent when a session starts
+type InitMessage struct {
+	Type              string            `json:"type"`
+	Subtype           string            `json:"subtype"`
+	UUID              string            `json:"uuid,omitempty"`
+	APIKeySource      string            `json:"apiKeySource"`
+	CWD               string            `json:"cwd"`
+	SessionID         string            `json:"session_id"`
+	Tools             []string          `json:"tools"`
+	MCPServers        []MCPServerStatus `json:"mcp_servers"`
+	Model             string            `json:"model"`
+	PermissionMode    string            `json:"permissionMode"`
+	SlashCommands     []string          `json:"slash_commands,omitempty"`
+	OutputStyle       string            `json:"output_style,omitempty"`
+	Agents            []string          `json:"agents,omitempty"`
+	ClaudeCodeVersion string            `json:"claude_code_version,omitempty"`
+
+	// AdditionalDirectories lists the directories passed with --add-dir.
+	// The client fills it from Options when the CLI does not report it.
+	AdditionalDirectories []string `json:"additional_directories,omitempty"`
+}
+
+func (InitMessage) messageType() string { return "system" }
+
+// Directories returns the effective directory set: the working directory
+// followed by any additional directories
+func (m *InitMessage) Directories() []string {
+	dirs := make([]string, 0, len(m.AdditionalDirectories)+1)
+	if m.CWD != "" {
+		dirs = append(dirs, m.CWD)
+	}
+	return append(dirs, m.AdditionalDirectories...)
+}
+
+// CompactBoundaryMessage marks the point where the conversation was compacted
+type CompactBoundaryMessage struct {
+	Type            string          `json:"type"`
+	Subtype         string          `json:"subtype"`
+	UUID            string          `json:"uuid,omitempty"`
+	SessionID       string          `json:"session_id"`
+	CompactMetadata CompactMetadata `json:"compact_metadata"`
+}
+
+func (CompactBoundaryMessage) messageType() string { return "system" }
+
+// CompactMetadata describes a compaction
+type CompactMetadata struct {
+	// Trigger is "manual" for /compact or "auto" when the context filled up
+	Trigger string `json:"trigger"`
+	// PreTokens is the context size before compaction
+	PreTokens int `json:"pre_tokens"`
+}
+
+// HookResponseMessage reports the output of a hook run by the CLI
+type HookResponseMessage struct {
+	Type      string `json:"type"`
+	Subtype   string `json:"subtype"`
+	UUID      string `json:"uuid,omitempty"`
+	SessionID string `json:"session_id"`
+	HookName  string `json:"hook_name"`
+	HookEvent string `json:"hook_event"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+}
+
+func (HookResponseMessage) messageType() string { return "system" }
+
+// StatusMessage reports a change in what the CLI is doing, such as compacting.
+// Status is empty when the CLI returns to normal operation.
+type StatusMessage struct {
+	Type      string `json:"type"`
+	Subtype   string `json:"subtype"`
+	UUID      string `json:"uuid,omitempty"`
+	SessionID string `json:"session_id"`
+	Status    string `json:"status"`
+}
+
+func (StatusMessage) messageType() string { return "system" }
+
+// PermissionRequestMessage represents a permission request for tool use.
+// In bidirectional mode (see PermissionPromptToolStdio) it is decoded from the
+// CLI's can_use_tool control request and answered with MessageStream.Respond.
 type PermissionRequestMessage struct {
 	Type      string `json:"type"`
 	SessionID string `json:"session_id"`
 	Subtype   string `json:"subtype"`
+
+	// RequestID identifies the request when responding
+	RequestID string `json:"request_id,omitempty"`
+	// ToolName and Input describe the tool use awaiting approval
+	ToolName string          `json:"tool_name,omitempty"`
+	Input    json.RawMessage `json:"input,omitempty"`
+	// ToolUseID is the ID of the tool_use block being approved
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	// Suggestions are permission updates the CLI proposes, such as an
+	// "always allow" rule, that can be returned in the decision
+	Suggestions []PermissionUpdate `json:"permission_suggestions,omitempty"`
+	// BlockedPath is the path outside the allowed directories that triggered the request
+	BlockedPath string `json:"blocked_path,omitempty"`
 }
 
 func (PermissionRequestMessage) messageType() string { return "permission_request" }
 
+// PermissionBehavior is the outcome of a permission decision
+type PermissionBehavior string
+
+// PermissionBehavior constants
+const (
+	PermissionBehaviorAllow PermissionBehavior = "allow"
+	PermissionBehaviorDeny  PermissionBehavior = "deny"
+)
+
+// PermissionDecision answers a PermissionRequestMessage
+type PermissionDecision struct {
+	Behavior PermissionBehavior `json:"behavior"`
+	// UpdatedInput replaces the tool input when allowing; nil keeps the original
+	UpdatedInput json.RawMessage `json:"updatedInput,omitempty"`
+	// UpdatedPermissions applies permission updates when allowing, typically
+	// taken from the request's Suggestions
+	UpdatedPermissions []PermissionUpdate `json:"updatedPermissions,omitempty"`
+	// Message tells Claude why the tool use was denied
+	Message string `json:"message,omitempty"`
+	// Interrupt stops the current turn when denying
+	Interrupt bool `json:"interrupt,omitempty"`
+}
+
+// PermissionUpdate changes permission rules, modes or directories
+type PermissionUpdate struct {
+	Type        string           `json:"type"`
+	Rules       []PermissionRule `json:"rules,omitempty"`
+	Behavior    string           `json:"behavior,omitempty"`
+	Mode        PermissionMode   `json:"mode,omitempty"`
+	Directories []string         `json:"directories,omitempty"`
+	// Destination is where the update is saved, such as "session" or "localSettings"
+	Destination string `json:"destination,omitempty"`
+}
+
+// PermissionRule matches tool uses by tool name and optional rule content
+type PermissionRule struct {
+	ToolName    string `json:"toolName"`
+	RuleContent string `json:"ruleContent,omitempty"`
+}
+
 // Usage represents token usage information
 type Usage struct {
 	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
 	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 	InputTokens              int `json:"input_tokens"`
 	OutputTokens             int `json:"output_tokens"`
+
+	// ServerToolUse counts requests to server-side tools such as web search
+	ServerToolUse *ServerToolUse `json:"server_tool_use,omitempty"`
+	// ServiceTier is the API service tier that handled the requests
+	ServiceTier string `json:"service_tier,omitempty"`
+}
+
+// ServerToolUse counts requests made to server-side tools
+type ServerToolUse struct {
+	WebSearchRequests int `json:"web_search_requests"`
+	WebFetchRequests  int `json:"web_fetch_requests,omitempty"`
+}
+
+// Add returns the sum of u and other. ServiceTier is taken from other when set.
+func (u Usage) Add(other Usage) Usage {
+	sum := Usage{
+		CacheCreationInputTokens: u.CacheCreationInputTokens + other.CacheCreationInputTokens,
+		CacheReadInputTokens:     u.CacheReadInputTokens + other.CacheReadInputTokens,
+		InputTokens:              u.InputTokens + other.InputTokens,
+		OutputTokens:             u.OutputTokens + other.OutputTokens,
+		ServiceTier:              u.ServiceTier,
+	}
+	if other.ServiceTier != "" {
+		sum.ServiceTier = other.ServiceTier
+	}
+	if u.ServerToolUse != nil || other.ServerToolUse != nil {
+		sum.ServerToolUse = &ServerToolUse{}
+		for _, s := range []*ServerToolUse{u.ServerToolUse, other.ServerToolUse} {
+			if s != nil {
+				sum.ServerToolUse.WebSearchRequests += s.WebSearchRequests
+				sum.ServerToolUse.WebFetchRequests += s.WebFetchRequests
+			}
+		}
+	}
+	return sum
 }
 
 // MCPServerStatus represents the status of an MCP server
@@ -2,6 +2,7 @@ package claude
 
 import (
 	"encoding/json"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -327,6 +328,36 @@ func TestUsage(t *testing.T) {
 	}
 }
 
+func TestUsage_Add(t *testing.T) {
+	a := Usage{InputTokens: 1, OutputTokens: 2, CacheReadInputTokens: 3, ServiceTier: "standard"}
+	b := Usage{InputTokens: 10, OutputTokens: 20, CacheCreationInputTokens: 4, ServerToolUse: &ServerToolUse{WebSearchRequests: 1, WebFetchRequests: 2}}
+
+	got := a.Add(b)
+	want := Usage{
+		InputTokens:              11,
+		OutputTokens:             22,
+		CacheReadInputTokens:     3,
+		CacheCreationInputTokens: 4,
+		ServerToolUse:            &ServerToolUse{WebSearchRequests: 1, WebFetchRequests: 2},
+		ServiceTier:              "standard",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Add() = %+v, want %+v", got, want)
+	}
+
+	got = got.Add(Usage{ServerToolUse: &ServerToolUse{WebSearchRequests: 1}, ServiceTier: "priority"})
+	if got.ServerToolUse.WebSearchRequests != 2 || got.ServiceTier != "priority" {
+		t.Errorf("Add() = %+v, want 2 web searches on the priority tier", got)
+	}
+	if b.ServerToolUse.WebSearchRequests != 1 {
+		t.Error("Add() modified its argument")
+	}
+
+	if got := (Usage{}).Add(Usage{}); got.ServerToolUse != nil {
+		t.Errorf("Add() ServerToolUse = %+v, want nil", got.ServerToolUse)
+	}
+}
+
 func TestMCPServerStatus(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -0,0 +1,222 @@
+package claude
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTimeout matches a *TimeoutError
+var ErrTimeout = errors.New("stream timed out")
+
+// TimeoutLimit names the limit that ended a stream
+type TimeoutLimit string
+
+const (
+	// TimeoutIdle is Options.IdleTimeout
+	TimeoutIdle TimeoutLimit = "idle"
+	// TimeoutTurn is Options.TurnTimeout
+	TimeoutTurn TimeoutLimit = "turn"
+)
+
+// TimeoutError is returned when a stream is stopped by Options.IdleTimeout
+// or Options.TurnTimeout. The CLI process is killed.
+type TimeoutError struct {
+	Limit   TimeoutLimit
+	Timeout time.Duration
+	// LastMessage is the last message received before the limit fired, or
+	// nil if there was none
+	LastMessage Message
+}
+
+func (e *TimeoutError) Error() string {
+	last := "none"
+	if e.LastMessage != nil {
+		last = e.LastMessage.messageType()
+	}
+	if e.Limit == TimeoutTurn {
+		return fmt.Sprintf("turn exceeded %s (last message: %s)", e.Timeout, last)
+	}
+	return fmt.Sprintf("no message received for %s (last message: %s)", e.Timeout, last)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return ErrTimeout
+}
+
+// streamWatchdog enforces the idle and turn timeouts of a stream. A turn
+// starts with the stream and again with each user message, which carries
+// tool results back to Claude. Both timers are paused while a permission
+// request waits for an answer.
+type streamWatchdog struct {
+	idle      time.Duration
+	turn      time.Duration
+	onTimeout func()
+
+	mu         sync.Mutex
+	idleTimer  *time.Timer
+	turnTimer  *time.Timer
+	idleGen    int
+	turnGen    int
+	idlePaused bool
+	turnPaused bool
+	turnEnds   time.Time
+	turnLeft   time.Duration
+	sending    bool
+	last       Message
+	fired      *TimeoutError
+	stopped    bool
+}
+
+// newStreamWatchdog starts a watchdog that calls onTimeout once when a limit
+// fires. It returns nil, which watches nothing, when both limits are zero.
+func newStreamWatchdog(idle, turn time.Duration, onTimeout func()) *streamWatchdog {
+	if idle <= 0 && turn <= 0 {
+		return nil
+	}
+	w := &streamWatchdog{idle: idle, turn: turn, onTimeout: onTimeout}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.armIdle()
+	w.armTurn()
+	return w
+}
+
+// observe records a received message and restarts the timers it affects
+func (w *streamWatchdog) observe(msg Message) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.last = msg
+	switch msg.(type) {
+	case *UserMessage:
+		w.armTurn()
+	case *ResultMessage:
+		// The run is over; only the process exit remains
+		w.stopLocked()
+		return
+	}
+	// The CLI waits silently while a permission request is answered, and a
+	// human taking their time should not end the turn
+	_, waiting := msg.(*PermissionRequestMessage)
+	if waiting {
+		w.pauseTurn()
+	} else if w.turnPaused {
+		w.startTurn(w.turnLeft)
+	}
+	w.idlePaused = waiting
+	w.armIdle()
+}
+
+// setSending pauses the idle timer while a message waits for the consumer,
+// since the CLI's output is not being read
+func (w *streamWatchdog) setSending(sending bool) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sending = sending
+	w.armIdle()
+}
+
+// err returns the timeout that fired, if any
+func (w *streamWatchdog) err() *TimeoutError {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.fired
+}
+
+// stop disarms the watchdog
+func (w *streamWatchdog) stop() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopLocked()
+}
+
+func (w *streamWatchdog) stopLocked() {
+	w.stopped = true
+	if w.idleTimer != nil {
+		w.idleTimer.Stop()
+	}
+	if w.turnTimer != nil {
+		w.turnTimer.Stop()
+	}
+}
+
+// armIdle restarts the idle timer unless it is paused
+func (w *streamWatchdog) armIdle() {
+	if w.idle <= 0 || w.stopped {
+		return
+	}
+	w.idleGen++
+	if w.idleTimer != nil {
+		w.idleTimer.Stop()
+	}
+	if w.idlePaused || w.sending {
+		return
+	}
+	gen := w.idleGen
+	w.idleTimer = time.AfterFunc(w.idle, func() { w.fire(TimeoutIdle, gen) })
+}
+
+// armTurn restarts the turn timer
+func (w *streamWatchdog) armTurn() {
+	w.startTurn(w.turn)
+}
+
+// startTurn runs the turn timer for the d left in the turn
+func (w *streamWatchdog) startTurn(d time.Duration) {
+	if w.turn <= 0 || w.stopped {
+		return
+	}
+	w.turnGen++
+	if w.turnTimer != nil {
+		w.turnTimer.Stop()
+	}
+	w.turnPaused = false
+	w.turnEnds = time.Now().Add(d)
+	gen := w.turnGen
+	w.turnTimer = time.AfterFunc(d, func() { w.fire(TimeoutTurn, gen) })
+}
+
+// pauseTurn stops the turn timer, keeping the time left in the turn
+func (w *streamWatchdog) pauseTurn() {
+	if w.turn <= 0 || w.stopped || w.turnPaused {
+		return
+	}
+	w.turnGen++
+	w.turnTimer.Stop()
+	w.turnPaused = true
+	w.turnLeft = time.Until(w.turnEnds)
+}
+
+// fire reports a timeout unless its timer has since been restarted
+func (w *streamWatchdog) fire(limit TimeoutLimit, gen int) {
+	w.mu.Lock()
+	current := w.turnGen
+	timeout := w.turn
+	if limit == TimeoutIdle {
+		current = w.idleGen
+		timeout = w.idle
+	}
+	if w.stopped || gen != current {
+		w.mu.Unlock()
+		return
+	}
+	w.fired = &TimeoutError{Limit: limit, Timeout: timeout, LastMessage: w.last}
+	w.stopLocked()
+	w.mu.Unlock()
+
+	w.onTimeout()
+}
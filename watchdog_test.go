@@ -0,0 +1,202 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeExecutor streams whatever write sends until the reader is closed; it
+// ignores ctx like a CLI that does not exit when signalled
+func pipeExecutor(write func(w *io.PipeWriter)) *MockCommandExecutor {
+	return &MockCommandExecutor{
+		ExecuteStreamFunc: func(context.Context, string, []string, string, string, map[string]string) (io.ReadCloser, error) {
+			r, w := io.Pipe()
+			go write(w)
+			return r, nil
+		},
+	}
+}
+
+// writeLines writes lines with interval between them and reports whether the
+// reader is still open
+func writeLines(w *io.PipeWriter, interval time.Duration, lines ...string) bool {
+	for _, line := range lines {
+		if _, err := w.Write([]byte(line + "\n")); err != nil {
+			return false
+		}
+		time.Sleep(interval)
+	}
+	return true
+}
+
+const (
+	testInitLine      = `{"type":"system","subtype":"init","session_id":"s1"}`
+	testAssistantLine = `{"type":"assistant","message":{"content":[]},"session_id":"s1"}`
+	testUserLine      = `{"type":"user","message":{"content":[]},"session_id":"s1"}`
+)
+
+func streamTimeout(t *testing.T, executor CommandExecutor, opts *Options) (*TimeoutError, []Message) {
+	t.Helper()
+	stream, err := NewClientWithExecutor(executor).QueryStream(context.Background(), "hi", opts)
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	messages, errs := drain(stream)
+	if len(errs) == 0 {
+		return nil, messages
+	}
+	var timeoutErr *TimeoutError
+	if len(errs) != 1 || !errors.As(errs[0], &timeoutErr) {
+		t.Fatalf("stream errors = %v, want a single *TimeoutError", errs)
+	}
+	return timeoutErr, messages
+}
+
+func TestQueryStream_IdleTimeout(t *testing.T) {
+	executor := pipeExecutor(func(w *io.PipeWriter) {
+		writeLines(w, 0, testInitLine)
+		// Hang like a stuck MCP server
+	})
+
+	timeoutErr, messages := streamTimeout(t, executor, &Options{IdleTimeout: 50 * time.Millisecond})
+	if timeoutErr == nil {
+		t.Fatal("stream ended without a timeout")
+	}
+	if timeoutErr.Limit != TimeoutIdle || timeoutErr.Timeout != 50*time.Millisecond {
+		t.Errorf("TimeoutError = %+v, want idle limit of 50ms", timeoutErr)
+	}
+	if _, ok := timeoutErr.LastMessage.(*InitMessage); !ok {
+		t.Errorf("LastMessage = %T, want *InitMessage", timeoutErr.LastMessage)
+	}
+	if !errors.Is(timeoutErr, ErrTimeout) {
+		t.Error("errors.Is(TimeoutError, ErrTimeout) = false")
+	}
+	if !strings.Contains(timeoutErr.Error(), "no message received for 50ms (last message: system)") {
+		t.Errorf("Error() = %q", timeoutErr.Error())
+	}
+	if len(messages) != 1 {
+		t.Errorf("messages = %d, want 1", len(messages))
+	}
+}
+
+func TestQueryStream_TurnTimeout(t *testing.T) {
+	executor := pipeExecutor(func(w *io.PipeWriter) {
+		// Steady output keeps the idle timer happy but the turn never ends
+		for writeLines(w, 5*time.Millisecond, testAssistantLine) {
+		}
+	})
+
+	timeoutErr, _ := streamTimeout(t, executor, &Options{IdleTimeout: time.Second, TurnTimeout: 60 * time.Millisecond})
+	if timeoutErr == nil {
+		t.Fatal("stream ended without a timeout")
+	}
+	if timeoutErr.Limit != TimeoutTurn {
+		t.Errorf("Limit = %q, want turn", timeoutErr.Limit)
+	}
+	if _, ok := timeoutErr.LastMessage.(*AssistantMessage); !ok {
+		t.Errorf("LastMessage = %T, want *AssistantMessage", timeoutErr.LastMessage)
+	}
+}
+
+func TestQueryStream_TimeoutsNotExceeded(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *Options
+		write   func(w *io.PipeWriter)
+		consume time.Duration
+	}{
+		{
+			name: "tool results start new turns",
+			opts: &Options{TurnTimeout: 60 * time.Millisecond},
+			write: func(w *io.PipeWriter) {
+				for i := 0; i < 4; i++ {
+					writeLines(w, 20*time.Millisecond, testAssistantLine, testUserLine)
+				}
+				writeLines(w, 0, testResult)
+				w.Close()
+			},
+		},
+		{
+			name: "slow consumer",
+			opts: &Options{IdleTimeout: 20 * time.Millisecond},
+			write: func(w *io.PipeWriter) {
+				writeLines(w, 0, testInitLine, testAssistantLine, testResult)
+				w.Close()
+			},
+			consume: 60 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := NewClientWithExecutor(pipeExecutor(tt.write)).QueryStream(context.Background(), "hi", tt.opts)
+			if err != nil {
+				t.Fatalf("QueryStream() error = %v", err)
+			}
+			for item := range stream.Messages {
+				if item.Err != nil {
+					t.Errorf("stream error = %v", item.Err)
+				}
+				time.Sleep(tt.consume)
+			}
+		})
+	}
+}
+
+func TestClient_QueryIdleTimeout(t *testing.T) {
+	executor := pipeExecutor(func(w *io.PipeWriter) {
+		writeLines(w, 0, testInitLine)
+	})
+	client := NewClientWithExecutor(executor)
+
+	_, err := client.Query(context.Background(), "hi", &Options{IdleTimeout: 30 * time.Millisecond})
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Limit != TimeoutIdle {
+		t.Errorf("Query() error = %v, want idle *TimeoutError", err)
+	}
+}
+
+func TestStreamWatchdog_PermissionPausesTurn(t *testing.T) {
+	timedOut := make(chan struct{})
+	w := newStreamWatchdog(0, 100*time.Millisecond, func() { close(timedOut) })
+	defer w.stop()
+
+	w.observe(&AssistantMessage{})
+	time.Sleep(50 * time.Millisecond)
+	w.observe(&PermissionRequestMessage{})
+	w.observe(&PermissionRequestMessage{})
+
+	// A slow human answering does not use up the turn
+	select {
+	case <-timedOut:
+		t.Fatalf("turn timed out while a permission request waited: %v", w.err())
+	case <-time.After(250 * time.Millisecond):
+	}
+
+	// The turn resumes with the time it had left
+	w.observe(&AssistantMessage{})
+	select {
+	case <-timedOut:
+	case <-time.After(time.Second):
+		t.Fatal("turn did not time out after the permission request was answered")
+	}
+	if err := w.err(); err == nil || err.Limit != TimeoutTurn {
+		t.Errorf("err() = %v, want turn timeout", err)
+	}
+}
+
+func TestStreamWatchdog_Nil(t *testing.T) {
+	if w := newStreamWatchdog(0, 0, func() { t.Error("onTimeout called") }); w != nil {
+		t.Fatalf("newStreamWatchdog(0, 0) = %v, want nil", w)
+	}
+	var w *streamWatchdog
+	w.observe(&InitMessage{})
+	w.setSending(true)
+	w.stop()
+	if err := w.err(); err != nil {
+		t.Errorf("err() = %v, want nil", err)
+	}
+}